@@ -17,7 +17,7 @@ var configYamlContent []byte
 var runFn = run
 
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer cancel()
 	runFn(ctx)
 }
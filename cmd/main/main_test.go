@@ -30,3 +30,20 @@ func Test_Main_WhenSigtermSignal_WillGracefullyShutdown(t *testing.T) {
 	require.NotPanics(t, main)
 	require.Nilf(t, sendSignalError, "failed to send signal: %v", sendSignalError)
 }
+
+func Test_Main_WhenSigintSignal_WillGracefullyShutdown(t *testing.T) {
+	runFn = func(ctx context.Context) {
+		<-ctx.Done()
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		p, err := os.FindProcess(os.Getpid())
+		if err != nil {
+			return
+		}
+		_ = p.Signal(syscall.SIGINT)
+	}()
+
+	require.NotPanics(t, main)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"mailculator-processor/internal/outbox"
+)
+
+// Prints the outbox state machine as a diagram, e.g. `go run ./cmd/statemachine -format graphviz | dot -Tpng`.
+func main() {
+	format := flag.String("format", "mermaid", "diagram format: mermaid or graphviz")
+	flag.Parse()
+
+	switch *format {
+	case "mermaid":
+		fmt.Print(outbox.Mermaid())
+	case "graphviz":
+		fmt.Print(outbox.Graphviz())
+	default:
+		log.Fatalf("unknown format %q", *format)
+	}
+}
@@ -0,0 +1,16 @@
+ALTER TABLE emails MODIFY status ENUM(
+    'ACCEPTED','INTAKING','READY','PROCESSING',
+    'SENT','FAILED','INVALID',
+    'CALLING-SENT-CALLBACK','CALLING-FAILED-CALLBACK',
+    'SENT-ACKNOWLEDGED','FAILED-ACKNOWLEDGED',
+    'QUARANTINED','SEND-UNCERTAIN'
+) NOT NULL;
+
+CREATE TABLE IF NOT EXISTS email_send_markers (
+    idempotency_key CHAR(36) PRIMARY KEY,
+    email_id CHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    INDEX idx_email_id (email_id),
+    FOREIGN KEY (email_id) REFERENCES emails(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
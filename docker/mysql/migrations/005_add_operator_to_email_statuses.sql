@@ -0,0 +1,11 @@
+ALTER TABLE emails MODIFY status ENUM(
+    'ACCEPTED','INTAKING','READY','PROCESSING',
+    'SENT','FAILED','INVALID',
+    'CALLING-SENT-CALLBACK','CALLING-FAILED-CALLBACK',
+    'SENT-ACKNOWLEDGED','FAILED-ACKNOWLEDGED',
+    'QUARANTINED','SEND-UNCERTAIN','CANCELLED'
+) NOT NULL;
+
+ALTER TABLE email_statuses ADD COLUMN operator VARCHAR(255) NULL AFTER reason;
+
+ALTER TABLE email_statuses_archive ADD COLUMN operator VARCHAR(255) NULL AFTER reason;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS ingestion_keys (
+    idempotency_key VARCHAR(255) PRIMARY KEY,
+    email_id CHAR(36) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    INDEX idx_email_id (email_id),
+    FOREIGN KEY (email_id) REFERENCES emails(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+ALTER TABLE emails MODIFY status ENUM(
+    'ACCEPTED','INTAKING','READY','PROCESSING',
+    'SENT','FAILED','INVALID',
+    'CALLING-SENT-CALLBACK','CALLING-FAILED-CALLBACK',
+    'SENT-ACKNOWLEDGED','FAILED-ACKNOWLEDGED',
+    'QUARANTINED','SEND-UNCERTAIN','CANCELLED',
+    'CALLING-CANCELLED-CALLBACK','CANCELLED-ACKNOWLEDGED',
+    'CALLBACK-FAILED'
+) NOT NULL;
+
+ALTER TABLE emails
+    ADD COLUMN callback_attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN next_attempt_at TIMESTAMP NULL DEFAULT NULL;
@@ -0,0 +1,7 @@
+ALTER TABLE emails ADD COLUMN failure JSON NULL DEFAULT NULL AFTER reason;
+
+ALTER TABLE email_statuses ADD COLUMN failure JSON NULL DEFAULT NULL AFTER reason;
+
+ALTER TABLE emails_archive ADD COLUMN failure JSON NULL DEFAULT NULL AFTER reason;
+
+ALTER TABLE email_statuses_archive ADD COLUMN failure JSON NULL DEFAULT NULL AFTER reason;
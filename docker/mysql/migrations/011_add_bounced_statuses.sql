@@ -0,0 +1,11 @@
+ALTER TABLE emails MODIFY status ENUM(
+    'ACCEPTED','INTAKING','READY','PROCESSING',
+    'SENT','FAILED','INVALID',
+    'CALLING-SENT-CALLBACK','CALLING-FAILED-CALLBACK',
+    'SENT-ACKNOWLEDGED','FAILED-ACKNOWLEDGED',
+    'QUARANTINED','SEND-UNCERTAIN','CANCELLED',
+    'CALLING-CANCELLED-CALLBACK','CANCELLED-ACKNOWLEDGED',
+    'CALLBACK-FAILED',
+    'CALLING-INVALID-CALLBACK','INVALID-ACKNOWLEDGED',
+    'BOUNCED','CALLING-BOUNCED-CALLBACK','BOUNCED-ACKNOWLEDGED'
+) NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE emails ADD COLUMN return_path VARCHAR(320) NULL DEFAULT NULL AFTER payload_file_path,
+    ADD INDEX idx_return_path (return_path);
+
+ALTER TABLE emails_archive ADD COLUMN return_path VARCHAR(320) NULL DEFAULT NULL AFTER payload_file_path;
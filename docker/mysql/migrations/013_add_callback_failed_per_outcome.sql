@@ -0,0 +1,39 @@
+ALTER TABLE emails MODIFY status ENUM(
+    'ACCEPTED','INTAKING','READY','PROCESSING',
+    'SENT','FAILED','INVALID',
+    'CALLING-SENT-CALLBACK','CALLING-FAILED-CALLBACK',
+    'SENT-ACKNOWLEDGED','FAILED-ACKNOWLEDGED',
+    'QUARANTINED','SEND-UNCERTAIN','CANCELLED',
+    'CALLING-CANCELLED-CALLBACK','CANCELLED-ACKNOWLEDGED',
+    'CALLBACK-FAILED',
+    'CALLING-INVALID-CALLBACK','INVALID-ACKNOWLEDGED',
+    'BOUNCED','CALLING-BOUNCED-CALLBACK','BOUNCED-ACKNOWLEDGED',
+    'SENT-CALLBACK-FAILED','FAILED-CALLBACK-FAILED','CANCELLED-CALLBACK-FAILED',
+    'INVALID-CALLBACK-FAILED','BOUNCED-CALLBACK-FAILED'
+) NOT NULL;
+
+-- CALLBACK-FAILED is split per outcome: the outcome is the callback that was given up, the last CALLING-*-CALLBACK in the history
+UPDATE emails e
+SET e.status = CONCAT(
+    SUBSTRING_INDEX(SUBSTRING((
+        SELECT s.status FROM email_statuses s
+        WHERE s.email_id = e.id AND s.status LIKE 'CALLING-%-CALLBACK'
+        ORDER BY s.id DESC
+        LIMIT 1
+    ), 9), '-CALLBACK', 1),
+    '-CALLBACK-FAILED'
+)
+WHERE e.status = 'CALLBACK-FAILED';
+
+ALTER TABLE emails MODIFY status ENUM(
+    'ACCEPTED','INTAKING','READY','PROCESSING',
+    'SENT','FAILED','INVALID',
+    'CALLING-SENT-CALLBACK','CALLING-FAILED-CALLBACK',
+    'SENT-ACKNOWLEDGED','FAILED-ACKNOWLEDGED',
+    'QUARANTINED','SEND-UNCERTAIN','CANCELLED',
+    'CALLING-CANCELLED-CALLBACK','CANCELLED-ACKNOWLEDGED',
+    'CALLING-INVALID-CALLBACK','INVALID-ACKNOWLEDGED',
+    'BOUNCED','CALLING-BOUNCED-CALLBACK','BOUNCED-ACKNOWLEDGED',
+    'SENT-CALLBACK-FAILED','FAILED-CALLBACK-FAILED','CANCELLED-CALLBACK-FAILED',
+    'INVALID-CALLBACK-FAILED','BOUNCED-CALLBACK-FAILED'
+) NOT NULL;
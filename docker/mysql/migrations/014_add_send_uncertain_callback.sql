@@ -0,0 +1,13 @@
+ALTER TABLE emails MODIFY status ENUM(
+    'ACCEPTED','INTAKING','READY','PROCESSING',
+    'SENT','FAILED','INVALID',
+    'CALLING-SENT-CALLBACK','CALLING-FAILED-CALLBACK',
+    'SENT-ACKNOWLEDGED','FAILED-ACKNOWLEDGED',
+    'QUARANTINED','SEND-UNCERTAIN','CANCELLED',
+    'CALLING-CANCELLED-CALLBACK','CANCELLED-ACKNOWLEDGED',
+    'CALLING-INVALID-CALLBACK','INVALID-ACKNOWLEDGED',
+    'BOUNCED','CALLING-BOUNCED-CALLBACK','BOUNCED-ACKNOWLEDGED',
+    'SENT-CALLBACK-FAILED','FAILED-CALLBACK-FAILED','CANCELLED-CALLBACK-FAILED',
+    'INVALID-CALLBACK-FAILED','BOUNCED-CALLBACK-FAILED',
+    'CALLING-SEND-UNCERTAIN-CALLBACK','SEND-UNCERTAIN-ACKNOWLEDGED','SEND-UNCERTAIN-CALLBACK-FAILED'
+) NOT NULL;
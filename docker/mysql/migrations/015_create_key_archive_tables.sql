@@ -0,0 +1,22 @@
+-- Send markers and ingestion keys outlive the emails removed by retention, so a payload id or an ingestion key
+-- cannot be used again and a late bounce can still be correlated. No foreign key: purged emails keep them too.
+CREATE TABLE IF NOT EXISTS email_send_markers_archive (
+    idempotency_key CHAR(36) PRIMARY KEY,
+    email_id CHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    INDEX idx_email_id (email_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS ingestion_keys_archive (
+    idempotency_key VARCHAR(255) PRIMARY KEY,
+    email_id CHAR(36) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    INDEX idx_email_id (email_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE emails_archive ADD INDEX idx_return_path (return_path);
@@ -0,0 +1,293 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailculator-processor/internal/failure"
+	"mailculator-processor/internal/outbox"
+)
+
+type outboxService interface {
+	Get(ctx context.Context, id string) (outbox.Email, error)
+	History(ctx context.Context, id string) ([]outbox.StatusChange, error)
+	List(ctx context.Context, filter outbox.ListFilter) (outbox.ListPage, error)
+	ApplyOperatorAction(ctx context.Context, id string, action string, operator string, reason string) (outbox.Email, error)
+}
+
+// Operator is an identity allowed to use the admin API with its bearer token.
+type Operator struct {
+	Name  string
+	Token string
+}
+
+type operatorContextKey struct{}
+
+type emailResponse struct {
+	Id              string                 `json:"id"`
+	Status          string                 `json:"status"`
+	PayloadFilePath string                 `json:"payload_file_path"`
+	Reason          string                 `json:"reason"`
+	Failure         *failure.Reason        `json:"failure,omitempty"`
+	Version         int                    `json:"version"`
+	UpdatedAt       string                 `json:"updated_at"`
+	History         []statusChangeResponse `json:"history,omitempty"`
+}
+
+type statusChangeResponse struct {
+	Status    string          `json:"status"`
+	Reason    string          `json:"reason"`
+	Failure   *failure.Reason `json:"failure,omitempty"`
+	Operator  string          `json:"operator,omitempty"`
+	CreatedAt string          `json:"created_at"`
+}
+
+type listResponse struct {
+	Emails     []emailResponse `json:"emails"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type actionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type Server struct {
+	port      int
+	outbox    outboxService
+	operators []Operator
+	logger    *slog.Logger
+}
+
+func NewServer(port int, outbox outboxService, operators []Operator) *Server {
+	return &Server{
+		port:      port,
+		outbox:    outbox,
+		operators: operators,
+		logger:    slog.With("server", "admin"),
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/emails", s.handleList)
+	mux.HandleFunc("GET /v1/emails/{id}", s.handleGet)
+	mux.HandleFunc("POST /v1/emails/{id}/requeue", s.handleAction(outbox.ActionRequeue))
+	mux.HandleFunc("POST /v1/emails/{id}/cancel", s.handleAction(outbox.ActionCancel))
+	mux.HandleFunc("POST /v1/emails/{id}/acknowledge", s.handleAction(outbox.ActionAcknowledge))
+	mux.HandleFunc("POST /v1/emails/{id}/mark-sent", s.handleAction(outbox.ActionMarkSent))
+	mux.HandleFunc("POST /v1/emails/{id}/fail", s.handleAction(outbox.ActionFail))
+
+	return s.authenticate(mux)
+}
+
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	baseContextFunc := func(_ net.Listener) context.Context {
+		return ctx
+	}
+
+	srv := &http.Server{
+		Addr:        fmt.Sprintf(":%d", s.port),
+		BaseContext: baseContextFunc,
+		Handler:     s.Handler(),
+	}
+
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+
+	<-ctx.Done()
+
+	ctxShutDown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer func() {
+		cancel()
+	}()
+
+	if err := srv.Shutdown(ctxShutDown); err != nil {
+		return fmt.Errorf("admin server shutdown failed:%v", err)
+	}
+
+	return nil
+}
+
+// authenticate resolves the bearer token to an operator and stores its name in the request context.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || token == "" {
+			writeError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+
+		operator := ""
+		for _, o := range s.operators {
+			if o.Token != "" && subtle.ConstantTimeCompare([]byte(o.Token), []byte(token)) == 1 {
+				operator = o.Name
+			}
+		}
+
+		if operator == "" {
+			writeError(w, http.StatusUnauthorized, "invalid bearer token")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), operatorContextKey{}, operator)))
+	})
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	e, err := s.outbox.Get(r.Context(), id)
+	if err != nil {
+		s.writeOutboxError(w, err)
+		return
+	}
+
+	history, err := s.outbox.History(r.Context(), id)
+	if err != nil {
+		s.writeOutboxError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newEmailResponse(e, history))
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := outbox.ListFilter{
+		Status:          query.Get("status"),
+		ReasonContains:  query.Get("reason"),
+		Cursor:          query.Get("cursor"),
+		IncludeArchived: query.Get("archived") == "true",
+	}
+
+	var err error
+	if filter.UpdatedFrom, err = parseTimeParam(query.Get("from")); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid from: expected RFC3339 time")
+		return
+	}
+	if filter.UpdatedTo, err = parseTimeParam(query.Get("to")); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid to: expected RFC3339 time")
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit: expected a positive integer")
+			return
+		}
+	}
+
+	page, err := s.outbox.List(r.Context(), filter)
+	if err != nil {
+		s.writeOutboxError(w, err)
+		return
+	}
+
+	resp := listResponse{Emails: make([]emailResponse, 0, len(page.Emails)), NextCursor: page.NextCursor}
+	for _, e := range page.Emails {
+		resp.Emails = append(resp.Emails, newEmailResponse(e, nil))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		operator, _ := r.Context().Value(operatorContextKey{}).(string)
+
+		var req actionRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+				return
+			}
+		}
+
+		e, err := s.outbox.ApplyOperatorAction(r.Context(), id, action, operator, req.Reason)
+		if err != nil {
+			s.writeOutboxError(w, err)
+			return
+		}
+
+		s.logger.Info(fmt.Sprintf("operator %v applied %v to email %v, new status %v", operator, action, id, e.Status))
+
+		history, err := s.outbox.History(r.Context(), id)
+		if err != nil {
+			s.writeOutboxError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newEmailResponse(e, history))
+	}
+}
+
+func (s *Server) writeOutboxError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, outbox.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, outbox.ErrInvalidCursor):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, outbox.ErrTransitionNotAllowed), errors.Is(err, outbox.ErrLockNotAcquired), errors.Is(err, outbox.ErrSendInProgress):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		s.logger.Error(fmt.Sprintf("admin request failed: %v", err))
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func newEmailResponse(e outbox.Email, history []outbox.StatusChange) emailResponse {
+	resp := emailResponse{
+		Id:              e.Id,
+		Status:          e.Status,
+		PayloadFilePath: e.PayloadFilePath,
+		Reason:          e.Reason,
+		Failure:         e.Failure,
+		Version:         e.Version,
+		UpdatedAt:       e.UpdatedAt,
+	}
+
+	for _, c := range history {
+		resp.History = append(resp.History, statusChangeResponse{
+			Status:    c.Status,
+			Reason:    c.Reason,
+			Failure:   c.Failure,
+			Operator:  c.Operator,
+			CreatedAt: c.CreatedAt,
+		})
+	}
+
+	return resp
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
@@ -0,0 +1,156 @@
+//go:build unit
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mailculator-processor/internal/outbox"
+)
+
+const testToken = "test-token-0123456789"
+
+type outboxMock struct {
+	email          outbox.Email
+	getError       error
+	actionError    error
+	lastFilter     outbox.ListFilter
+	lastAction     string
+	lastOperator   string
+	lastReason     string
+	listNextCursor string
+}
+
+func (m *outboxMock) Get(ctx context.Context, id string) (outbox.Email, error) {
+	return m.email, m.getError
+}
+
+func (m *outboxMock) History(ctx context.Context, id string) ([]outbox.StatusChange, error) {
+	return []outbox.StatusChange{{Status: m.email.Status, Operator: m.lastOperator, CreatedAt: m.email.UpdatedAt}}, nil
+}
+
+func (m *outboxMock) List(ctx context.Context, filter outbox.ListFilter) (outbox.ListPage, error) {
+	m.lastFilter = filter
+	return outbox.ListPage{Emails: []outbox.Email{m.email}, NextCursor: m.listNextCursor}, nil
+}
+
+func (m *outboxMock) ApplyOperatorAction(ctx context.Context, id string, action string, operator string, reason string) (outbox.Email, error) {
+	m.lastAction = action
+	m.lastOperator = operator
+	m.lastReason = reason
+	if m.actionError != nil {
+		return outbox.Email{}, m.actionError
+	}
+	e := m.email
+	e.Status = outbox.StatusReady
+	return e, nil
+}
+
+func newTestServer(ob *outboxMock) http.Handler {
+	return NewServer(0, ob, []Operator{{Name: "alice", Token: testToken}}).Handler()
+}
+
+func doRequest(t *testing.T, h http.Handler, method string, target string, body string, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequestWithoutTokenIsUnauthorized(t *testing.T) {
+	h := newTestServer(&outboxMock{})
+
+	assert.Equal(t, http.StatusUnauthorized, doRequest(t, h, http.MethodGet, "/v1/emails/1", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(t, h, http.MethodGet, "/v1/emails/1", "", "wrong-token").Code)
+}
+
+func TestGetEmailWithHistory(t *testing.T) {
+	h := newTestServer(&outboxMock{email: outbox.Email{Id: "1", Status: outbox.StatusSent, UpdatedAt: "2025-01-01T00:00:00Z"}})
+
+	rec := doRequest(t, h, http.MethodGet, "/v1/emails/1", "", testToken)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp emailResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "1", resp.Id)
+	assert.Equal(t, outbox.StatusSent, resp.Status)
+	assert.Len(t, resp.History, 1)
+}
+
+func TestGetEmailNotFound(t *testing.T) {
+	h := newTestServer(&outboxMock{getError: outbox.ErrNotFound})
+
+	rec := doRequest(t, h, http.MethodGet, "/v1/emails/1", "", testToken)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.JSONEq(t, `{"error":"email not found"}`, rec.Body.String())
+}
+
+func TestListEmailsParsesFilter(t *testing.T) {
+	ob := &outboxMock{email: outbox.Email{Id: "1", Status: outbox.StatusFailed}, listNextCursor: "next"}
+	h := newTestServer(ob)
+
+	rec := doRequest(t, h, http.MethodGet, "/v1/emails?status=FAILED&from=2025-01-01T00:00:00Z&reason=quota&limit=10&archived=true", "", testToken)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, outbox.StatusFailed, ob.lastFilter.Status)
+	assert.Equal(t, "quota", ob.lastFilter.ReasonContains)
+	assert.Equal(t, 10, ob.lastFilter.Limit)
+	assert.True(t, ob.lastFilter.IncludeArchived)
+	assert.Equal(t, 2025, ob.lastFilter.UpdatedFrom.Year())
+
+	var resp listResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Len(t, resp.Emails, 1)
+	assert.Equal(t, "next", resp.NextCursor)
+}
+
+func TestListEmailsInvalidTime(t *testing.T) {
+	h := newTestServer(&outboxMock{})
+
+	rec := doRequest(t, h, http.MethodGet, "/v1/emails?from=yesterday", "", testToken)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestRequeueRecordsOperator(t *testing.T) {
+	ob := &outboxMock{email: outbox.Email{Id: "1", Status: outbox.StatusFailed}}
+	h := newTestServer(ob)
+
+	rec := doRequest(t, h, http.MethodPost, "/v1/emails/1/requeue", `{"reason":"smtp fixed"}`, testToken)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, outbox.ActionRequeue, ob.lastAction)
+	assert.Equal(t, "alice", ob.lastOperator)
+	assert.Equal(t, "smtp fixed", ob.lastReason)
+
+	var resp emailResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, outbox.StatusReady, resp.Status)
+	assert.Equal(t, "alice", resp.History[0].Operator)
+}
+
+func TestActionNotAllowedIsConflict(t *testing.T) {
+	ob := &outboxMock{actionError: outbox.ErrTransitionNotAllowed}
+	h := newTestServer(ob)
+
+	for _, action := range []string{"cancel", "acknowledge"} {
+		rec := doRequest(t, h, http.MethodPost, "/v1/emails/1/"+action, "", testToken)
+
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, action, ob.lastAction)
+	}
+}
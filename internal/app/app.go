@@ -10,36 +10,92 @@ import (
 
 	_ "github.com/go-sql-driver/mysql"
 
+	"mailculator-processor/internal/admin"
+	"mailculator-processor/internal/bounce"
+	"mailculator-processor/internal/callbacksink"
 	"mailculator-processor/internal/healthcheck"
+	"mailculator-processor/internal/ingestion"
+	"mailculator-processor/internal/metrics"
 	"mailculator-processor/internal/outbox"
 	"mailculator-processor/internal/pipeline"
 	"mailculator-processor/internal/smtp"
+	"mailculator-processor/internal/tracing"
+	"mailculator-processor/internal/wakeup"
 )
 
 type pipelineProcessor interface {
-	Process(ctx context.Context)
+	// Process handles one batch and returns the number of emails it handled
+	Process(ctx context.Context) int
 }
 
 type pipelineEntry struct {
+	name     string
 	proc     pipelineProcessor
 	interval int
+	// batchSize is the size of a full batch, re-polled immediately; 0 disables immediate re-polls
+	batchSize int
+	// wake interrupts the wait before the next poll, nil for pipelines not woken by status changes
+	wake <-chan struct{}
+	// heartbeat is ticked on every loop iteration, so /readyz can detect a wedged pipeline
+	heartbeat *healthcheck.Heartbeat
+}
+
+// queueCounter counts the emails per status for the queue depth exposed on /metrics.
+type queueCounter interface {
+	CountByStatus(ctx context.Context) (map[string]int, error)
 }
 
 type App struct {
 	pipes             []pipelineEntry
+	maxIdleInterval   int // seconds, upper bound of the backoff while a pipeline finds nothing to do
 	healthCheckServer *healthcheck.Server
-	mysqlDB           *sql.DB // Keep reference for cleanup
+	adminServer       *admin.Server     // nil when the admin API is disabled
+	ingestionServer   *ingestion.Server // nil when the ingestion API is disabled
+	wakeupHub         *wakeup.Hub
+	claims            *pipeline.ClaimTracker
+	gracePeriod       time.Duration // upper bound to drain the in-flight emails on shutdown
+	queue             queueCounter
+	queueInterval     time.Duration // how often the queue depth is refreshed
+	mysqlDB           *sql.DB       // Keep reference for cleanup
+	callbackSink      callbacksink.Sink
+	shutdownTracing   tracing.Shutdown
 }
 
+// releaseTimeout bounds the release of the emails still claimed after the grace period.
+const releaseTimeout = 10 * time.Second
+
+// tracingFlushTimeout bounds the export of the spans still buffered on shutdown.
+const tracingFlushTimeout = 5 * time.Second
+
 type configProvider interface {
+	GetAdminServerPort() int
+	GetAdminOperators() []admin.Operator
+	GetIngestionConfig() ingestion.Config
+	GetIngestionPayloadPath() string
 	GetHealthCheckServerPort() int
-	GetPipelineInterval() int
+	GetQueueDepthInterval() time.Duration
+	GetReadinessConfig() healthcheck.ReadinessConfig
+	GetIntakePipelineConfig() pipeline.StageConfig
+	GetSenderPipelineConfig() pipeline.StageConfig
+	GetCallbackPipelineConfig() pipeline.StageConfig
+	GetPipelineMaxIdleInterval() int
 	GetRestorePipelineInterval() int
 	GetRestorePipelineMaxAge() time.Duration
+	GetAmbiguousSendPolicy() pipeline.AmbiguousSendPolicy
+	GetRetentionPipelineInterval() int
+	GetRetentionConfig() pipeline.RetentionConfig
+	GetBouncePipelineInterval() int
+	GetBounceConfig() pipeline.BounceConfig
+	GetBounceSourceConfig() bounce.Config
 	GetCallbackConfig() pipeline.CallbackConfig
+	GetCallbackSinkConfig() callbacksink.Config
 	GetSmtpConfig() smtp.Config
+	GetEnvelopeSenderConfig() pipeline.EnvelopeSenderConfig
 	GetAttachmentsBasePath() string
 	GetMySQLDSN() string
+	GetWakeupConfig() wakeup.Config
+	GetShutdownGracePeriod() time.Duration
+	GetTracingConfig() tracing.Config
 }
 
 type mysqlOpener func(driverName, dsn string) (*sql.DB, error)
@@ -49,8 +105,18 @@ func New(cp configProvider) (*App, error) {
 }
 
 func NewWithMySQLOpener(cp configProvider, opener mysqlOpener) (*App, error) {
+	shutdownTracing, err := tracing.Setup(context.Background(), cp.GetTracingConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
 	client := smtp.New(cp.GetSmtpConfig())
+	callbackSink, err := callbacksink.New(cp.GetCallbackSinkConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up the callback sink: %w", err)
+	}
 	callbackConfig := cp.GetCallbackConfig()
+	callbackConfig.Sink = callbackSink
 	healthCheckServer := healthcheck.NewServer(cp.GetHealthCheckServerPort())
 
 	var pipes []pipelineEntry
@@ -62,7 +128,6 @@ func NewWithMySQLOpener(cp configProvider, opener mysqlOpener) (*App, error) {
 	}
 
 	slog.Info("MySQL pipelines initializing...")
-	var err error
 	mysqlDB, err = opener("mysql", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open MySQL connection: %w", err)
@@ -80,64 +145,327 @@ func NewWithMySQLOpener(cp configProvider, opener mysqlOpener) (*App, error) {
 
 	mysqlOutbox := outbox.NewOutbox(mysqlDB)
 
-	mainInterval := cp.GetPipelineInterval()
+	// wakes a pipeline as soon as an email enters its start status, the timer poll stays as a fallback
+	wakeupHub := wakeup.NewHub(cp.GetWakeupConfig())
+	mysqlOutbox.SetNotifier(wakeupHub)
+
+	// the claiming pipelines go through the tracker, so their in-flight emails can be released on shutdown
+	claims := pipeline.NewClaimTracker(mysqlOutbox)
+
 	restoreInterval := cp.GetRestorePipelineInterval()
 	restoreMaxAge := cp.GetRestorePipelineMaxAge()
 
+	sender := cp.GetSenderPipelineConfig()
+	callback := cp.GetCallbackPipelineConfig()
+
+	if intake := cp.GetIntakePipelineConfig(); intake.Enabled {
+		pipes = append(pipes,
+			pipelineEntry{name: "intake", proc: pipeline.NewIntakePipeline(claims, intake.Pool, callbackConfig.AllowedHosts), interval: intake.Interval, batchSize: intake.Pool.WithDefaults().BatchSize, wake: wakeupHub.Subscribe(outbox.StatusAccepted)},
+		)
+	}
+
+	if sender.Enabled {
+		pipes = append(pipes,
+			pipelineEntry{name: "main", proc: pipeline.NewMainSenderPipeline(claims, client, cp.GetAttachmentsBasePath(), sender.Pool, cp.GetEnvelopeSenderConfig()), interval: sender.Interval, batchSize: sender.Pool.WithDefaults().BatchSize, wake: wakeupHub.Subscribe(outbox.StatusReady)},
+		)
+	}
+
+	if callback.Enabled {
+		pipes = append(pipes,
+			pipelineEntry{name: "sent-callback", proc: pipeline.NewSentCallbackPipeline(claims, callbackConfig, callback.Pool), interval: callback.Interval, batchSize: callback.Pool.WithDefaults().BatchSize, wake: wakeupHub.Subscribe(outbox.StatusSent)},
+			pipelineEntry{name: "failed-callback", proc: pipeline.NewFailedCallbackPipeline(claims, callbackConfig, callback.Pool), interval: callback.Interval, batchSize: callback.Pool.WithDefaults().BatchSize, wake: wakeupHub.Subscribe(outbox.StatusFailed)},
+			pipelineEntry{name: "cancelled-callback", proc: pipeline.NewCancelledCallbackPipeline(claims, callbackConfig, callback.Pool), interval: callback.Interval, batchSize: callback.Pool.WithDefaults().BatchSize, wake: wakeupHub.Subscribe(outbox.StatusCancelled)},
+			pipelineEntry{name: "invalid-callback", proc: pipeline.NewInvalidCallbackPipeline(claims, callbackConfig, callback.Pool), interval: callback.Interval, batchSize: callback.Pool.WithDefaults().BatchSize, wake: wakeupHub.Subscribe(outbox.StatusInvalid)},
+			pipelineEntry{name: "send-uncertain-callback", proc: pipeline.NewSendUncertainCallbackPipeline(claims, callbackConfig, callback.Pool), interval: callback.Interval, batchSize: callback.Pool.WithDefaults().BatchSize, wake: wakeupHub.Subscribe(outbox.StatusSendUncertain)},
+			pipelineEntry{name: "bounced-callback", proc: pipeline.NewBouncedCallbackPipeline(claims, callbackConfig, callback.Pool), interval: callback.Interval, batchSize: callback.Pool.WithDefaults().BatchSize, wake: wakeupHub.Subscribe(outbox.StatusBounced)},
+		)
+	}
+
 	pipes = append(pipes,
-		pipelineEntry{proc: pipeline.NewIntakePipeline(mysqlOutbox), interval: mainInterval},
-		pipelineEntry{proc: pipeline.NewMainSenderPipeline(mysqlOutbox, client, cp.GetAttachmentsBasePath()), interval: mainInterval},
-		pipelineEntry{proc: pipeline.NewSentCallbackPipeline(mysqlOutbox, callbackConfig), interval: mainInterval},
-		pipelineEntry{proc: pipeline.NewFailedCallbackPipeline(mysqlOutbox, callbackConfig), interval: mainInterval},
-		pipelineEntry{proc: pipeline.NewRestoreIntakingPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
-		pipelineEntry{proc: pipeline.NewRestoreProcessingPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
-		pipelineEntry{proc: pipeline.NewRestoreCallingSentPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
-		pipelineEntry{proc: pipeline.NewRestoreCallingFailedPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
+		pipelineEntry{name: "restore-intaking", proc: pipeline.NewRestoreIntakingPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
+		pipelineEntry{name: "restore-processing", proc: pipeline.NewRestoreProcessingPipeline(mysqlOutbox, restoreMaxAge, cp.GetAmbiguousSendPolicy()), interval: restoreInterval},
+		pipelineEntry{name: "restore-calling-sent", proc: pipeline.NewRestoreCallingSentPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
+		pipelineEntry{name: "restore-calling-failed", proc: pipeline.NewRestoreCallingFailedPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
+		pipelineEntry{name: "restore-calling-cancelled", proc: pipeline.NewRestoreCallingCancelledPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
+		pipelineEntry{name: "restore-calling-invalid", proc: pipeline.NewRestoreCallingInvalidPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
+		pipelineEntry{name: "restore-calling-send-uncertain", proc: pipeline.NewRestoreCallingSendUncertainPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
+		pipelineEntry{name: "restore-calling-bounced", proc: pipeline.NewRestoreCallingBouncedPipeline(mysqlOutbox, restoreMaxAge), interval: restoreInterval},
 	)
+
+	if retentionInterval := cp.GetRetentionPipelineInterval(); retentionInterval > 0 {
+		pipes = append(pipes,
+			pipelineEntry{name: "retention", proc: pipeline.NewRetentionPipeline(mysqlOutbox, cp.GetRetentionConfig()), interval: retentionInterval},
+		)
+	}
+
+	if bounceInterval := cp.GetBouncePipelineInterval(); bounceInterval > 0 {
+		bounceSource, err := bounce.New(cp.GetBounceSourceConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up the bounce source: %w", err)
+		}
+		bounceConfig := cp.GetBounceConfig().WithDefaults()
+		pipes = append(pipes,
+			pipelineEntry{name: "bounce", proc: pipeline.NewBouncePipeline(mysqlOutbox, bounceSource, bounceConfig), interval: bounceInterval, batchSize: bounceConfig.BatchSize},
+		)
+	}
 	slog.Info("MySQL pipelines initialized", "count", len(pipes))
 
-	slog.Info("App initialized", "total_pipelines", len(pipes))
+	readiness := cp.GetReadinessConfig()
+	healthCheckServer.SetProbeTimeouts(readiness.CheckTimeout, readiness.CacheTTL)
+	healthCheckServer.AddCheck("mysql", mysqlDB.PingContext)
+	if sender.Enabled {
+		healthCheckServer.AddCheck("smtp", client.Probe)
+		healthCheckServer.AddCheck("attachments", healthcheck.DirProbe(cp.GetAttachmentsBasePath()))
+	}
+	if callback.Enabled {
+		if pinger, ok := callbackSink.(callbacksink.Pinger); ok {
+			healthCheckServer.AddCheck("callback", pinger.Ping)
+		} else {
+			healthCheckServer.AddCheck("callback", healthcheck.HTTPProbe(callbackConfig.Url))
+		}
+	}
+
+	maxIdleInterval := cp.GetPipelineMaxIdleInterval()
+	for i := range pipes {
+		// the longest wait between two polls is the backoff limit, see pollDelay
+		longestWait := time.Duration(max(pipes[i].interval, maxIdleInterval)) * time.Second
+		pipes[i].heartbeat = healthcheck.NewHeartbeat()
+		healthCheckServer.AddCheck("pipeline."+pipes[i].name, pipes[i].heartbeat.Probe(longestWait+readiness.PipelineStaleAfter))
+	}
+
+	var adminServer *admin.Server
+	if adminPort := cp.GetAdminServerPort(); adminPort > 0 {
+		adminServer = admin.NewServer(adminPort, mysqlOutbox, cp.GetAdminOperators())
+	}
+
+	var ingestionServer *ingestion.Server
+	if ingestionConfig := cp.GetIngestionConfig(); ingestionConfig.Port > 0 {
+		ingestionServer = ingestion.NewServer(ingestionConfig, mysqlOutbox, ingestion.NewFileStore(cp.GetIngestionPayloadPath()))
+	}
+
+	slog.Info("App initialized", "total_pipelines", len(pipes), "admin_api", adminServer != nil, "ingestion_api", ingestionServer != nil)
 
 	return &App{
 		pipes:             pipes,
+		maxIdleInterval:   maxIdleInterval,
 		healthCheckServer: healthCheckServer,
+		adminServer:       adminServer,
+		ingestionServer:   ingestionServer,
+		wakeupHub:         wakeupHub,
+		claims:            claims,
+		gracePeriod:       cp.GetShutdownGracePeriod(),
+		queue:             mysqlOutbox,
+		queueInterval:     cp.GetQueueDepthInterval(),
 		mysqlDB:           mysqlDB,
+		callbackSink:      callbackSink,
+		shutdownTracing:   shutdownTracing,
 	}, nil
 }
 
-func (a *App) runPipelineUntilContextIsDone(ctx context.Context, proc pipelineProcessor, interval int) {
+// drain waits for the pipelines to finish their in-flight emails, up to the grace period,
+// then releases the emails still claimed and logs a summary.
+func (a *App) drain(pipesWg *sync.WaitGroup) {
+	if a.claims != nil {
+		a.claims.StartDraining()
+		slog.Info("shutting down, draining in-flight emails", "in_flight", a.claims.InFlight(), "grace_period", a.gracePeriod)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		pipesWg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(a.gracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		slog.Warn("grace period expired before the pipelines drained")
+	}
+
+	if a.claims == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	summary := a.claims.Release(ctx)
+	slog.Info("shutdown completed", "drained", summary.Drained, "released", summary.Released, "pending", summary.Pending)
+}
+
+// collectQueueDepth refreshes the emails per status gauge until the context is done.
+// Statuses that emptied since the previous count are reported as 0.
+func (a *App) collectQueueDepth(ctx context.Context) {
+	seen := make(map[string]bool)
+	ticker := time.NewTicker(a.queueInterval)
+	defer ticker.Stop()
+
+	for {
+		counts, err := a.queue.CountByStatus(ctx)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("failed to count emails per status: %v", err))
+		} else {
+			for status := range seen {
+				if _, found := counts[status]; !found {
+					metrics.QueueDepth.Set(0, status)
+				}
+			}
+			for status, count := range counts {
+				metrics.QueueDepth.Set(float64(count), status)
+				seen[status] = true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *App) runPipelineUntilContextIsDone(ctx context.Context, entry pipelineEntry) {
+	idlePolls := 0
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			proc.Process(ctx)
 		}
-		time.Sleep(time.Duration(interval) * time.Second)
+
+		if entry.heartbeat != nil {
+			entry.heartbeat.Beat()
+		}
+
+		handled := entry.proc.Process(ctx)
+		if handled == 0 {
+			idlePolls++
+		} else {
+			idlePolls = 0
+		}
+
+		delay := pollDelay(handled, entry.batchSize, idlePolls, entry.interval, a.maxIdleInterval)
+		if delay == 0 {
+			continue
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-entry.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
 	}
 }
 
+// pollDelay returns the wait before the next poll: none after a full batch, the interval after a partial one,
+// and an interval doubling for each consecutive empty poll, up to maxIdleInterval, while the queue stays empty.
+func pollDelay(handled int, batchSize int, idlePolls int, interval int, maxIdleInterval int) time.Duration {
+	if batchSize > 0 && handled >= batchSize {
+		return 0
+	}
+
+	base := time.Duration(interval) * time.Second
+	if idlePolls <= 1 {
+		return base
+	}
+
+	limit := max(time.Duration(maxIdleInterval)*time.Second, base)
+	delay := base
+	for range idlePolls - 1 {
+		delay *= 2
+		if delay >= limit {
+			return limit
+		}
+	}
+	return delay
+}
+
 func (a *App) Run(ctx context.Context) {
 	var wg sync.WaitGroup
+	var pipesWg sync.WaitGroup
 
 	for _, entry := range a.pipes {
-		proc := entry.proc
-		interval := entry.interval
-		wg.Add(1)
+		pipesWg.Add(1)
 		go func() {
-			defer wg.Done()
-			a.runPipelineUntilContextIsDone(ctx, proc, interval)
+			defer pipesWg.Done()
+			a.runPipelineUntilContextIsDone(ctx, entry)
 		}()
 	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		a.drain(&pipesWg)
+	}()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		slog.Info(fmt.Sprintf("%v", a.healthCheckServer.ListenAndServe(ctx)))
 	}()
 
+	if a.queue != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.collectQueueDepth(ctx)
+		}()
+	}
+
+	if a.adminServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slog.Info(fmt.Sprintf("%v", a.adminServer.ListenAndServe(ctx)))
+		}()
+	}
+
+	if a.ingestionServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slog.Info(fmt.Sprintf("%v", a.ingestionServer.ListenAndServe(ctx)))
+		}()
+	}
+
+	if a.wakeupHub != nil && a.wakeupHub.Listening() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.wakeupHub.ListenAndServe(ctx); err != nil {
+				slog.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+	}
+
 	wg.Wait()
 
+	if a.wakeupHub != nil {
+		a.wakeupHub.Close()
+	}
+
+	if a.shutdownTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+		if err := a.shutdownTracing(ctx); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+		cancel()
+	}
+
+	if a.callbackSink != nil {
+		if err := a.callbackSink.Close(); err != nil {
+			slog.Error("failed to close the callback sink", "error", err)
+		}
+	}
+
 	// Cleanup MySQL connection if it was opened
 	if a.mysqlDB != nil {
 		if err := a.mysqlDB.Close(); err != nil {
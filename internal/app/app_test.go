@@ -12,19 +12,41 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"mailculator-processor/internal/admin"
+	"mailculator-processor/internal/bounce"
+	"mailculator-processor/internal/callbacksink"
 	"mailculator-processor/internal/healthcheck"
+	"mailculator-processor/internal/ingestion"
+	"mailculator-processor/internal/metrics"
 	"mailculator-processor/internal/pipeline"
 	"mailculator-processor/internal/smtp"
+	"mailculator-processor/internal/testutils/mocks"
+	"mailculator-processor/internal/tracing"
+	"mailculator-processor/internal/wakeup"
 )
 
-type configProviderMock struct{}
+type configProviderMock struct {
+	senderDisabled bool
+}
 
 func newConfigProviderMock() *configProviderMock {
 	return &configProviderMock{}
 }
 
-func (cp *configProviderMock) GetPipelineInterval() int {
-	return 1
+func (cp *configProviderMock) GetPipelineMaxIdleInterval() int {
+	return 30
+}
+
+func (cp *configProviderMock) GetIntakePipelineConfig() pipeline.StageConfig {
+	return pipeline.StageConfig{Enabled: true, Interval: 1}
+}
+
+func (cp *configProviderMock) GetSenderPipelineConfig() pipeline.StageConfig {
+	return pipeline.StageConfig{Enabled: !cp.senderDisabled, Interval: 1, Pool: pipeline.PoolConfig{BatchSize: 50, Workers: 10}}
+}
+
+func (cp *configProviderMock) GetCallbackPipelineConfig() pipeline.StageConfig {
+	return pipeline.StageConfig{Enabled: true, Interval: 2}
 }
 
 func (cp *configProviderMock) GetRestorePipelineInterval() int {
@@ -35,6 +57,33 @@ func (cp *configProviderMock) GetRestorePipelineMaxAge() time.Duration {
 	return 30 * time.Minute
 }
 
+func (cp *configProviderMock) GetAmbiguousSendPolicy() pipeline.AmbiguousSendPolicy {
+	return pipeline.AmbiguousSendOperator
+}
+
+func (cp *configProviderMock) GetRetentionPipelineInterval() int {
+	return 3600
+}
+
+func (cp *configProviderMock) GetRetentionConfig() pipeline.RetentionConfig {
+	return pipeline.RetentionConfig{
+		Periods:   map[string]time.Duration{"SENT-ACKNOWLEDGED": 24 * time.Hour},
+		BatchSize: 100,
+	}
+}
+
+func (cp *configProviderMock) GetBouncePipelineInterval() int {
+	return 60
+}
+
+func (cp *configProviderMock) GetBounceConfig() pipeline.BounceConfig {
+	return pipeline.BounceConfig{VERPAddress: "bounces@dummy-domain.com"}
+}
+
+func (cp *configProviderMock) GetBounceSourceConfig() bounce.Config {
+	return bounce.Config{Kind: bounce.KindMaildir, Maildir: bounce.MaildirConfig{Path: "/base/bounces/path/"}}
+}
+
 func (cp *configProviderMock) GetCallbackConfig() pipeline.CallbackConfig {
 	return pipeline.CallbackConfig{Url: "dummy-domain.com",
 		RetryInterval: 2,
@@ -42,10 +91,42 @@ func (cp *configProviderMock) GetCallbackConfig() pipeline.CallbackConfig {
 	}
 }
 
+func (cp *configProviderMock) GetCallbackSinkConfig() callbacksink.Config {
+	return callbacksink.Config{Kind: callbacksink.KindHTTP}
+}
+
+func (cp *configProviderMock) GetAdminServerPort() int {
+	return 8081
+}
+
+func (cp *configProviderMock) GetAdminOperators() []admin.Operator {
+	return []admin.Operator{{Name: "dummy-operator", Token: "dummy-token-0123456789"}}
+}
+
+func (cp *configProviderMock) GetIngestionConfig() ingestion.Config {
+	return ingestion.Config{Port: 8082, MaxBatchSize: 100}
+}
+
+func (cp *configProviderMock) GetIngestionPayloadPath() string {
+	return "/base/payloads/path/"
+}
+
 func (cp *configProviderMock) GetHealthCheckServerPort() int {
 	return 8080
 }
 
+func (cp *configProviderMock) GetQueueDepthInterval() time.Duration {
+	return 15 * time.Second
+}
+
+func (cp *configProviderMock) GetReadinessConfig() healthcheck.ReadinessConfig {
+	return healthcheck.ReadinessConfig{CheckTimeout: time.Second, CacheTTL: time.Second, PipelineStaleAfter: time.Minute}
+}
+
+func (cp *configProviderMock) GetEnvelopeSenderConfig() pipeline.EnvelopeSenderConfig {
+	return pipeline.EnvelopeSenderConfig{Strategy: pipeline.EnvelopeSenderVERP, Address: "bounces@dummy-domain.com"}
+}
+
 func (cp *configProviderMock) GetSmtpConfig() smtp.Config {
 	return smtp.Config{
 		Host:             "dummy-host",
@@ -61,6 +142,18 @@ func (cp *configProviderMock) GetAttachmentsBasePath() string {
 	return "/base/attachments/path/"
 }
 
+func (cp *configProviderMock) GetWakeupConfig() wakeup.Config {
+	return wakeup.Config{}
+}
+
+func (cp *configProviderMock) GetShutdownGracePeriod() time.Duration {
+	return 30 * time.Second
+}
+
+func (cp *configProviderMock) GetTracingConfig() tracing.Config {
+	return tracing.Config{}
+}
+
 func (cp *configProviderMock) GetMySQLDSN() string {
 	return "sqlmock"
 }
@@ -79,7 +172,7 @@ func TestAppInstance(t *testing.T) {
 
 	app, errNew := NewWithMySQLOpener(newConfigProviderMock(), opener)
 	require.NoError(t, errNew)
-	require.Equal(t, 8, len(app.pipes))
+	require.Equal(t, 18, len(app.pipes))
 	assert.NotZero(t, app.pipes[0])
 	assert.NotZero(t, app.pipes[1])
 	assert.NotZero(t, app.pipes[2])
@@ -88,21 +181,65 @@ func TestAppInstance(t *testing.T) {
 	assert.NotZero(t, app.pipes[5])
 	assert.NotZero(t, app.pipes[6])
 	assert.NotZero(t, app.pipes[7])
+	assert.NotZero(t, app.pipes[8])
+	assert.NotZero(t, app.pipes[9])
+	assert.NotZero(t, app.pipes[10])
+	assert.NotZero(t, app.pipes[11])
+	assert.NotZero(t, app.pipes[12])
+	assert.NotZero(t, app.pipes[13])
+	assert.NotZero(t, app.pipes[14])
+	assert.NotZero(t, app.pipes[15])
+	assert.NotNil(t, app.adminServer)
+	assert.NotNil(t, app.ingestionServer)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestAppInstanceWithDisabledPipeline(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	mock.ExpectPing()
+
+	opener := func(_ string, _ string) (*sql.DB, error) {
+		return db, nil
+	}
+
+	cp := newConfigProviderMock()
+	cp.senderDisabled = true
+
+	app, errNew := NewWithMySQLOpener(cp, opener)
+	require.NoError(t, errNew)
+	require.Equal(t, 17, len(app.pipes))
+	for _, entry := range app.pipes {
+		_, isSender := entry.proc.(*pipeline.MainSenderPipeline)
+		assert.False(t, isSender)
+	}
+
+	checks := app.healthCheckServer.Check(context.TODO())
+	assert.Contains(t, checks, "mysql")
+	assert.Contains(t, checks, "callback")
+	assert.Contains(t, checks, "pipeline.intake")
+	assert.Contains(t, checks, "pipeline.bounce")
+	assert.NotContains(t, checks, "smtp")
+	assert.NotContains(t, checks, "pipeline.main")
+}
+
 type processorMock struct {
 	sleepMilliseconds int
 	calls             int
+	handled           int
 }
 
 func newProcessorMock(sleepMilliseconds int) *processorMock {
 	return &processorMock{sleepMilliseconds: sleepMilliseconds, calls: 0}
 }
 
-func (t *processorMock) Process(_ context.Context) {
+func (t *processorMock) Process(_ context.Context) int {
 	time.Sleep(time.Duration(t.sleepMilliseconds) * time.Millisecond)
 	t.calls++
+	return t.handled
 }
 
 func TestRunFunction(t *testing.T) {
@@ -117,6 +254,7 @@ func TestRunFunction(t *testing.T) {
 			{proc: proc2, interval: 1},
 		},
 		healthCheckServer: healthCheckServer,
+		gracePeriod:       time.Second,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
@@ -126,3 +264,117 @@ func TestRunFunction(t *testing.T) {
 	assert.Equal(t, 1, proc1.calls)
 	assert.Equal(t, 1, proc2.calls)
 }
+
+func TestRunPipelineRepollsFullBatchAndStopsOnCancel(t *testing.T) {
+	proc := newProcessorMock(10)
+	proc.handled = 25
+	app := &App{maxIdleInterval: 30}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	app.runPipelineUntilContextIsDone(ctx, pipelineEntry{proc: proc, interval: 60, batchSize: 25})
+
+	assert.Greater(t, proc.calls, 1)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRunPipelineSleepIsInterruptible(t *testing.T) {
+	proc := newProcessorMock(0)
+	app := &App{maxIdleInterval: 30}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	app.runPipelineUntilContextIsDone(ctx, pipelineEntry{proc: proc, interval: 60, batchSize: 25})
+
+	assert.Equal(t, 1, proc.calls)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestPollDelay(t *testing.T) {
+	cases := []struct {
+		name      string
+		handled   int
+		batchSize int
+		idlePolls int
+		expected  time.Duration
+	}{
+		{"full batch", 25, 25, 0, 0},
+		{"partial batch", 10, 25, 0, 3 * time.Second},
+		{"no batch size", 25, 0, 0, 3 * time.Second},
+		{"first empty poll", 0, 25, 1, 3 * time.Second},
+		{"second empty poll", 0, 25, 2, 6 * time.Second},
+		{"third empty poll", 0, 25, 3, 12 * time.Second},
+		{"capped", 0, 25, 10, 30 * time.Second},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, pollDelay(c.handled, c.batchSize, c.idlePolls, 3, 30))
+		})
+	}
+}
+
+func TestRunPipelineWakesUpBeforeInterval(t *testing.T) {
+	proc := newProcessorMock(0)
+	app := &App{maxIdleInterval: 30}
+	wake := make(chan struct{}, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	wake <- struct{}{}
+	app.runPipelineUntilContextIsDone(ctx, pipelineEntry{proc: proc, interval: 60, batchSize: 25, wake: wake})
+
+	assert.Equal(t, 2, proc.calls)
+}
+
+func TestRunReleasesClaimsAfterGracePeriod(t *testing.T) {
+	ob := mocks.NewOutboxMock()
+	claims := pipeline.NewClaimTracker(ob)
+	require.NoError(t, claims.Update(context.TODO(), "1", "PROCESSING", ""))
+
+	app := &App{
+		pipes:             []pipelineEntry{{proc: newProcessorMock(500), interval: 1}},
+		healthCheckServer: healthcheck.NewServer(8083),
+		claims:            claims,
+		gracePeriod:       50 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	app.Run(ctx)
+
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+	assert.Equal(t, 0, claims.InFlight())
+	assert.Equal(t, "READY", ob.LastUpdateFromStatus())
+}
+
+type queueCounterMock struct {
+	counts []map[string]int
+	calls  int
+}
+
+func (m *queueCounterMock) CountByStatus(_ context.Context) (map[string]int, error) {
+	counts := m.counts[min(m.calls, len(m.counts)-1)]
+	m.calls++
+	return counts, nil
+}
+
+func TestCollectQueueDepthResetsEmptiedStatuses(t *testing.T) {
+	queue := &queueCounterMock{counts: []map[string]int{{"ACCEPTED": 3}, {}}}
+	app := &App{queue: queue, queueInterval: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	app.collectQueueDepth(ctx)
+
+	assert.GreaterOrEqual(t, queue.calls, 2)
+	assert.Equal(t, float64(0), metrics.QueueDepth.Value("ACCEPTED"))
+}
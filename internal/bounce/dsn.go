@@ -0,0 +1,202 @@
+// Package bounce reads the delivery status notifications (RFC 3464) returned for the sent emails
+// from an inbound mailbox: a local maildir, an IMAP folder or a POP3 mailbox.
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mailculator-processor/internal/failure"
+)
+
+var ErrNotDSN = errors.New("message is not a delivery status notification")
+
+// ActionFailed is the Action of a recipient the remote server gave up delivering to.
+const ActionFailed = "failed"
+
+// envelopeHeaders are the headers where the receiving server records the address a notification was delivered to,
+// that is the envelope sender of the original message.
+var envelopeHeaders = []string{"Delivered-To", "X-Original-To", "Envelope-To", "X-Envelope-To", "To"}
+
+// diagnosticReplyPattern matches the SMTP reply code opening a Diagnostic-Code of type smtp.
+var diagnosticReplyPattern = regexp.MustCompile(`^([245]\d\d)\b`)
+
+// Report is a delivery status notification.
+type Report struct {
+	// Recipients holds the per-recipient fields, in the order of the notification
+	Recipients []Recipient
+	// MessageID is the Message-ID of the original message, empty when the notification does not return its headers
+	MessageID string
+	// EnvelopeRecipients are the addresses the notification was delivered to, where a VERP address shows up
+	EnvelopeRecipients []string
+}
+
+// Recipient is the outcome of the delivery to one recipient of the original message.
+type Recipient struct {
+	FinalRecipient string
+	// Action is failed, delayed, delivered, relayed or expanded
+	Action string
+	// Status is the RFC 3463 enhanced status code, such as 5.1.1
+	Status string
+	// DiagnosticCode is the reply of the remote server, without its type
+	DiagnosticCode string
+}
+
+// Failed returns the first recipient the delivery failed for.
+// Notifications that only report delays or successful deliveries have none.
+func (r Report) Failed() (Recipient, bool) {
+	for _, recipient := range r.Recipients {
+		if recipient.Action == ActionFailed {
+			return recipient, true
+		}
+	}
+	return Recipient{}, false
+}
+
+// Reason describes the failed delivery: a 5.x.x status is a hard bounce, anything else a soft one.
+// The SMTP code comes from a Diagnostic-Code of type smtp.
+func (r Recipient) Reason() failure.Reason {
+	reason := failure.Reason{Category: failure.CategoryBounceSoft, EnhancedCode: r.Status, Message: r.DiagnosticCode}
+	if strings.HasPrefix(r.Status, "5") {
+		reason.Category = failure.CategoryBounceHard
+	}
+	if match := diagnosticReplyPattern.FindStringSubmatch(r.DiagnosticCode); match != nil {
+		reason.SMTPCode, _ = strconv.Atoi(match[1])
+	}
+	if reason.Message == "" {
+		reason.Message = fmt.Sprintf("delivery to %s failed with status %s", r.FinalRecipient, r.Status)
+	}
+	return reason
+}
+
+// Parse reads a multipart/report message carrying a message/delivery-status part.
+// It returns ErrNotDSN for any other message, such as an out-of-office reply.
+func Parse(raw []byte) (Report, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return Report{}, fmt.Errorf("%w: %v", ErrNotDSN, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["boundary"] == "" {
+		return Report{}, ErrNotDSN
+	}
+
+	report := Report{EnvelopeRecipients: envelopeRecipients(msg.Header)}
+	found := false
+
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Report{}, fmt.Errorf("failed to read the notification parts: %w", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		body := partBody(part)
+
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			recipients, err := parseDeliveryStatus(body)
+			if err != nil {
+				return Report{}, err
+			}
+			report.Recipients = recipients
+			found = true
+		case "message/rfc822", "text/rfc822-headers", "message/global-headers":
+			if original, err := mail.ReadMessage(body); err == nil {
+				report.MessageID = original.Header.Get("Message-Id")
+			}
+		}
+	}
+
+	if !found {
+		return Report{}, ErrNotDSN
+	}
+	return report, nil
+}
+
+func partBody(part *multipart.Part) io.Reader {
+	if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+		return base64.NewDecoder(base64.StdEncoding, part)
+	}
+	return part
+}
+
+// parseDeliveryStatus reads the per-message block and the per-recipient blocks that follow it,
+// all of them header blocks separated by a blank line.
+func parseDeliveryStatus(body io.Reader) ([]Recipient, error) {
+	reader := textproto.NewReader(bufio.NewReader(body))
+
+	var blocks []textproto.MIMEHeader
+	for {
+		block, err := reader.ReadMIMEHeader()
+		if len(block) > 0 {
+			blocks = append(blocks, block)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed delivery status: %w", err)
+		}
+	}
+
+	var recipients []Recipient
+	for _, block := range blocks {
+		if block.Get("Action") == "" {
+			// the per-message block
+			continue
+		}
+		recipients = append(recipients, Recipient{
+			FinalRecipient: typedValue(block.Get("Final-Recipient")),
+			Action:         strings.ToLower(strings.TrimSpace(block.Get("Action"))),
+			Status:         strings.TrimSpace(block.Get("Status")),
+			DiagnosticCode: typedValue(block.Get("Diagnostic-Code")),
+		})
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("%w: no recipient in the delivery status", ErrNotDSN)
+	}
+	return recipients, nil
+}
+
+// typedValue strips the type of a field such as "rfc822; user@example.com" or "smtp; 550 5.1.1 unknown".
+func typedValue(field string) string {
+	if _, value, found := strings.Cut(field, ";"); found {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(field)
+}
+
+func envelopeRecipients(header mail.Header) []string {
+	var addresses []string
+	for _, name := range envelopeHeaders {
+		for _, value := range header[textproto.CanonicalMIMEHeaderKey(name)] {
+			list, err := mail.ParseAddressList(value)
+			if err != nil {
+				addresses = append(addresses, strings.Trim(strings.TrimSpace(value), "<>"))
+				continue
+			}
+			for _, address := range list {
+				addresses = append(addresses, address.Address)
+			}
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,94 @@
+//go:build unit
+
+package bounce
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mailculator-processor/internal/failure"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	raw, err := os.ReadFile("testdata/" + name)
+	require.NoError(t, err)
+	return raw
+}
+
+func TestParseHardBounce(t *testing.T) {
+	report, err := Parse(readFixture(t, "hard-bounce.eml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "<550e8400-e29b-41d4-a716-446655440000@mailculator.example>", report.MessageID)
+	assert.Contains(t, report.EnvelopeRecipients, "bounces+4b1c7a52-0d3e-4f7a-9a63-2f0f6c1b9e11@mailculator.example")
+
+	recipient, found := report.Failed()
+	require.True(t, found)
+	assert.Equal(t, failure.Reason{
+		Category:     failure.CategoryBounceHard,
+		SMTPCode:     550,
+		EnhancedCode: "5.1.1",
+		Message:      "550 5.1.1 <nobody@example.com>: Recipient address rejected: User unknown in virtual mailbox table",
+	}, recipient.Reason())
+	assert.Equal(t, "nobody@example.com", recipient.FinalRecipient)
+}
+
+func TestParseSoftBounce(t *testing.T) {
+	report, err := Parse(readFixture(t, "soft-bounce.eml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "<550e8400-e29b-41d4-a716-446655440000@mailculator.example>", report.MessageID)
+
+	recipient, found := report.Failed()
+	require.True(t, found)
+	assert.Equal(t, failure.Reason{
+		Category:     failure.CategoryBounceSoft,
+		SMTPCode:     452,
+		EnhancedCode: "4.2.2",
+		Message:      "452 4.2.2 Mailbox full",
+	}, recipient.Reason())
+}
+
+func TestParseDelayIsNotAFailure(t *testing.T) {
+	report, err := Parse(readFixture(t, "delayed.eml"))
+	require.NoError(t, err)
+
+	_, found := report.Failed()
+	assert.False(t, found)
+	assert.Equal(t, "delayed", report.Recipients[0].Action)
+}
+
+func TestParseRejectsMessagesThatAreNotDSN(t *testing.T) {
+	_, err := Parse(readFixture(t, "autoreply.eml"))
+
+	assert.ErrorIs(t, err, ErrNotDSN)
+}
+
+func TestRecipientReasonWithoutDiagnostic(t *testing.T) {
+	reason := Recipient{FinalRecipient: "a@example.com", Action: ActionFailed, Status: "5.7.1"}.Reason()
+
+	assert.Equal(t, failure.Reason{
+		Category:     failure.CategoryBounceHard,
+		EnhancedCode: "5.7.1",
+		Message:      "delivery to a@example.com failed with status 5.7.1",
+	}, reason)
+}
+
+func TestVERPRoundTrip(t *testing.T) {
+	address := VERPAddress("bounces@mailculator.example", "4b1c7a52")
+	assert.Equal(t, "bounces+4b1c7a52@mailculator.example", address)
+
+	id, found := ParseVERP("bounces@mailculator.example", "bounces+4b1c7a52@MAILCULATOR.example")
+	assert.True(t, found)
+	assert.Equal(t, "4b1c7a52", id)
+
+	for _, other := range []string{"bounces@mailculator.example", "bounces+4b1c7a52@other.example", "sender@mailculator.example", "bounces+"} {
+		_, found = ParseVERP("bounces@mailculator.example", other)
+		assert.False(t, found, other)
+	}
+}
@@ -0,0 +1,144 @@
+package bounce
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// DefaultIMAPMailbox is the folder read when none is configured.
+const DefaultIMAPMailbox = "INBOX"
+
+type IMAPConfig struct {
+	// Addr is the host:port of the server
+	Addr     string
+	User     string
+	Password string
+	// Mailbox is the folder holding the notifications, INBOX when empty
+	Mailbox string
+	// TLS connects with implicit TLS, usually on port 993
+	TLS bool
+}
+
+// IMAP reads a folder over IMAP4rev1. Removed messages are flagged \Deleted and expunged at the end of the fetch,
+// the others are fetched with BODY.PEEK, keep their flags and are skipped.
+type IMAP struct {
+	cfg     IMAPConfig
+	timeout time.Duration
+}
+
+func NewIMAP(cfg IMAPConfig, timeout time.Duration) *IMAP {
+	return &IMAP{cfg: cfg, timeout: timeout}
+}
+
+func (s *IMAP) Fetch(ctx context.Context, limit int, handle Handler) (int, error) {
+	c, err := s.dial()
+	if err != nil {
+		return 0, fmt.Errorf("imap connection failed: %w", err)
+	}
+	defer func() { _ = c.Logout() }()
+	c.Timeout = s.timeout
+
+	if err := c.Login(s.cfg.User, s.cfg.Password); err != nil {
+		return 0, fmt.Errorf("imap login failed: %w", err)
+	}
+
+	mailbox := s.cfg.Mailbox
+	if mailbox == "" {
+		mailbox = DefaultIMAPMailbox
+	}
+	if _, err := c.Select(mailbox, false); err != nil {
+		return 0, fmt.Errorf("imap select %s failed: %w", mailbox, err)
+	}
+
+	uids, err := c.UidSearch(&imap.SearchCriteria{WithoutFlags: []string{imap.DeletedFlag}})
+	if err != nil {
+		return 0, fmt.Errorf("imap search failed: %w", err)
+	}
+	if len(uids) == 0 {
+		return 0, nil
+	}
+	slices.Sort(uids)
+
+	removed := new(imap.SeqSet)
+	count := 0
+	for next := 0; next < len(uids) && (limit <= 0 || count < limit) && ctx.Err() == nil; {
+		// fetch only as many bodies as can still be removed, the kept ones are skipped on the next round
+		size := len(uids) - next
+		if limit > 0 {
+			size = min(size, limit-count)
+		}
+		chunk := uids[next : next+size]
+		next += size
+
+		bodies, err := s.fetchBodies(c, chunk)
+		if err != nil {
+			return 0, err
+		}
+		for _, uid := range chunk {
+			if ctx.Err() != nil {
+				break
+			}
+			if raw, found := bodies[uid]; found && handle(raw) {
+				removed.AddNum(uid)
+				count++
+			}
+		}
+	}
+
+	if count == 0 {
+		return 0, ctx.Err()
+	}
+
+	flags := []interface{}{imap.DeletedFlag}
+	if err := c.UidStore(removed, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err != nil {
+		return 0, fmt.Errorf("imap store failed: %w", err)
+	}
+	if err := c.Expunge(nil); err != nil {
+		return 0, fmt.Errorf("imap expunge failed: %w", err)
+	}
+
+	return count, ctx.Err()
+}
+
+func (s *IMAP) dial() (*client.Client, error) {
+	dialer := &net.Dialer{Timeout: s.timeout}
+	if s.cfg.TLS {
+		host, _, _ := net.SplitHostPort(s.cfg.Addr)
+		return client.DialWithDialerTLS(dialer, s.cfg.Addr, &tls.Config{ServerName: host})
+	}
+	return client.DialWithDialer(dialer, s.cfg.Addr)
+}
+
+// fetchBodies reads the whole messages without setting \Seen.
+func (s *IMAP) fetchBodies(c *client.Client, uids []uint32) (map[uint32][]byte, error) {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+
+	section := &imap.BodySectionName{Peek: true}
+	messages := make(chan *imap.Message, len(uids))
+	if err := c.UidFetch(seqSet, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages); err != nil {
+		return nil, fmt.Errorf("imap fetch failed: %w", err)
+	}
+
+	bodies := map[uint32][]byte{}
+	for msg := range messages {
+		literal := msg.GetBody(section)
+		if literal == nil {
+			continue
+		}
+		raw, err := io.ReadAll(literal)
+		if err != nil {
+			return nil, fmt.Errorf("imap fetch failed: %w", err)
+		}
+		bodies[msg.Uid] = raw
+	}
+	return bodies, nil
+}
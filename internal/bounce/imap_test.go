@@ -0,0 +1,104 @@
+//go:build unit
+
+package bounce
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startIMAPServer serves the go-imap memory backend: user "username", password "password",
+// an INBOX holding one plain message, to which the fixtures are appended.
+func startIMAPServer(t *testing.T, fixtures ...string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	c, err := client.Dial(listener.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = c.Logout() }()
+	require.NoError(t, c.Login("username", "password"))
+	for _, name := range fixtures {
+		require.NoError(t, c.Append("INBOX", nil, time.Now(), bytes.NewBuffer(readFixture(t, name))))
+	}
+
+	return listener.Addr().String()
+}
+
+func inboxSize(t *testing.T, addr string) uint32 {
+	t.Helper()
+
+	c, err := client.Dial(addr)
+	require.NoError(t, err)
+	defer func() { _ = c.Logout() }()
+	require.NoError(t, c.Login("username", "password"))
+	status, err := c.Status("INBOX", []imap.StatusItem{imap.StatusMessages})
+	require.NoError(t, err)
+	return status.Messages
+}
+
+func TestIMAPFetchExpungesHandledMessages(t *testing.T) {
+	addr := startIMAPServer(t, "hard-bounce.eml", "soft-bounce.eml")
+	source, err := New(Config{Kind: KindIMAP, IMAP: IMAPConfig{Addr: addr, User: "username", Password: "password"}})
+	require.NoError(t, err)
+
+	var reports []Report
+	removed, err := source.Fetch(context.TODO(), 10, func(raw []byte) bool {
+		report, parseErr := Parse(raw)
+		if parseErr != nil {
+			return false
+		}
+		reports = append(reports, report)
+		return true
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	require.Len(t, reports, 2)
+	assert.Equal(t, "5.1.1", reports[0].Recipients[0].Status)
+	assert.Equal(t, "4.2.2", reports[1].Recipients[0].Status)
+	assert.Equal(t, uint32(1), inboxSize(t, addr))
+}
+
+func TestIMAPFetchSkipsKeptMessagesUpToLimit(t *testing.T) {
+	addr := startIMAPServer(t, "hard-bounce.eml", "soft-bounce.eml")
+
+	var messages [][]byte
+	removed, err := NewIMAP(IMAPConfig{Addr: addr, User: "username", Password: "password"}, time.Second).
+		Fetch(context.TODO(), 1, func(raw []byte) bool {
+			messages = append(messages, raw)
+			_, parseErr := Parse(raw)
+			return parseErr == nil
+		})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	require.Len(t, messages, 2)
+	assert.Contains(t, string(messages[0]), "Subject: A little message, just for you")
+	assert.Equal(t, uint32(2), inboxSize(t, addr))
+}
+
+func TestIMAPFetchFailsOnWrongPassword(t *testing.T) {
+	addr := startIMAPServer(t)
+
+	_, err := NewIMAP(IMAPConfig{Addr: addr, User: "username", Password: "wrong"}, time.Second).
+		Fetch(context.TODO(), 10, func([]byte) bool { return true })
+
+	assert.ErrorContains(t, err, "imap login failed")
+}
@@ -0,0 +1,88 @@
+package bounce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+type MaildirConfig struct {
+	// Path is the maildir root, holding the new and cur directories
+	Path string
+}
+
+// Maildir reads the messages a local MTA delivered to a maildir. Maildir file names start with the delivery
+// time, so sorting them reads the oldest first. Kept messages are left in place and skipped.
+type Maildir struct {
+	cfg MaildirConfig
+}
+
+func NewMaildir(cfg MaildirConfig) *Maildir {
+	return &Maildir{cfg: cfg}
+}
+
+func (m *Maildir) Fetch(ctx context.Context, limit int, handle Handler) (int, error) {
+	paths, err := m.list()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range paths {
+		if limit > 0 && removed >= limit {
+			break
+		}
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+
+		raw, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// removed by another reader of the maildir
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		if !handle(raw) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// list returns the messages of new and cur, oldest first.
+func (m *Maildir) list() ([]string, error) {
+	var names []string
+	dirs := map[string]string{}
+
+	for _, dir := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(m.cfg.Path, dir))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read maildir: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				names = append(names, entry.Name())
+				dirs[entry.Name()] = dir
+			}
+		}
+	}
+
+	slices.Sort(names)
+
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		paths = append(paths, filepath.Join(m.cfg.Path, dirs[name], name))
+	}
+	return paths, nil
+}
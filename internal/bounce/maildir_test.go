@@ -0,0 +1,92 @@
+//go:build unit
+
+package bounce
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMaildirFixture delivers the fixtures to a new maildir, in the given order.
+func newMaildirFixture(t *testing.T, fixtures ...string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for _, dir := range []string{"new", "cur", "tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(root, dir), 0o755))
+	}
+	for i, name := range fixtures {
+		path := filepath.Join(root, "new", fmt.Sprintf("17606952%02d.M1P1.mx", i))
+		require.NoError(t, os.WriteFile(path, readFixture(t, name), 0o644))
+	}
+	return root
+}
+
+func TestMaildirFetchRemovesHandledMessages(t *testing.T) {
+	root := newMaildirFixture(t, "hard-bounce.eml", "autoreply.eml", "soft-bounce.eml")
+	source, err := New(Config{Kind: KindMaildir, Maildir: MaildirConfig{Path: root}})
+	require.NoError(t, err)
+
+	var seen []string
+	removed, err := source.Fetch(context.TODO(), 10, func(raw []byte) bool {
+		_, parseErr := Parse(raw)
+		seen = append(seen, string(raw[:20]))
+		return parseErr == nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Len(t, seen, 3)
+
+	left, err := os.ReadDir(filepath.Join(root, "new"))
+	require.NoError(t, err)
+	require.Len(t, left, 1)
+	assert.Equal(t, "1760695201.M1P1.mx", left[0].Name())
+}
+
+func TestMaildirFetchReadsOldestFirstUpToLimit(t *testing.T) {
+	root := newMaildirFixture(t, "hard-bounce.eml", "soft-bounce.eml")
+
+	var messages [][]byte
+	removed, err := NewMaildir(MaildirConfig{Path: root}).Fetch(context.TODO(), 1, func(raw []byte) bool {
+		messages = append(messages, raw)
+		return true
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	require.Len(t, messages, 1)
+	assert.Equal(t, readFixture(t, "hard-bounce.eml"), messages[0])
+}
+
+func TestMaildirFetchSkipsKeptMessages(t *testing.T) {
+	root := newMaildirFixture(t, "autoreply.eml", "hard-bounce.eml", "soft-bounce.eml")
+
+	var messages [][]byte
+	removed, err := NewMaildir(MaildirConfig{Path: root}).Fetch(context.TODO(), 1, func(raw []byte) bool {
+		messages = append(messages, raw)
+		_, parseErr := Parse(raw)
+		return parseErr == nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	require.Len(t, messages, 2)
+	assert.Equal(t, readFixture(t, "hard-bounce.eml"), messages[1])
+
+	left, err := os.ReadDir(filepath.Join(root, "new"))
+	require.NoError(t, err)
+	assert.Len(t, left, 2)
+}
+
+func TestMaildirFetchFailsWithoutMaildir(t *testing.T) {
+	_, err := NewMaildir(MaildirConfig{Path: filepath.Join(t.TempDir(), "missing")}).Fetch(context.TODO(), 1, func([]byte) bool { return true })
+
+	assert.ErrorContains(t, err, "failed to read maildir")
+}
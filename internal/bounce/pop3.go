@@ -0,0 +1,151 @@
+package bounce
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type POP3Config struct {
+	// Addr is the host:port of the server
+	Addr     string
+	User     string
+	Password string
+	// TLS connects with implicit TLS, usually on port 995
+	TLS bool
+}
+
+// POP3 reads the mailbox over POP3 (RFC 1939). The deletions are committed by QUIT only:
+// a fetch interrupted by an error leaves every message in the mailbox.
+type POP3 struct {
+	cfg     POP3Config
+	timeout time.Duration
+}
+
+func NewPOP3(cfg POP3Config, timeout time.Duration) *POP3 {
+	return &POP3{cfg: cfg, timeout: timeout}
+}
+
+func (s *POP3) Fetch(ctx context.Context, limit int, handle Handler) (int, error) {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("pop3 connection failed: %w", err)
+	}
+	defer conn.Close()
+
+	client := &pop3Conn{conn: conn, text: textproto.NewConn(conn), timeout: s.timeout}
+
+	if _, err := client.reply(); err != nil {
+		return 0, fmt.Errorf("pop3 greeting failed: %w", err)
+	}
+	if _, err := client.cmd("USER %s", s.cfg.User); err != nil {
+		return 0, fmt.Errorf("pop3 authentication failed: %w", err)
+	}
+	if _, err := client.cmd("PASS %s", s.cfg.Password); err != nil {
+		return 0, fmt.Errorf("pop3 authentication failed: %w", err)
+	}
+
+	stat, err := client.cmd("STAT")
+	if err != nil {
+		return 0, fmt.Errorf("pop3 STAT failed: %w", err)
+	}
+	count, err := strconv.Atoi(strings.Fields(stat + " ")[0])
+	if err != nil {
+		return 0, fmt.Errorf("pop3 STAT failed: unexpected reply %q", stat)
+	}
+
+	removed := 0
+	for i := 1; i <= count; i++ {
+		if limit > 0 && removed >= limit {
+			break
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		raw, err := client.retrieve(i)
+		if err != nil {
+			return 0, fmt.Errorf("pop3 RETR failed: %w", err)
+		}
+		if !handle(raw) {
+			continue
+		}
+		if _, err := client.cmd("DELE %d", i); err != nil {
+			return 0, fmt.Errorf("pop3 DELE failed: %w", err)
+		}
+		removed++
+	}
+
+	if _, err := client.cmd("QUIT"); err != nil {
+		return 0, fmt.Errorf("pop3 QUIT failed: %w", err)
+	}
+	return removed, nil
+}
+
+func (s *POP3) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.timeout}
+	if s.cfg.TLS {
+		host, _, _ := net.SplitHostPort(s.cfg.Addr)
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		return tlsDialer.DialContext(ctx, "tcp", s.cfg.Addr)
+	}
+	return dialer.DialContext(ctx, "tcp", s.cfg.Addr)
+}
+
+type pop3Conn struct {
+	conn    net.Conn
+	text    *textproto.Conn
+	timeout time.Duration
+}
+
+// cmd sends a command and returns the text following +OK.
+func (c *pop3Conn) cmd(format string, args ...any) (string, error) {
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.reply()
+}
+
+func (c *pop3Conn) reply() (string, error) {
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if text, found := strings.CutPrefix(line, "+OK"); found {
+		return strings.TrimSpace(text), nil
+	}
+	return "", errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+}
+
+// retrieve returns the message i, read from the dot-stuffed multi-line reply with its CRLF line endings.
+func (c *pop3Conn) retrieve(i int) ([]byte, error) {
+	if _, err := c.cmd("RETR %d", i); err != nil {
+		return nil, err
+	}
+
+	var raw bytes.Buffer
+	for {
+		_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+		line, err := c.text.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "." {
+			return raw.Bytes(), nil
+		}
+		if strings.HasPrefix(line, ".") {
+			line = line[1:]
+		}
+		raw.WriteString(line)
+		raw.WriteString("\r\n")
+	}
+}
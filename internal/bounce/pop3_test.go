@@ -0,0 +1,166 @@
+//go:build unit
+
+package bounce
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePOP3 serves a single mailbox, deleting the messages marked by DELE when the client sends QUIT.
+type fakePOP3 struct {
+	mu       sync.Mutex
+	messages [][]byte
+	password string
+}
+
+func startFakePOP3(t *testing.T, password string, messages ...[]byte) (*fakePOP3, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	server := &fakePOP3{messages: messages, password: password}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server, listener.Addr().String()
+}
+
+func (s *fakePOP3) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	deleted := map[int]bool{}
+
+	_ = text.PrintfLine("+OK fake pop3 ready")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, arg, _ := strings.Cut(line, " ")
+
+		s.mu.Lock()
+		switch strings.ToUpper(command) {
+		case "USER":
+			_ = text.PrintfLine("+OK")
+		case "PASS":
+			if arg != s.password {
+				_ = text.PrintfLine("-ERR invalid credentials")
+			} else {
+				_ = text.PrintfLine("+OK logged in")
+			}
+		case "STAT":
+			_ = text.PrintfLine("+OK %d 0", len(s.messages))
+		case "RETR":
+			var i int
+			_, _ = fmt.Sscan(arg, &i)
+			_ = text.PrintfLine("+OK")
+			writer := text.DotWriter()
+			_, _ = writer.Write(s.messages[i-1])
+			_ = writer.Close()
+		case "DELE":
+			var i int
+			_, _ = fmt.Sscan(arg, &i)
+			deleted[i] = true
+			_ = text.PrintfLine("+OK")
+		case "QUIT":
+			var kept [][]byte
+			for i, msg := range s.messages {
+				if !deleted[i+1] {
+					kept = append(kept, msg)
+				}
+			}
+			s.messages = kept
+			_ = text.PrintfLine("+OK bye")
+			s.mu.Unlock()
+			return
+		default:
+			_ = text.PrintfLine("-ERR unknown command")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *fakePOP3) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages)
+}
+
+func TestPOP3FetchDeletesHandledMessagesOnQuit(t *testing.T) {
+	// a line starting with a dot checks the dot-stuffing of RETR
+	autoreply := append(readFixture(t, "autoreply.eml"), []byte(".signature\r\n")...)
+	server, addr := startFakePOP3(t, "secret", readFixture(t, "hard-bounce.eml"), autoreply)
+	source, err := New(Config{Kind: KindPOP3, POP3: POP3Config{Addr: addr, User: "bounces", Password: "secret"}})
+	require.NoError(t, err)
+
+	var messages [][]byte
+	removed, err := source.Fetch(context.TODO(), 10, func(raw []byte) bool {
+		messages = append(messages, raw)
+		_, parseErr := Parse(raw)
+		return parseErr == nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	require.Len(t, messages, 2)
+	assert.Equal(t, readFixture(t, "hard-bounce.eml"), messages[0])
+	assert.Equal(t, autoreply, messages[1])
+	assert.Equal(t, 1, server.count())
+}
+
+func TestPOP3FetchSkipsKeptMessagesUpToLimit(t *testing.T) {
+	server, addr := startFakePOP3(t, "secret", readFixture(t, "autoreply.eml"), readFixture(t, "hard-bounce.eml"), readFixture(t, "soft-bounce.eml"))
+
+	var messages [][]byte
+	removed, err := NewPOP3(POP3Config{Addr: addr, User: "bounces", Password: "secret"}, time.Second).
+		Fetch(context.TODO(), 1, func(raw []byte) bool {
+			messages = append(messages, raw)
+			_, parseErr := Parse(raw)
+			return parseErr == nil
+		})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	require.Len(t, messages, 2)
+	assert.Equal(t, readFixture(t, "hard-bounce.eml"), messages[1])
+	assert.Equal(t, 2, server.count())
+}
+
+func TestPOP3FetchFailsOnWrongPassword(t *testing.T) {
+	server, addr := startFakePOP3(t, "secret", readFixture(t, "hard-bounce.eml"))
+
+	_, err := NewPOP3(POP3Config{Addr: addr, User: "bounces", Password: "wrong"}, time.Second).
+		Fetch(context.TODO(), 10, func([]byte) bool { return true })
+
+	assert.ErrorContains(t, err, "pop3 authentication failed: invalid credentials")
+	assert.Equal(t, 1, server.count())
+}
+
+func TestPOP3FetchFailsWhenServerIsDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	_, err = NewPOP3(POP3Config{Addr: addr}, time.Second).Fetch(context.TODO(), 10, func([]byte) bool { return true })
+
+	assert.ErrorContains(t, err, "pop3 connection failed")
+}
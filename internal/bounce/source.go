@@ -0,0 +1,57 @@
+package bounce
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	KindMaildir = "maildir"
+	KindIMAP    = "imap"
+	KindPOP3    = "pop3"
+)
+
+// DefaultTimeout bounds every exchange with an IMAP or POP3 server.
+const DefaultTimeout = 30 * time.Second
+
+// Handler processes a raw message and reports whether it can be removed from the mailbox.
+// A message it keeps is passed again on a later fetch.
+type Handler func(raw []byte) bool
+
+// Source is the mailbox the delivery status notifications are read from.
+type Source interface {
+	// Fetch passes the messages to handle, oldest first, and removes the ones handle returns true for,
+	// until limit messages are removed. The kept messages do not count against limit, so they cannot
+	// hold back the newer ones. It returns the number of removed messages.
+	Fetch(ctx context.Context, limit int, handle Handler) (int, error)
+}
+
+type Config struct {
+	// Kind is maildir, imap or pop3
+	Kind    string
+	Timeout time.Duration
+	Maildir MaildirConfig
+	IMAP    IMAPConfig
+	POP3    POP3Config
+}
+
+// New builds the source of the configured kind. Remote mailboxes are connected on every fetch,
+// so an unreachable server does not prevent the processor from starting.
+func New(cfg Config) (Source, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	switch cfg.Kind {
+	case KindMaildir:
+		return NewMaildir(cfg.Maildir), nil
+	case KindIMAP:
+		return NewIMAP(cfg.IMAP, timeout), nil
+	case KindPOP3:
+		return NewPOP3(cfg.POP3, timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown bounce source %q", cfg.Kind)
+	}
+}
@@ -0,0 +1,29 @@
+package bounce
+
+import "strings"
+
+// VERPAddress encodes id in the local part of base, bounces@example.com becomes bounces+id@example.com,
+// so the notification returned to that address names the email it is about.
+func VERPAddress(base string, id string) string {
+	local, domain, _ := strings.Cut(base, "@")
+	return local + "+" + id + "@" + domain
+}
+
+// ParseVERP returns the id encoded by VERPAddress in address, false when address is not derived from base.
+func ParseVERP(base string, address string) (string, bool) {
+	baseLocal, baseDomain, found := strings.Cut(base, "@")
+	if !found {
+		return "", false
+	}
+
+	at := strings.LastIndex(address, "@")
+	if at < 0 || !strings.EqualFold(address[at+1:], baseDomain) {
+		return "", false
+	}
+
+	id, found := strings.CutPrefix(address[:at], baseLocal+"+")
+	if !found || id == "" {
+		return "", false
+	}
+	return id, true
+}
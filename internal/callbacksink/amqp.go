@@ -0,0 +1,196 @@
+package callbacksink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type AMQPConfig struct {
+	URL        string
+	Exchange   string
+	RoutingKey string
+}
+
+// amqpPublisher publishes on a channel in confirm mode and waits for the broker confirm.
+// A message the broker could not route is reported with an amqpReturnedError.
+type amqpPublisher interface {
+	Publish(ctx context.Context, exchange string, key string, msg amqp.Publishing) (bool, error)
+	IsClosed() bool
+	Close() error
+}
+
+// amqpReturnedError is a mandatory message the broker returned because no queue is bound to its routing key.
+type amqpReturnedError struct {
+	code uint16
+	text string
+}
+
+func (e *amqpReturnedError) Error() string {
+	return fmt.Sprintf("amqp broker returned the message: %d %s", e.code, e.text)
+}
+
+// AMQP publishes the callback as a persistent, mandatory message on an AMQP 0-9-1 exchange and waits for
+// the publisher confirm. A message that reaches no queue is returned by the broker and fails the delivery.
+type AMQP struct {
+	cfg       AMQPConfig
+	timeout   time.Duration
+	dial      func(url string) (amqpPublisher, error)
+	mu        sync.Mutex
+	publisher amqpPublisher
+}
+
+func NewAMQP(cfg AMQPConfig, timeout time.Duration) *AMQP {
+	return &AMQP{cfg: cfg, timeout: timeout, dial: dialAMQP}
+}
+
+// Deliver returns a nil error once the broker confirms the message and routed it to a queue. A failed publish
+// drops the connection, the next delivery dials again; a returned message keeps it, the channel is healthy.
+func (s *AMQP) Deliver(ctx context.Context, msg Message) (Receipt, error) {
+	publisher, err := s.connect()
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	acked, err := publisher.Publish(ctx, s.cfg.Exchange, s.cfg.RoutingKey, amqpPublishing(msg))
+	var returned *amqpReturnedError
+	if errors.As(err, &returned) {
+		return Receipt{}, err
+	}
+	if err != nil {
+		s.reset(publisher)
+		return Receipt{}, fmt.Errorf("amqp publish failed: %w", err)
+	}
+	if !acked {
+		return Receipt{}, errors.New("amqp broker nacked the message")
+	}
+	return Receipt{}, nil
+}
+
+func (s *AMQP) Ping(_ context.Context) error {
+	_, err := s.connect()
+	return err
+}
+
+func (s *AMQP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.publisher == nil {
+		return nil
+	}
+	err := s.publisher.Close()
+	s.publisher = nil
+	return err
+}
+
+func (s *AMQP) connect() (amqpPublisher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.publisher != nil && !s.publisher.IsClosed() {
+		return s.publisher, nil
+	}
+
+	publisher, err := s.dial(s.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("amqp connection failed: %w", err)
+	}
+	s.publisher = publisher
+	return publisher, nil
+}
+
+// reset drops a publisher that failed, unless another delivery already replaced it.
+func (s *AMQP) reset(publisher amqpPublisher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.publisher == publisher {
+		_ = publisher.Close()
+		s.publisher = nil
+	}
+}
+
+// amqpPublishing maps the callback on a persistent message, headers become message headers.
+func amqpPublishing(msg Message) amqp.Publishing {
+	headers := amqp.Table{}
+	for name, values := range msg.Headers {
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	return amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.Headers.Get("Content-Type"),
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.Key,
+		Timestamp:    time.Now(),
+		Body:         msg.Body,
+	}
+}
+
+// amqpChannel publishes one message at a time: the broker sends basic.return before the confirm of
+// the same message, so a return found after the confirm belongs to the message just published.
+type amqpChannel struct {
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	returns chan amqp.Return
+	mu      sync.Mutex
+}
+
+func dialAMQP(url string) (amqpPublisher, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err == nil {
+		err = ch.Confirm(false)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+	return &amqpChannel{conn: conn, ch: ch, returns: returns}, nil
+}
+
+func (c *amqpChannel) Publish(ctx context.Context, exchange string, key string, msg amqp.Publishing) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	confirm, err := c.ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		return false, err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	select {
+	case ret, open := <-c.returns:
+		if open {
+			return false, &amqpReturnedError{code: ret.ReplyCode, text: ret.ReplyText}
+		}
+	default:
+	}
+	return acked, nil
+}
+
+func (c *amqpChannel) IsClosed() bool {
+	return c.conn.IsClosed() || c.ch.IsClosed()
+}
+
+func (c *amqpChannel) Close() error {
+	return c.conn.Close()
+}
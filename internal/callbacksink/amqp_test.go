@@ -0,0 +1,114 @@
+//go:build unit
+
+package callbacksink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBroker stands in for an AMQP channel in confirm mode.
+type fakeBroker struct {
+	published  []amqp.Publishing
+	exchange   string
+	routingKey string
+	acked      bool
+	publishErr error
+	closed     bool
+}
+
+func (b *fakeBroker) Publish(_ context.Context, exchange string, key string, msg amqp.Publishing) (bool, error) {
+	if b.publishErr != nil {
+		return false, b.publishErr
+	}
+	b.exchange, b.routingKey = exchange, key
+	b.published = append(b.published, msg)
+	return b.acked, nil
+}
+
+func (b *fakeBroker) IsClosed() bool {
+	return b.closed
+}
+
+func (b *fakeBroker) Close() error {
+	b.closed = true
+	return nil
+}
+
+func newTestAMQP(brokers ...*fakeBroker) (*AMQP, *int) {
+	dials := 0
+	sink := NewAMQP(AMQPConfig{URL: "amqp://localhost", Exchange: "mailculator", RoutingKey: "callbacks"}, time.Second)
+	sink.dial = func(string) (amqpPublisher, error) {
+		if dials >= len(brokers) {
+			return nil, errors.New("connection refused")
+		}
+		dials++
+		return brokers[dials-1], nil
+	}
+	return sink, &dials
+}
+
+func TestAMQPDeliverAcknowledgesOnConfirm(t *testing.T) {
+	broker := &fakeBroker{acked: true}
+	sink, _ := newTestAMQP(broker)
+
+	_, err := sink.Deliver(context.TODO(), testMessage())
+
+	require.NoError(t, err)
+	require.Len(t, broker.published, 1)
+	assert.Equal(t, "mailculator", broker.exchange)
+	assert.Equal(t, "callbacks", broker.routingKey)
+	published := broker.published[0]
+	assert.Equal(t, []byte(`{"message_ids":["1"]}`), published.Body)
+	assert.Equal(t, "application/json", published.ContentType)
+	assert.Equal(t, "1", published.MessageId)
+	assert.Equal(t, amqp.Persistent, published.DeliveryMode)
+	assert.Equal(t, "processor", published.Headers["X-Source"])
+}
+
+func TestAMQPDeliverFailsOnNack(t *testing.T) {
+	sink, _ := newTestAMQP(&fakeBroker{acked: false})
+
+	_, err := sink.Deliver(context.TODO(), testMessage())
+
+	assert.EqualError(t, err, "amqp broker nacked the message")
+}
+
+func TestAMQPDeliverRedialsAfterFailedPublish(t *testing.T) {
+	broken := &fakeBroker{publishErr: errors.New("channel closed")}
+	healthy := &fakeBroker{acked: true}
+	sink, dials := newTestAMQP(broken, healthy)
+
+	_, err := sink.Deliver(context.TODO(), testMessage())
+	assert.EqualError(t, err, "amqp publish failed: channel closed")
+	assert.True(t, broken.closed)
+
+	_, err = sink.Deliver(context.TODO(), testMessage())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *dials)
+	assert.Len(t, healthy.published, 1)
+}
+
+func TestAMQPDeliverFailsOnReturnedMessageAndKeepsConnection(t *testing.T) {
+	broker := &fakeBroker{acked: true, publishErr: &amqpReturnedError{code: 312, text: "NO_ROUTE"}}
+	sink, dials := newTestAMQP(broker)
+
+	_, err := sink.Deliver(context.TODO(), testMessage())
+
+	assert.EqualError(t, err, "amqp broker returned the message: 312 NO_ROUTE")
+	assert.False(t, broker.closed)
+	require.NoError(t, sink.Ping(context.TODO()))
+	assert.Equal(t, 1, *dials)
+}
+
+func TestAMQPPingReportsUnreachableBroker(t *testing.T) {
+	sink, _ := newTestAMQP()
+
+	assert.EqualError(t, sink.Ping(context.TODO()), "amqp connection failed: connection refused")
+}
@@ -0,0 +1,73 @@
+package callbacksink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxResponseReason bounds how much of a failed response body is kept in the error
+const maxResponseReason = 512
+
+// maxResponseBody bounds how much of a successful response body is read, for the per-email results of a batch
+const maxResponseBody = 1 << 20
+
+// HTTP posts the callback to the message destination.
+type HTTP struct {
+	client *http.Client
+}
+
+func NewHTTP(timeout time.Duration) *HTTP {
+	return &HTTP{client: &http.Client{Timeout: timeout}}
+}
+
+// Deliver returns a nil error only for a 2xx response.
+func (s *HTTP) Deliver(ctx context.Context, msg Message) (Receipt, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.Destination, bytes.NewReader(msg.Body))
+	if err != nil {
+		return Receipt{}, fmt.Errorf("error during request creation: %w", err)
+	}
+	req.Header = msg.Headers.Clone()
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Receipt{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+		return Receipt{StatusCode: resp.StatusCode, Body: body}, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseReason))
+	receipt := Receipt{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		Body:       body,
+	}
+	return receipt, fmt.Errorf("status %d, response: %s", resp.StatusCode, body)
+}
+
+func (s *HTTP) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
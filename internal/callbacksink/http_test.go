@@ -0,0 +1,80 @@
+//go:build unit
+
+package callbacksink
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMessage() Message {
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
+	headers.Set("X-Source", "processor")
+	return Message{Key: "1", Body: []byte(`{"message_ids":["1"]}`), Headers: headers}
+}
+
+func TestHTTPDeliverAcknowledgesOn2xx(t *testing.T) {
+	var body []byte
+	var source string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		source = r.Header.Get("X-Source")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}))
+	defer server.Close()
+
+	msg := testMessage()
+	msg.Destination = server.URL
+	receipt, err := NewHTTP(time.Second).Deliver(context.TODO(), msg)
+
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, receipt.StatusCode)
+	assert.Equal(t, `{"results":[]}`, string(receipt.Body))
+	assert.Equal(t, msg.Body, body)
+	assert.Equal(t, "processor", source)
+}
+
+func TestHTTPDeliverFailsOnNon2xx(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("busy"))
+	}))
+	defer server.Close()
+
+	msg := testMessage()
+	msg.Destination = server.URL
+	receipt, err := NewHTTP(time.Second).Deliver(context.TODO(), msg)
+
+	assert.EqualError(t, err, "status 503, response: busy")
+	assert.Equal(t, http.StatusServiceUnavailable, receipt.StatusCode)
+	assert.Equal(t, 30*time.Second, receipt.RetryAfter)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Wed, 01 Jan 2025 10:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Wed, 01 Jan 2025 09:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestNewRejectsUnknownKind(t *testing.T) {
+	_, err := New(Config{Kind: "smoke-signals"})
+	assert.EqualError(t, err, `unknown callback sink "smoke-signals"`)
+
+	sink, err := New(Config{})
+	require.NoError(t, err)
+	assert.IsType(t, &HTTP{}, sink)
+}
@@ -0,0 +1,63 @@
+package callbacksink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type KafkaConfig struct {
+	Brokers []string
+	Topic   string
+}
+
+// Kafka produces the callback on a topic, keyed by the email id, with acks from all in-sync replicas.
+type Kafka struct {
+	cfg     KafkaConfig
+	timeout time.Duration
+	client  *kgo.Client
+}
+
+// NewKafka does not contact the brokers, the client connects on the first delivery.
+func NewKafka(cfg KafkaConfig, timeout time.Duration) (*Kafka, error) {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.DefaultProduceTopic(cfg.Topic),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.ProducerLinger(0),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("kafka client creation failed: %w", err)
+	}
+
+	return &Kafka{cfg: cfg, timeout: timeout, client: client}, nil
+}
+
+// Deliver returns a nil error once the record is acknowledged by all in-sync replicas.
+func (s *Kafka) Deliver(ctx context.Context, msg Message) (Receipt, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	record := &kgo.Record{Key: []byte(msg.Key), Value: msg.Body}
+	for name, values := range msg.Headers {
+		for _, value := range values {
+			record.Headers = append(record.Headers, kgo.RecordHeader{Key: name, Value: []byte(value)})
+		}
+	}
+
+	if err := s.client.ProduceSync(ctx, record).FirstErr(); err != nil {
+		return Receipt{}, fmt.Errorf("kafka produce failed: %w", err)
+	}
+	return Receipt{}, nil
+}
+
+func (s *Kafka) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx)
+}
+
+func (s *Kafka) Close() error {
+	s.client.Close()
+	return nil
+}
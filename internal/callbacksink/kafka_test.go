@@ -0,0 +1,62 @@
+//go:build unit
+
+package callbacksink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestKafkaDeliverAcknowledgesOnProduce(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "mailculator-callbacks"))
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	sink, err := NewKafka(KafkaConfig{Brokers: cluster.ListenAddrs(), Topic: "mailculator-callbacks"}, 5*time.Second)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	_, err = sink.Deliver(context.TODO(), testMessage())
+	require.NoError(t, err)
+	assert.NoError(t, sink.Ping(context.TODO()))
+
+	consumer, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumeTopics("mailculator-callbacks"))
+	require.NoError(t, err)
+	defer consumer.Close()
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	fetches := consumer.PollFetches(ctx)
+	require.NoError(t, fetches.Err())
+	records := fetches.Records()
+	require.Len(t, records, 1)
+
+	assert.Equal(t, "1", string(records[0].Key))
+	assert.Equal(t, []byte(`{"message_ids":["1"]}`), records[0].Value)
+	headers := map[string]string{}
+	for _, header := range records[0].Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	assert.Equal(t, map[string]string{"Content-Type": "application/json", "X-Source": "processor"}, headers)
+}
+
+func TestKafkaDeliverFailsWhenBrokerIsDown(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "mailculator-callbacks"))
+	require.NoError(t, err)
+	addrs := cluster.ListenAddrs()
+	cluster.Close()
+
+	sink, err := NewKafka(KafkaConfig{Brokers: addrs, Topic: "mailculator-callbacks"}, 500*time.Millisecond)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	_, err = sink.Deliver(context.TODO(), testMessage())
+
+	assert.ErrorContains(t, err, "kafka produce failed")
+}
@@ -0,0 +1,74 @@
+package callbacksink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type NATSConfig struct {
+	URL     string
+	Subject string
+}
+
+// NATS publishes the callback on a JetStream subject and waits for the stream ack.
+// A stream must capture the subject, otherwise every delivery fails with no responders.
+type NATS struct {
+	cfg     NATSConfig
+	timeout time.Duration
+	conn    *nats.Conn
+	js      jetstream.JetStream
+}
+
+// NewNATS connects in the background: the client keeps reconnecting while the server is unreachable.
+func NewNATS(cfg NATSConfig, timeout time.Duration) (*NATS, error) {
+	conn, err := nats.Connect(cfg.URL,
+		nats.Name("mailculator-processor"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("nats connection failed: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats jetstream unavailable: %w", err)
+	}
+
+	return &NATS{cfg: cfg, timeout: timeout, conn: conn, js: js}, nil
+}
+
+// Deliver returns a nil error once the stream stored the message.
+func (s *NATS) Deliver(ctx context.Context, msg Message) (Receipt, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	natsMsg := nats.NewMsg(s.cfg.Subject)
+	natsMsg.Data = msg.Body
+	natsMsg.Header = nats.Header(msg.Headers.Clone())
+
+	if _, err := s.js.PublishMsg(ctx, natsMsg); err != nil {
+		return Receipt{}, fmt.Errorf("nats publish failed: %w", err)
+	}
+	return Receipt{}, nil
+}
+
+func (s *NATS) Ping(ctx context.Context) error {
+	if status := s.conn.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("nats connection is %v", status)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.conn.FlushWithContext(ctx)
+}
+
+func (s *NATS) Close() error {
+	s.conn.Close()
+	return nil
+}
@@ -0,0 +1,63 @@
+//go:build unit
+
+package callbacksink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startNATSServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+
+	go srv.Start()
+	require.True(t, srv.ReadyForConnections(5*time.Second))
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+func TestNATSDeliverAcknowledgesOnStreamAck(t *testing.T) {
+	srv := startNATSServer(t)
+	sink, err := NewNATS(NATSConfig{URL: srv.ClientURL(), Subject: "mailculator.callbacks"}, time.Second)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	stream, err := sink.js.CreateStream(context.TODO(), jetstream.StreamConfig{Name: "CALLBACKS", Subjects: []string{"mailculator.callbacks"}})
+	require.NoError(t, err)
+
+	_, err = sink.Deliver(context.TODO(), testMessage())
+	require.NoError(t, err)
+
+	stored, err := stream.GetLastMsgForSubject(context.TODO(), "mailculator.callbacks")
+	require.NoError(t, err)
+	assert.Equal(t, []byte(`{"message_ids":["1"]}`), stored.Data)
+	assert.Equal(t, "processor", stored.Header.Get("X-Source"))
+	assert.NoError(t, sink.Ping(context.TODO()))
+}
+
+func TestNATSDeliverFailsWithoutStream(t *testing.T) {
+	srv := startNATSServer(t)
+	sink, err := NewNATS(NATSConfig{URL: srv.ClientURL(), Subject: "mailculator.callbacks"}, time.Second)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	_, err = sink.Deliver(context.TODO(), testMessage())
+
+	assert.ErrorIs(t, err, jetstream.ErrNoStreamResponse)
+}
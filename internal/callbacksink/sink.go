@@ -0,0 +1,83 @@
+// Package callbacksink delivers the rendered callbacks to the receivers: an HTTP endpoint or a message broker.
+// Every sink carries the same body and headers, so a consumer can switch transport without changing its parser.
+package callbacksink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	KindHTTP  = "http"
+	KindAMQP  = "amqp"
+	KindNATS  = "nats"
+	KindKafka = "kafka"
+)
+
+// DefaultTimeout bounds a delivery, including the wait for the broker acknowledgment.
+const DefaultTimeout = 10 * time.Second
+
+// Message is a rendered callback, already signed and carrying the trace context.
+type Message struct {
+	// Destination is the URL the http sink posts to; the broker sinks publish to their configured target
+	Destination string
+	// Key identifies the message, the first email of the request; Kafka partitions by it
+	Key     string
+	Body    []byte
+	Headers http.Header
+}
+
+// Receipt is what the receiver answered to a delivery.
+type Receipt struct {
+	// StatusCode is the HTTP status, 0 when no response was received and for the broker sinks
+	StatusCode int
+	// RetryAfter is the wait asked by the receiver, 0 when none
+	RetryAfter time.Duration
+	// Body is the response body, limited in size
+	Body []byte
+}
+
+// Sink delivers callbacks. A nil error means the receiver acknowledged the message: a 2xx response for http,
+// a publisher confirm for AMQP, a JetStream ack for NATS and an ack from all in-sync replicas for Kafka.
+type Sink interface {
+	Deliver(ctx context.Context, msg Message) (Receipt, error)
+	Close() error
+}
+
+// Pinger is implemented by the sinks that keep a connection to a broker, to report it on /readyz.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type Config struct {
+	// Kind is http, amqp, nats or kafka; empty means http
+	Kind    string
+	Timeout time.Duration
+	AMQP    AMQPConfig
+	NATS    NATSConfig
+	Kafka   KafkaConfig
+}
+
+// New builds the sink of the configured kind. Broker sinks connect lazily or in the background,
+// so an unreachable broker does not prevent the processor from starting.
+func New(cfg Config) (Sink, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	switch cfg.Kind {
+	case "", KindHTTP:
+		return NewHTTP(timeout), nil
+	case KindAMQP:
+		return NewAMQP(cfg.AMQP, timeout), nil
+	case KindNATS:
+		return NewNATS(cfg.NATS, timeout)
+	case KindKafka:
+		return NewKafka(cfg.Kafka, timeout)
+	default:
+		return nil, fmt.Errorf("unknown callback sink %q", cfg.Kind)
+	}
+}
@@ -11,32 +11,186 @@ import (
 
 	"github.com/go-playground/validator/v10"
 
+	"mailculator-processor/internal/admin"
+	"mailculator-processor/internal/bounce"
+	"mailculator-processor/internal/callbacksink"
+	"mailculator-processor/internal/healthcheck"
+	"mailculator-processor/internal/ingestion"
 	"mailculator-processor/internal/pipeline"
 	"mailculator-processor/internal/smtp"
+	"mailculator-processor/internal/tracing"
+	"mailculator-processor/internal/wakeup"
 )
 
+// CallbacksConfig is validated by validateCallbacksConfig: the body and header templates must parse
+// and the selected sink must have its destination.
 type CallbacksConfig struct {
-	MaxRetries    int    `yaml:"max_retries" validate:"required"`
-	RetryInterval int    `yaml:"retry_interval" validate:"required"`
-	Url           string `yaml:"url" validate:"required"`
+	MaxRetries    int `yaml:"max_retries" validate:"required"`
+	RetryInterval int `yaml:"retry_interval" validate:"required"`
+	// MaxRetryInterval caps the exponential backoff and Retry-After in seconds, default 3600
+	MaxRetryInterval int `yaml:"max_retry_interval" validate:"gte=0"`
+	// Url is required by the http sink
+	Url string `yaml:"url"`
+	// AllowedHosts are the hosts a payload callback_url may point to, "*.example.com" allows the subdomains
+	AllowedHosts []string `yaml:"allowed_hosts" validate:"dive,required"`
+	// SigningSecrets sign every request, more than one while a secret is being rotated
+	SigningSecrets []string `yaml:"signing_secrets" validate:"dive,min=32"`
+	// BodyTemplate is a text/template for the request body, empty uses the built-in preset
+	BodyTemplate string `yaml:"body_template"`
+	// Headers are sent with every request, values may be templates
+	Headers map[string]string   `yaml:"headers"`
+	Batch   CallbackBatchConfig `yaml:"batch"`
+	// Sink is where the callbacks are delivered: http (default), amqp, nats or kafka
+	Sink  string              `yaml:"sink" validate:"omitempty,oneof=http amqp nats kafka"`
+	AMQP  CallbackAMQPConfig  `yaml:"amqp"`
+	NATS  CallbackNATSConfig  `yaml:"nats"`
+	Kafka CallbackKafkaConfig `yaml:"kafka"`
 }
 
+type CallbackAMQPConfig struct {
+	Url string `yaml:"url"`
+	// Exchange is empty for the default exchange, which routes to the queue named as the routing key
+	Exchange   string `yaml:"exchange"`
+	RoutingKey string `yaml:"routing_key"`
+}
+
+type CallbackNATSConfig struct {
+	Url string `yaml:"url"`
+	// Subject must be captured by a JetStream stream
+	Subject string `yaml:"subject"`
+}
+
+type CallbackKafkaConfig struct {
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+}
+
+// CallbackBatchConfig groups emails going to the same URL in one request, disabled when max_size is 0 or 1.
+type CallbackBatchConfig struct {
+	MaxSize int `yaml:"max_size" validate:"gte=0"`
+	// MaxWait is how long an incomplete batch waits for more emails, in seconds
+	MaxWait int `yaml:"max_wait" validate:"gte=0"`
+}
+
+type AdminServerConfig struct {
+	Port int `yaml:"port"`
+}
+
+type AdminOperatorConfig struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+}
+
+// AdminConfig is validated by validateAdminConfig: operators are only required when the admin API is enabled.
+type AdminConfig struct {
+	Server    AdminServerConfig     `yaml:"server"`
+	Operators []AdminOperatorConfig `yaml:"operators"`
+}
+
+const minAdminTokenLength = 16
+
+const defaultMaxIdleInterval = 30
+
+const defaultShutdownGracePeriod = 30
+
+const defaultQueueDepthInterval = 15
+
+const defaultMaxRetryInterval = 3600
+
+const (
+	defaultCheckTimeout       = 2
+	defaultCheckCacheTTL      = 10
+	defaultPipelineStaleAfter = 120
+)
+
 type HealthCheckServerConfig struct {
 	Port int `yaml:"port" validate:"required"`
 }
 
 type HealthCheckConfig struct {
 	Server HealthCheckServerConfig `yaml:"server" validate:"required"`
+	// QueueDepthInterval is how often, in seconds, the emails per status are counted for /metrics
+	QueueDepthInterval int `yaml:"queue_depth_interval" validate:"omitempty,min=1"`
+	// CheckTimeout, CacheTTL and PipelineStaleAfter tune the /readyz probes, in seconds
+	CheckTimeout       int `yaml:"check_timeout" validate:"omitempty,min=1"`
+	CacheTTL           int `yaml:"cache_ttl" validate:"omitempty,min=1"`
+	PipelineStaleAfter int `yaml:"pipeline_stale_after" validate:"omitempty,min=1"`
 }
 
 type PipelineConfig struct {
-	Interval int                   `yaml:"interval" validate:"required"`
-	Restore  RestorePipelineConfig `yaml:"restore,flow" validate:"required"`
+	Interval        int                     `yaml:"interval" validate:"required"`
+	MaxIdleInterval int                     `yaml:"max_idle_interval" validate:"omitempty,min=1"`
+	ShutdownGrace   int                     `yaml:"shutdown_grace_period" validate:"omitempty,min=1"`
+	Intake          PipelineStageConfig     `yaml:"intake,flow"`
+	Sender          PipelineStageConfig     `yaml:"sender,flow"`
+	Callback        PipelineStageConfig     `yaml:"callback,flow"`
+	Wakeup          WakeupConfig            `yaml:"wakeup,flow"`
+	Restore         RestorePipelineConfig   `yaml:"restore,flow" validate:"required"`
+	Retention       RetentionPipelineConfig `yaml:"retention,flow"`
+	Bounce          BouncePipelineConfig    `yaml:"bounce,flow"`
+}
+
+// PipelineStageConfig overrides the scheduling of a pipeline. Omitted values keep the defaults:
+// enabled, pipeline.interval, a batch of 25 emails and one worker per email.
+type PipelineStageConfig struct {
+	Enabled   *bool `yaml:"enabled"`
+	Interval  int   `yaml:"interval" validate:"omitempty,min=1"`
+	BatchSize int   `yaml:"batch_size" validate:"omitempty,min=1"`
+	Workers   int   `yaml:"workers" validate:"omitempty,min=1"`
+}
+
+// WakeupConfig forwards wakeups between replicas over UDP; in-process wakeups are always enabled.
+type WakeupConfig struct {
+	ListenAddr string   `yaml:"listen_addr" validate:"omitempty,hostname_port"`
+	Peers      []string `yaml:"peers" validate:"dive,hostname_port"`
 }
 
 type RestorePipelineConfig struct {
-	Interval       int `yaml:"interval" validate:"required"`
-	TimeoutMinutes int `yaml:"timeout_minutes" validate:"required"`
+	Interval            int    `yaml:"interval" validate:"required"`
+	TimeoutMinutes      int    `yaml:"timeout_minutes" validate:"required"`
+	AmbiguousSendPolicy string `yaml:"ambiguous_send_policy" validate:"omitempty,oneof=resend uncertain operator"`
+}
+
+type RetentionPipelineConfig struct {
+	Interval         int            `yaml:"interval"`
+	BatchSize        int            `yaml:"batch_size" validate:"omitempty,min=1"`
+	ArchivePath      string         `yaml:"archive_path"`
+	DeleteFiles      bool           `yaml:"delete_files"`
+	MoveToColdTables bool           `yaml:"move_to_cold_tables"`
+	PeriodsDays      map[string]int `yaml:"periods_days" validate:"dive,keys,oneof=SENT-ACKNOWLEDGED FAILED-ACKNOWLEDGED CANCELLED-ACKNOWLEDGED INVALID-ACKNOWLEDGED BOUNCED-ACKNOWLEDGED SENT-CALLBACK-FAILED FAILED-CALLBACK-FAILED CANCELLED-CALLBACK-FAILED INVALID-CALLBACK-FAILED BOUNCED-CALLBACK-FAILED SEND-UNCERTAIN-ACKNOWLEDGED SEND-UNCERTAIN-CALLBACK-FAILED,endkeys,min=1"`
+}
+
+// BouncePipelineConfig is validated by validateBounceConfig: the source and its mailbox are only required
+// when the pipeline is enabled.
+type BouncePipelineConfig struct {
+	// Interval is 0 (default) to disable the bounce pipeline
+	Interval  int `yaml:"interval"`
+	BatchSize int `yaml:"batch_size" validate:"omitempty,min=1"`
+	// Source is the mailbox the delivery status notifications are read from: maildir, imap or pop3
+	Source  string              `yaml:"source" validate:"omitempty,oneof=maildir imap pop3"`
+	Maildir BounceMaildirConfig `yaml:"maildir"`
+	IMAP    BounceIMAPConfig    `yaml:"imap"`
+	POP3    BouncePOP3Config    `yaml:"pop3"`
+}
+
+type BounceMaildirConfig struct {
+	Path string `yaml:"path"`
+}
+
+type BounceIMAPConfig struct {
+	Addr     string `yaml:"addr" validate:"omitempty,hostname_port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// Mailbox is the folder holding the notifications, default INBOX
+	Mailbox string `yaml:"mailbox"`
+	TLS     bool   `yaml:"tls"`
+}
+
+type BouncePOP3Config struct {
+	Addr     string `yaml:"addr" validate:"omitempty,hostname_port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	TLS      bool   `yaml:"tls"`
 }
 
 type SmtpConfig struct {
@@ -46,12 +200,30 @@ type SmtpConfig struct {
 	Password         string `yaml:"password" validate:"required"`
 	From             string `yaml:"from" validate:"required"`
 	AllowInsecureTls bool   `yaml:"allow_insecure_tls"`
+	// EnvelopeSender is the MAIL FROM strategy: fixed (the from above, default), payload or verp
+	EnvelopeSender string `yaml:"envelope_sender" validate:"omitempty,oneof=fixed payload verp"`
+	// VERPAddress is the base of the VERP envelope senders, required by the verp strategy
+	VERPAddress string `yaml:"verp_address" validate:"required_if=EnvelopeSender verp,omitempty,email"`
 }
 
 type AttachmentsConfig struct {
 	BasePath string `yaml:"base-path" validate:"required"`
 }
 
+type IngestionServerConfig struct {
+	Port int `yaml:"port"`
+}
+
+// IngestionConfig is validated by validateIngestionConfig: the payload path and the tokens are only required when the API is enabled.
+type IngestionConfig struct {
+	Server       IngestionServerConfig `yaml:"server"`
+	PayloadPath  string                `yaml:"payload_path"`
+	MaxBatchSize int                   `yaml:"max_batch_size" validate:"omitempty,min=1"`
+	Tokens       []string              `yaml:"tokens"`
+	// AllowAnonymous accepts submissions without a bearer token, tokens are required otherwise
+	AllowAnonymous bool `yaml:"allow_anonymous"`
+}
+
 type MySQLConfig struct {
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
@@ -60,13 +232,25 @@ type MySQLConfig struct {
 	Database string `yaml:"database"`
 }
 
+// TracingConfig is optional, tracing is disabled when the exporter is empty or none.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" validate:"omitempty,oneof=none otlp stdout"`
+	Endpoint    string  `yaml:"endpoint" validate:"omitempty,hostname_port"`
+	Insecure    bool    `yaml:"insecure"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio" validate:"min=0,max=1"`
+}
+
 type Config struct {
+	Admin       AdminConfig       `yaml:"admin,flow"`
 	Attachments AttachmentsConfig `yaml:"attachments,flow" validate:"required"`
 	Callback    CallbacksConfig   `yaml:"callback,flow" validate:"required"`
 	HealthCheck HealthCheckConfig `yaml:"health-check,flow" validate:"required"`
+	Ingestion   IngestionConfig   `yaml:"ingestion,flow"`
 	MySQL       MySQLConfig       `yaml:"mysql,flow"`
 	Pipeline    PipelineConfig    `yaml:"pipeline,flow" validate:"required"`
 	Smtp        SmtpConfig        `yaml:"smtp,flow" validate:"required"`
+	Tracing     TracingConfig     `yaml:"tracing,flow"`
 }
 
 func NewFromYamlContent(yamlContent []byte) (*Config, error) {
@@ -87,6 +271,11 @@ func (c *Config) load(r io.Reader) error {
 
 	decodeErr := decoder.Decode(c)
 	validate := validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterStructValidation(validateAdminConfig, AdminConfig{})
+	validate.RegisterStructValidation(validateIngestionConfig, IngestionConfig{})
+	validate.RegisterStructValidation(validateCallbacksConfig, CallbacksConfig{})
+	validate.RegisterStructValidation(validateBounceConfig, BouncePipelineConfig{})
+	validate.RegisterStructValidation(validateCallbackBatchSize, Config{})
 	err := validate.Struct(c)
 
 	if decodeErr != nil && err != nil {
@@ -102,22 +291,263 @@ func (c *Config) load(r io.Reader) error {
 	return nil
 }
 
+func validateAdminConfig(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(AdminConfig)
+	if cfg.Server.Port == 0 {
+		return
+	}
+
+	if len(cfg.Operators) == 0 {
+		sl.ReportError(cfg.Operators, "Operators", "operators", "required", "")
+	}
+
+	for _, o := range cfg.Operators {
+		if o.Name == "" {
+			sl.ReportError(o.Name, "Name", "name", "required", "")
+		}
+		if len(o.Token) < minAdminTokenLength {
+			sl.ReportError(o.Token, "Token", "token", "min", fmt.Sprint(minAdminTokenLength))
+		}
+	}
+}
+
+func validateIngestionConfig(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(IngestionConfig)
+	if cfg.Server.Port == 0 {
+		return
+	}
+
+	if cfg.PayloadPath == "" {
+		sl.ReportError(cfg.PayloadPath, "PayloadPath", "payload_path", "required", "")
+	}
+
+	if cfg.AllowAnonymous {
+		return
+	}
+	if len(cfg.Tokens) == 0 {
+		sl.ReportError(cfg.Tokens, "Tokens", "tokens", "required", "")
+	}
+	for _, token := range cfg.Tokens {
+		if len(token) < minAdminTokenLength {
+			sl.ReportError(token, "Tokens", "tokens", "min", fmt.Sprint(minAdminTokenLength))
+		}
+	}
+}
+
+func validateCallbacksConfig(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(CallbacksConfig)
+	if _, err := pipeline.NewCallbackTemplate(cfg.BodyTemplate, cfg.Headers); err != nil {
+		sl.ReportError(cfg.BodyTemplate, "BodyTemplate", "body_template", "template", "")
+	}
+
+	switch cfg.Sink {
+	case "", callbacksink.KindHTTP:
+		if cfg.Url == "" {
+			sl.ReportError(cfg.Url, "Url", "url", "required", "")
+		}
+	case callbacksink.KindAMQP:
+		if cfg.AMQP.Url == "" || cfg.AMQP.RoutingKey == "" {
+			sl.ReportError(cfg.AMQP, "AMQP", "amqp", "required", "url routing_key")
+		}
+	case callbacksink.KindNATS:
+		if cfg.NATS.Url == "" || cfg.NATS.Subject == "" {
+			sl.ReportError(cfg.NATS, "NATS", "nats", "required", "url subject")
+		}
+	case callbacksink.KindKafka:
+		if len(cfg.Kafka.Brokers) == 0 || cfg.Kafka.Topic == "" {
+			sl.ReportError(cfg.Kafka, "Kafka", "kafka", "required", "brokers topic")
+		}
+	}
+}
+
+// validateCallbackBatchSize rejects a callback batch larger than the callback pipeline batch_size:
+// a query would never return enough emails to fill it.
+func validateCallbackBatchSize(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(Config)
+	batchSize := pipeline.PoolConfig{BatchSize: cfg.Pipeline.Callback.BatchSize}.WithDefaults().BatchSize
+	if cfg.Callback.Batch.MaxSize > batchSize {
+		sl.ReportError(cfg.Callback.Batch.MaxSize, "Callback.Batch.MaxSize", "max_size", "ltefield", "Pipeline.Callback.BatchSize")
+	}
+}
+
+func validateBounceConfig(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(BouncePipelineConfig)
+	if cfg.Interval == 0 {
+		return
+	}
+
+	switch cfg.Source {
+	case "":
+		sl.ReportError(cfg.Source, "Source", "source", "required", "")
+	case bounce.KindMaildir:
+		if cfg.Maildir.Path == "" {
+			sl.ReportError(cfg.Maildir, "Maildir", "maildir", "required", "path")
+		}
+	case bounce.KindIMAP:
+		if cfg.IMAP.Addr == "" || cfg.IMAP.User == "" {
+			sl.ReportError(cfg.IMAP, "IMAP", "imap", "required", "addr user")
+		}
+	case bounce.KindPOP3:
+		if cfg.POP3.Addr == "" || cfg.POP3.User == "" {
+			sl.ReportError(cfg.POP3, "POP3", "pop3", "required", "addr user")
+		}
+	}
+}
+
+func (c *Config) GetTracingConfig() tracing.Config {
+	return tracing.Config{
+		Exporter:    c.Tracing.Exporter,
+		Endpoint:    c.Tracing.Endpoint,
+		Insecure:    c.Tracing.Insecure,
+		ServiceName: c.Tracing.ServiceName,
+		SampleRatio: c.Tracing.SampleRatio,
+	}
+}
+
 func (c *Config) GetCallbackConfig() pipeline.CallbackConfig {
 	return pipeline.CallbackConfig{
-		MaxRetries:    c.Callback.MaxRetries,
-		RetryInterval: time.Duration(c.Callback.RetryInterval),
-		Url:           c.Callback.Url,
+		MaxRetries:       c.Callback.MaxRetries,
+		RetryInterval:    time.Duration(c.Callback.RetryInterval) * time.Second,
+		MaxRetryInterval: secondsOrDefault(c.Callback.MaxRetryInterval, defaultMaxRetryInterval),
+		Url:              c.Callback.Url,
+		AllowedHosts:     c.Callback.AllowedHosts,
+		SigningSecrets:   c.Callback.SigningSecrets,
+		Template:         c.callbackTemplate(),
+		Batch: pipeline.CallbackBatchConfig{
+			MaxSize: c.Callback.Batch.MaxSize,
+			MaxWait: time.Duration(c.Callback.Batch.MaxWait) * time.Second,
+		},
+	}
+}
+
+func (c *Config) GetCallbackSinkConfig() callbacksink.Config {
+	return callbacksink.Config{
+		Kind:  c.Callback.Sink,
+		AMQP:  callbacksink.AMQPConfig{URL: c.Callback.AMQP.Url, Exchange: c.Callback.AMQP.Exchange, RoutingKey: c.Callback.AMQP.RoutingKey},
+		NATS:  callbacksink.NATSConfig{URL: c.Callback.NATS.Url, Subject: c.Callback.NATS.Subject},
+		Kafka: callbacksink.KafkaConfig{Brokers: c.Callback.Kafka.Brokers, Topic: c.Callback.Kafka.Topic},
+	}
+}
+
+// callbackTemplate cannot fail on a loaded config, the templates are checked by validateCallbacksConfig.
+func (c *Config) callbackTemplate() *pipeline.CallbackTemplate {
+	template, err := pipeline.NewCallbackTemplate(c.Callback.BodyTemplate, c.Callback.Headers)
+	if err != nil {
+		return nil
+	}
+	return template
+}
+
+// GetAdminServerPort returns 0 when the admin API is disabled.
+func (c *Config) GetAdminServerPort() int {
+	return c.Admin.Server.Port
+}
+
+func (c *Config) GetAdminOperators() []admin.Operator {
+	operators := make([]admin.Operator, 0, len(c.Admin.Operators))
+	for _, o := range c.Admin.Operators {
+		operators = append(operators, admin.Operator{Name: o.Name, Token: o.Token})
+	}
+	return operators
+}
+
+// GetIngestionConfig returns a config with Port 0 when the ingestion API is disabled.
+func (c *Config) GetIngestionConfig() ingestion.Config {
+	return ingestion.Config{
+		Port:           c.Ingestion.Server.Port,
+		MaxBatchSize:   c.Ingestion.MaxBatchSize,
+		Tokens:         c.Ingestion.Tokens,
+		AllowAnonymous: c.Ingestion.AllowAnonymous,
 	}
 }
 
+func (c *Config) GetIngestionPayloadPath() string {
+	return c.Ingestion.PayloadPath
+}
+
 func (c *Config) GetHealthCheckServerPort() int {
 	return c.HealthCheck.Server.Port
 }
 
+// GetQueueDepthInterval returns how often the outbox queue depth exposed on /metrics is refreshed.
+func (c *Config) GetQueueDepthInterval() time.Duration {
+	if c.HealthCheck.QueueDepthInterval == 0 {
+		return defaultQueueDepthInterval * time.Second
+	}
+	return time.Duration(c.HealthCheck.QueueDepthInterval) * time.Second
+}
+
+func (c *Config) GetReadinessConfig() healthcheck.ReadinessConfig {
+	return healthcheck.ReadinessConfig{
+		CheckTimeout:       secondsOrDefault(c.HealthCheck.CheckTimeout, defaultCheckTimeout),
+		CacheTTL:           secondsOrDefault(c.HealthCheck.CacheTTL, defaultCheckCacheTTL),
+		PipelineStaleAfter: secondsOrDefault(c.HealthCheck.PipelineStaleAfter, defaultPipelineStaleAfter),
+	}
+}
+
+func secondsOrDefault(seconds int, defaultSeconds int) time.Duration {
+	if seconds == 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
 func (c *Config) GetPipelineInterval() int {
 	return c.Pipeline.Interval
 }
 
+// GetPipelineMaxIdleInterval returns the maximum poll interval, in seconds, reached by the backoff of an idle pipeline.
+func (c *Config) GetPipelineMaxIdleInterval() int {
+	if c.Pipeline.MaxIdleInterval == 0 {
+		return defaultMaxIdleInterval
+	}
+	return c.Pipeline.MaxIdleInterval
+}
+
+// GetShutdownGracePeriod returns how long in-flight emails may take to complete on shutdown.
+func (c *Config) GetShutdownGracePeriod() time.Duration {
+	if c.Pipeline.ShutdownGrace == 0 {
+		return defaultShutdownGracePeriod * time.Second
+	}
+	return time.Duration(c.Pipeline.ShutdownGrace) * time.Second
+}
+
+func (c *Config) GetIntakePipelineConfig() pipeline.StageConfig {
+	return c.stageConfig(c.Pipeline.Intake)
+}
+
+func (c *Config) GetSenderPipelineConfig() pipeline.StageConfig {
+	return c.stageConfig(c.Pipeline.Sender)
+}
+
+// GetCallbackPipelineConfig is shared by the sent, failed and cancelled callback pipelines.
+func (c *Config) GetCallbackPipelineConfig() pipeline.StageConfig {
+	return c.stageConfig(c.Pipeline.Callback)
+}
+
+func (c *Config) stageConfig(stage PipelineStageConfig) pipeline.StageConfig {
+	interval := stage.Interval
+	if interval == 0 {
+		interval = c.Pipeline.Interval
+	}
+
+	return pipeline.StageConfig{
+		Enabled:  stage.Enabled == nil || *stage.Enabled,
+		Interval: interval,
+		Pool: pipeline.PoolConfig{
+			BatchSize: stage.BatchSize,
+			Workers:   stage.Workers,
+		},
+	}
+}
+
+func (c *Config) GetWakeupConfig() wakeup.Config {
+	return wakeup.Config{
+		ListenAddr: c.Pipeline.Wakeup.ListenAddr,
+		Peers:      c.Pipeline.Wakeup.Peers,
+	}
+}
+
 func (c *Config) GetRestorePipelineInterval() int {
 	return c.Pipeline.Restore.Interval
 }
@@ -126,6 +556,58 @@ func (c *Config) GetRestorePipelineMaxAge() time.Duration {
 	return time.Duration(c.Pipeline.Restore.TimeoutMinutes) * time.Minute
 }
 
+func (c *Config) GetAmbiguousSendPolicy() pipeline.AmbiguousSendPolicy {
+	return pipeline.AmbiguousSendPolicy(c.Pipeline.Restore.AmbiguousSendPolicy)
+}
+
+// GetRetentionPipelineInterval returns 0 when the retention pipeline is disabled.
+func (c *Config) GetRetentionPipelineInterval() int {
+	return c.Pipeline.Retention.Interval
+}
+
+func (c *Config) GetRetentionConfig() pipeline.RetentionConfig {
+	periods := make(map[string]time.Duration, len(c.Pipeline.Retention.PeriodsDays))
+	for status, days := range c.Pipeline.Retention.PeriodsDays {
+		periods[status] = time.Duration(days) * 24 * time.Hour
+	}
+
+	return pipeline.RetentionConfig{
+		Periods:          periods,
+		BatchSize:        c.Pipeline.Retention.BatchSize,
+		ArchivePath:      c.Pipeline.Retention.ArchivePath,
+		DeleteFiles:      c.Pipeline.Retention.DeleteFiles,
+		MoveToColdTables: c.Pipeline.Retention.MoveToColdTables,
+	}
+}
+
+// GetBouncePipelineInterval returns 0 when the bounce pipeline is disabled.
+func (c *Config) GetBouncePipelineInterval() int {
+	return c.Pipeline.Bounce.Interval
+}
+
+// GetBounceConfig correlates the bounces with the VERP address of the sender, the only VERP base, when the
+// verp strategy is in use: otherwise the bounces are correlated by Message-ID only.
+func (c *Config) GetBounceConfig() pipeline.BounceConfig {
+	var verpAddress string
+	if c.Smtp.EnvelopeSender == pipeline.EnvelopeSenderVERP {
+		verpAddress = c.Smtp.VERPAddress
+	}
+	return pipeline.BounceConfig{
+		BatchSize:   c.Pipeline.Bounce.BatchSize,
+		VERPAddress: verpAddress,
+	}
+}
+
+func (c *Config) GetBounceSourceConfig() bounce.Config {
+	b := c.Pipeline.Bounce
+	return bounce.Config{
+		Kind:    b.Source,
+		Maildir: bounce.MaildirConfig{Path: b.Maildir.Path},
+		IMAP:    bounce.IMAPConfig{Addr: b.IMAP.Addr, User: b.IMAP.User, Password: b.IMAP.Password, Mailbox: b.IMAP.Mailbox, TLS: b.IMAP.TLS},
+		POP3:    bounce.POP3Config{Addr: b.POP3.Addr, User: b.POP3.User, Password: b.POP3.Password, TLS: b.POP3.TLS},
+	}
+}
+
 func (c *Config) GetSmtpConfig() smtp.Config {
 	return smtp.Config{
 		Host:             c.Smtp.Host,
@@ -137,6 +619,17 @@ func (c *Config) GetSmtpConfig() smtp.Config {
 	}
 }
 
+func (c *Config) GetEnvelopeSenderConfig() pipeline.EnvelopeSenderConfig {
+	switch c.Smtp.EnvelopeSender {
+	case pipeline.EnvelopeSenderPayload:
+		return pipeline.EnvelopeSenderConfig{Strategy: pipeline.EnvelopeSenderPayload}
+	case pipeline.EnvelopeSenderVERP:
+		return pipeline.EnvelopeSenderConfig{Strategy: pipeline.EnvelopeSenderVERP, Address: c.Smtp.VERPAddress}
+	default:
+		return pipeline.EnvelopeSenderConfig{Strategy: pipeline.EnvelopeSenderFixed, Address: c.Smtp.From}
+	}
+}
+
 func (c *Config) GetAttachmentsBasePath() string {
 	return c.Attachments.BasePath
 }
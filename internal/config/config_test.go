@@ -7,8 +7,14 @@ import (
 	"math/rand"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"mailculator-processor/internal/bounce"
+	"mailculator-processor/internal/callbacksink"
+	"mailculator-processor/internal/healthcheck"
+	"mailculator-processor/internal/pipeline"
 )
 
 func getYamlContent(fileName string) ([]byte, error) {
@@ -33,6 +39,17 @@ func TestNewFromYamlContent(t *testing.T) {
 		{"Valid", "testdata/valid.yaml", false},
 		{"Invalid unknown field", "testdata/invalid-unknown-field.yaml", true},
 		{"Invalid missing fields", "testdata/invalid-missing-fields.yaml", true},
+		{"Invalid retention status", "testdata/invalid-retention-status.yaml", true},
+		{"Invalid admin token", "testdata/invalid-admin-token.yaml", true},
+		{"Invalid pipeline workers", "testdata/invalid-pipeline-workers.yaml", true},
+		{"Invalid tracing exporter", "testdata/invalid-tracing-exporter.yaml", true},
+		{"Invalid callback template", "testdata/invalid-callback-template.yaml", true},
+		{"Invalid callback signing secret", "testdata/invalid-callback-signing-secret.yaml", true},
+		{"Invalid callback sink", "testdata/invalid-callback-sink.yaml", true},
+		{"Invalid callback batch size", "testdata/invalid-callback-batch-size.yaml", true},
+		{"Invalid ingestion tokens", "testdata/invalid-ingestion-tokens.yaml", true},
+		{"Invalid bounce source", "testdata/invalid-bounce-source.yaml", true},
+		{"Invalid envelope sender", "testdata/invalid-envelope-sender.yaml", true},
 	}
 
 	for _, c := range cases {
@@ -65,3 +82,83 @@ func TestExpandEnvVars(t *testing.T) {
 	cfg, _ := NewFromYamlContent(yamlContent)
 	assert.Equal(t, randomString, cfg.Attachments.BasePath)
 }
+
+func TestPipelineStageConfig(t *testing.T) {
+	yamlContent, err := getYamlContent("testdata/valid.yaml")
+	if err != nil {
+		t.Error(err)
+	}
+
+	cfg, err := NewFromYamlContent(yamlContent)
+	assert.NoError(t, err)
+
+	sender := cfg.GetSenderPipelineConfig()
+	assert.True(t, sender.Enabled)
+	assert.Equal(t, 1, sender.Interval)
+	assert.Equal(t, 100, sender.Pool.BatchSize)
+	assert.Equal(t, 10, sender.Pool.Workers)
+
+	intake := cfg.GetIntakePipelineConfig()
+	assert.True(t, intake.Enabled)
+	assert.Equal(t, 3, intake.Interval)
+
+	assert.Equal(t, 15*time.Second, cfg.GetQueueDepthInterval())
+	assert.Equal(t, healthcheck.ReadinessConfig{CheckTimeout: 2 * time.Second, CacheTTL: 10 * time.Second, PipelineStaleAfter: 120 * time.Second}, cfg.GetReadinessConfig())
+
+	disabled := false
+	cfg.Pipeline.Callback.Enabled = &disabled
+	assert.False(t, cfg.GetCallbackPipelineConfig().Enabled)
+}
+
+func TestCallbackConfig(t *testing.T) {
+	yamlContent, err := getYamlContent("testdata/valid.yaml")
+	assert.NoError(t, err)
+	cfg, err := NewFromYamlContent(yamlContent)
+	assert.NoError(t, err)
+
+	callback := cfg.GetCallbackConfig()
+	assert.Equal(t, 5*time.Second, callback.RetryInterval)
+	assert.Equal(t, time.Hour, callback.MaxRetryInterval)
+	assert.Equal(t, []string{"crm.example.com", "*.tenants.example.com"}, callback.AllowedHosts)
+	assert.Equal(t, []string{"dummy-signing-secret-0123456789abcdef"}, callback.SigningSecrets)
+	assert.Equal(t, pipeline.CallbackBatchConfig{MaxSize: 50, MaxWait: 10 * time.Second}, callback.Batch)
+	assert.Equal(t, callbacksink.Config{
+		Kind:  callbacksink.KindKafka,
+		Kafka: callbacksink.KafkaConfig{Brokers: []string{"kafka-1:9092", "kafka-2:9092"}, Topic: "mailculator-callbacks"},
+	}, cfg.GetCallbackSinkConfig())
+	body, headers, err := callback.Template.Render(pipeline.CallbackData{Id: "1", Status: "SENT", ReachedAt: "2025-01-01T10:00:00Z"})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":"1","status":"SENT","reached_at":"2025-01-01T10:00:00Z"}`, string(body))
+	assert.Equal(t, "processor", headers.Get("X-Source"))
+	assert.Equal(t, "1", headers.Get("X-Email-Id"))
+}
+
+func TestBounceConfig(t *testing.T) {
+	yamlContent, err := getYamlContent("testdata/valid.yaml")
+	assert.NoError(t, err)
+	cfg, err := NewFromYamlContent(yamlContent)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 60, cfg.GetBouncePipelineInterval())
+	assert.Equal(t, pipeline.BounceConfig{BatchSize: 50, VERPAddress: "bounces@mailculator.example"}, cfg.GetBounceConfig())
+	assert.Equal(t, bounce.Config{
+		Kind: bounce.KindIMAP,
+		IMAP: bounce.IMAPConfig{Addr: "imap.mailculator.example:993", User: "bounces", Password: "dummy-password", TLS: true},
+	}, cfg.GetBounceSourceConfig())
+
+	cfg.Smtp.EnvelopeSender = pipeline.EnvelopeSenderFixed
+	assert.Equal(t, pipeline.BounceConfig{BatchSize: 50}, cfg.GetBounceConfig())
+}
+
+func TestEnvelopeSenderConfig(t *testing.T) {
+	yamlContent, err := getYamlContent("testdata/valid.yaml")
+	assert.NoError(t, err)
+	cfg, err := NewFromYamlContent(yamlContent)
+	assert.NoError(t, err)
+
+	assert.Equal(t, pipeline.EnvelopeSenderConfig{Strategy: pipeline.EnvelopeSenderVERP, Address: "bounces@mailculator.example"}, cfg.GetEnvelopeSenderConfig())
+
+	cfg.Smtp.EnvelopeSender = ""
+	assert.Equal(t, pipeline.EnvelopeSenderConfig{Strategy: pipeline.EnvelopeSenderFixed, Address: "dummy-front"}, cfg.GetEnvelopeSenderConfig())
+}
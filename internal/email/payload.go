@@ -1,12 +1,20 @@
 package email
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel/attribute"
+
+	"mailculator-processor/internal/tracing"
 )
 
 type AttachmentList []Attachment
@@ -46,14 +54,28 @@ type Payload struct {
 	BodyText      string            `json:"body_text" validate:"required_without=BodyHTML"`
 	Attachments   AttachmentList    `json:"attachments" validate:"dive"`
 	CustomHeaders map[string]string `json:"custom_headers"`
+	// TraceParent is the optional W3C traceparent of the producer, linked to the send span
+	TraceParent string `json:"traceparent,omitempty"`
+	// CallbackURL optionally replaces the configured callback URL for this email
+	CallbackURL string `json:"callback_url,omitempty" validate:"omitempty,http_url"`
+	// CallbackMetadata is echoed back verbatim in the callback body
+	CallbackMetadata json.RawMessage `json:"callback_metadata,omitempty"`
 }
 
-func LoadPayload(path string) (Payload, error) {
+func LoadPayload(ctx context.Context, path string) (_ Payload, err error) {
+	_, span := tracing.Start(ctx, "email.LoadPayload", attribute.String("payload.path", path))
+	defer func() { tracing.End(span, err) }()
+
 	payloadData, err := os.ReadFile(path)
 	if err != nil {
 		return Payload{}, fmt.Errorf("failed to read payload file %s: %w", path, err)
 	}
 
+	return ParsePayload(payloadData)
+}
+
+// ParsePayload decodes and validates a JSON payload with the same rules applied at intake.
+func ParsePayload(payloadData []byte) (Payload, error) {
 	var payload Payload
 	if err := json.Unmarshal(payloadData, &payload); err != nil {
 		return Payload{}, fmt.Errorf("failed to unmarshal payload: %w", err)
@@ -66,3 +88,107 @@ func LoadPayload(path string) (Payload, error) {
 
 	return payload, nil
 }
+
+// CheckCallbackHost fails when the payload has a callback URL whose host is not allowed.
+// An allowed host matches exactly, a "*.example.com" entry matches any subdomain of example.com.
+func (p Payload) CheckCallbackHost(allowedHosts []string) error {
+	if p.CallbackURL == "" {
+		return nil
+	}
+
+	callbackURL, err := url.Parse(p.CallbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback_url: %w", err)
+	}
+
+	host := strings.ToLower(callbackURL.Hostname())
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed {
+			return nil
+		}
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok && strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) {
+			return nil
+		}
+	}
+
+	return &CallbackHostError{URL: p.CallbackURL, Host: host}
+}
+
+// CallbackHostError rejects a callback_url whose host is not in the allowed hosts.
+type CallbackHostError struct {
+	URL  string
+	Host string
+}
+
+func (e *CallbackHostError) Error() string {
+	return fmt.Sprintf("callback_url host %s is not allowed", e.Host)
+}
+
+// ValidationError is a payload field rejected at intake, reported to the producer by the invalid callback.
+// Field is the JSON path of the field, Tag the failed rule.
+type ValidationError struct {
+	Field string `json:"field"`
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+// ValidationErrors extracts the rejected fields from an error of ParsePayload or CheckCallbackHost.
+// Errors not tied to a field, such as a malformed JSON document or a missing file, yield none.
+func ValidationErrors(err error) []ValidationError {
+	var fieldErrors validator.ValidationErrors
+	if errors.As(err, &fieldErrors) {
+		result := make([]ValidationError, 0, len(fieldErrors))
+		for _, fe := range fieldErrors {
+			result = append(result, ValidationError{
+				Field: jsonPath(reflect.TypeOf(Payload{}), fe.StructNamespace()),
+				Tag:   fe.Tag(),
+				Value: fmt.Sprint(fe.Value()),
+			})
+		}
+		return result
+	}
+
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return []ValidationError{{Field: typeError.Field, Tag: "type", Value: typeError.Value}}
+	}
+
+	var hostError *CallbackHostError
+	if errors.As(err, &hostError) {
+		return []ValidationError{{Field: "callback_url", Tag: "allowed_host", Value: hostError.URL}}
+	}
+
+	return nil
+}
+
+// jsonPath turns a validator struct namespace, such as "Payload.Attachments[0].Path",
+// into the JSON path of the payload, "attachments[0].path".
+func jsonPath(t reflect.Type, structNamespace string) string {
+	segments := strings.Split(structNamespace, ".")[1:]
+	path := make([]string, 0, len(segments))
+
+	for _, segment := range segments {
+		name, index, _ := strings.Cut(segment, "[")
+		if index != "" {
+			index = "[" + index
+		}
+
+		for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		field, ok := t.FieldByName(name)
+		if !ok {
+			path = append(path, segment)
+			continue
+		}
+
+		if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag != "" && tag != "-" {
+			name = tag
+		}
+		path = append(path, name+index)
+		t = field.Type
+	}
+
+	return strings.Join(path, ".")
+}
@@ -3,6 +3,7 @@
 package email
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"testing"
@@ -81,7 +82,7 @@ func TestLoadPayload_WithAttachmentsAsStrings(t *testing.T) {
 	require.NoError(t, err)
 	tmpFile.Close()
 
-	payload, err := LoadPayload(tmpFile.Name())
+	payload, err := LoadPayload(context.TODO(), tmpFile.Name())
 
 	require.NoError(t, err)
 	assert.Len(t, payload.Attachments, 2)
@@ -113,7 +114,7 @@ func TestLoadPayload_WithAttachmentsAsObjects(t *testing.T) {
 	require.NoError(t, err)
 	tmpFile.Close()
 
-	payload, err := LoadPayload(tmpFile.Name())
+	payload, err := LoadPayload(context.TODO(), tmpFile.Name())
 
 	require.NoError(t, err)
 	assert.Len(t, payload.Attachments, 2)
@@ -141,7 +142,7 @@ func TestLoadPayload_WithoutAttachments(t *testing.T) {
 	require.NoError(t, err)
 	tmpFile.Close()
 
-	payload, err := LoadPayload(tmpFile.Name())
+	payload, err := LoadPayload(context.TODO(), tmpFile.Name())
 
 	require.NoError(t, err)
 	assert.Len(t, payload.Attachments, 0)
@@ -167,3 +168,133 @@ func TestAttachmentList_UnmarshalJSON_MixedFormatsNotAllowed(t *testing.T) {
 
 	require.Error(t, err)
 }
+
+func TestParsePayload_WhenValid_ShouldReturnPayload(t *testing.T) {
+	payload, err := ParsePayload([]byte(`{
+		"id": "550e8400-e29b-41d4-a716-446655440000",
+		"from": "sender@example.com",
+		"reply_to": "reply@example.com",
+		"to": "recipient@example.com",
+		"subject": "Test Subject",
+		"body_text": "Test"
+	}`))
+
+	require.NoError(t, err)
+	assert.Equal(t, "recipient@example.com", payload.To)
+}
+
+func TestParsePayload_WhenInvalid_ShouldReturnValidationError(t *testing.T) {
+	_, err := ParsePayload([]byte(`{"id": "not-a-uuid", "subject": "Test"}`))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "payload validation failed")
+}
+
+func TestParsePayload_WithTraceParent(t *testing.T) {
+	payload, err := ParsePayload([]byte(`{
+		"id": "550e8400-e29b-41d4-a716-446655440000",
+		"from": "sender@example.com",
+		"reply_to": "reply@example.com",
+		"to": "recipient@example.com",
+		"subject": "Test Subject",
+		"body_text": "Test body",
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", payload.TraceParent)
+}
+
+func TestParsePayload_WithCallbackFields(t *testing.T) {
+	payload, err := ParsePayload([]byte(`{
+		"id": "550e8400-e29b-41d4-a716-446655440000",
+		"from": "sender@example.com",
+		"reply_to": "reply@example.com",
+		"to": "recipient@example.com",
+		"subject": "Test Subject",
+		"body_text": "Test body",
+		"callback_url": "https://crm.example.com/notifications",
+		"callback_metadata": {"tenant": "acme", "ref": 42}
+	}`))
+
+	require.NoError(t, err)
+	assert.Equal(t, "https://crm.example.com/notifications", payload.CallbackURL)
+	assert.JSONEq(t, `{"tenant": "acme", "ref": 42}`, string(payload.CallbackMetadata))
+}
+
+func TestParsePayload_WithInvalidCallbackURL(t *testing.T) {
+	_, err := ParsePayload([]byte(`{
+		"id": "550e8400-e29b-41d4-a716-446655440000",
+		"from": "sender@example.com",
+		"reply_to": "reply@example.com",
+		"to": "recipient@example.com",
+		"subject": "Test Subject",
+		"body_text": "Test body",
+		"callback_url": "ftp://crm.example.com"
+	}`))
+
+	assert.ErrorContains(t, err, "CallbackURL")
+}
+
+func TestPayload_CheckCallbackHost(t *testing.T) {
+	allowed := []string{"crm.example.com", "*.tenants.example.org"}
+
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"", false},
+		{"https://crm.example.com/notify", false},
+		{"https://CRM.example.com:8443/notify", false},
+		{"https://acme.tenants.example.org/notify", false},
+		{"https://tenants.example.org/notify", true},
+		{"https://evil.com/notify", true},
+		{"https://crm.example.com.evil.com/notify", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := Payload{CallbackURL: tt.url}.CheckCallbackHost(allowed)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "is not allowed")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	_, err := ParsePayload([]byte(`{
+		"id": "not-a-uuid",
+		"from": "sender@example.com",
+		"reply_to": "reply@example.com",
+		"to": "recipient@example.com",
+		"body_text": "Test body",
+		"attachments": [{"path": "", "name": "report.pdf"}]
+	}`))
+
+	assert.Equal(t, []ValidationError{
+		{Field: "id", Tag: "uuid", Value: "not-a-uuid"},
+		{Field: "subject", Tag: "required", Value: ""},
+		{Field: "attachments[0].path", Tag: "required", Value: ""},
+	}, ValidationErrors(err))
+}
+
+func TestValidationErrors_WithWrongType(t *testing.T) {
+	_, err := ParsePayload([]byte(`{"subject": 42}`))
+
+	assert.Equal(t, []ValidationError{{Field: "subject", Tag: "type", Value: "number"}}, ValidationErrors(err))
+}
+
+func TestValidationErrors_WithCallbackHost(t *testing.T) {
+	err := Payload{CallbackURL: "https://evil.com/notify"}.CheckCallbackHost([]string{"crm.example.com"})
+
+	assert.Equal(t, []ValidationError{{Field: "callback_url", Tag: "allowed_host", Value: "https://evil.com/notify"}}, ValidationErrors(err))
+}
+
+func TestValidationErrors_WithoutFields(t *testing.T) {
+	_, err := ParsePayload([]byte(`not json`))
+
+	assert.Empty(t, ValidationErrors(err))
+}
@@ -0,0 +1,124 @@
+package failure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strings"
+
+	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/smtp"
+)
+
+// Category groups the failures a producer can react to in the same way.
+type Category string
+
+const (
+	CategoryValidation    Category = "validation"
+	CategoryAttachment    Category = "attachment"
+	CategorySMTPPermanent Category = "smtp-permanent"
+	CategorySMTPTransient Category = "smtp-transient"
+	CategoryAuth          Category = "auth"
+	CategoryTimeout       Category = "timeout"
+	// CategoryBounceHard and CategoryBounceSoft are delivery status notifications received after the send:
+	// a 5.x.x status is a permanent failure, a 4.x.x status one the remote server gave up retrying
+	CategoryBounceHard Category = "bounce-hard"
+	CategoryBounceSoft Category = "bounce-soft"
+	// CategoryInternal covers the failures of the processor itself, such as an unreadable payload file
+	CategoryInternal Category = "internal"
+)
+
+// authReplyCodes are the SMTP replies rejecting the credentials of the relay rather than the message.
+var authReplyCodes = map[int]bool{530: true, 534: true, 535: true, 538: true}
+
+// enhancedCodePattern matches the RFC 3463 enhanced status code opening an SMTP reply text.
+var enhancedCodePattern = regexp.MustCompile(`^[245]\.\d{1,3}\.\d{1,3}\b`)
+
+// Reason is the machine-readable description of why an email failed, stored as JSON next to its message.
+// SMTPCode and EnhancedCode are set for SMTP replies, Field for validation failures.
+type Reason struct {
+	Category     Category `json:"category"`
+	SMTPCode     int      `json:"smtp_code,omitempty"`
+	EnhancedCode string   `json:"enhanced_code,omitempty"`
+	Field        string   `json:"field,omitempty"`
+	Message      string   `json:"message"`
+}
+
+// Classify maps an error of the intake or of the send to a Reason.
+// Errors no rule recognizes are CategoryInternal, with the error text as message.
+func Classify(err error) Reason {
+	if fields := email.ValidationErrors(err); len(fields) > 0 {
+		return validationReason(fields)
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return Reason{Category: CategoryValidation, Message: err.Error()}
+	}
+
+	var attachmentErr *smtp.AttachmentError
+	if errors.As(err, &attachmentErr) {
+		return Reason{Category: CategoryAttachment, Message: err.Error()}
+	}
+
+	if isTimeout(err) {
+		return Reason{Category: CategoryTimeout, Message: err.Error()}
+	}
+
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) {
+		reason := Reason{
+			SMTPCode:     replyErr.Code,
+			EnhancedCode: enhancedCodePattern.FindString(replyErr.Msg),
+			Message:      fmt.Sprintf("%03d %s", replyErr.Code, replyErr.Msg),
+		}
+		switch {
+		case authReplyCodes[replyErr.Code]:
+			reason.Category = CategoryAuth
+		case replyErr.Code >= 500:
+			reason.Category = CategorySMTPPermanent
+		default:
+			reason.Category = CategorySMTPTransient
+		}
+		return reason
+	}
+
+	// a conversation step failing without a reply, such as a refused connection, may succeed later
+	var commandErr *smtp.CommandError
+	if errors.As(err, &commandErr) {
+		if commandErr.Command == "AUTH" {
+			return Reason{Category: CategoryAuth, Message: err.Error()}
+		}
+		return Reason{Category: CategorySMTPTransient, Message: err.Error()}
+	}
+
+	return Reason{Category: CategoryInternal, Message: err.Error()}
+}
+
+// validationReason names the first rejected field, the message lists all of them.
+func validationReason(fields []email.ValidationError) Reason {
+	rejected := make([]string, 0, len(fields))
+	for _, f := range fields {
+		rejected = append(rejected, fmt.Sprintf("%s (%s)", f.Field, f.Tag))
+	}
+
+	return Reason{
+		Category: CategoryValidation,
+		Field:    fields[0].Field,
+		Message:  "invalid payload: " + strings.Join(rejected, ", "),
+	}
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,88 @@
+//go:build unit
+
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/smtp"
+)
+
+func TestClassifyValidationNamesTheFirstField(t *testing.T) {
+	_, err := email.ParsePayload([]byte(`{"id":"550e8400-e29b-41d4-a716-446655440000","from":"nope","reply_to":"a@b.com","to":"c@d.com","subject":"s","body_text":"t"}`))
+
+	reason := Classify(err)
+
+	assert.Equal(t, Reason{Category: CategoryValidation, Field: "from", Message: "invalid payload: from (email)"}, reason)
+}
+
+func TestClassifyMalformedPayloadIsValidation(t *testing.T) {
+	_, err := email.ParsePayload([]byte(`{"id":`))
+
+	assert.Equal(t, CategoryValidation, Classify(err).Category)
+}
+
+func TestClassifyAttachment(t *testing.T) {
+	err := &smtp.AttachmentError{Path: "report.pdf", Err: os.ErrNotExist}
+
+	assert.Equal(t, Reason{Category: CategoryAttachment, Message: "failed to attach report.pdf: file does not exist"}, Classify(err))
+}
+
+func TestClassifySMTPReplies(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected Reason
+	}{
+		{
+			"permanent",
+			&smtp.CommandError{Command: "RCPT", Err: &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}},
+			Reason{Category: CategorySMTPPermanent, SMTPCode: 550, EnhancedCode: "5.1.1", Message: "550 5.1.1 User unknown"},
+		},
+		{
+			"transient",
+			&textproto.Error{Code: 451, Msg: "4.3.0 Try again later"},
+			Reason{Category: CategorySMTPTransient, SMTPCode: 451, EnhancedCode: "4.3.0", Message: "451 4.3.0 Try again later"},
+		},
+		{
+			"auth",
+			&smtp.CommandError{Command: "AUTH", Err: &textproto.Error{Code: 535, Msg: "5.7.8 Authentication failed"}},
+			Reason{Category: CategoryAuth, SMTPCode: 535, EnhancedCode: "5.7.8", Message: "535 5.7.8 Authentication failed"},
+		},
+		{
+			"without enhanced code",
+			&textproto.Error{Code: 554, Msg: "Transaction failed"},
+			Reason{Category: CategorySMTPPermanent, SMTPCode: 554, Message: "554 Transaction failed"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, Classify(c.err))
+		})
+	}
+}
+
+func TestClassifyConversationErrorsWithoutReply(t *testing.T) {
+	assert.Equal(t, CategoryAuth, Classify(&smtp.CommandError{Command: "AUTH", Err: errors.New("unencrypted connection")}).Category)
+	assert.Equal(t, CategorySMTPTransient, Classify(&smtp.CommandError{Command: "DIAL", Err: errors.New("connection refused")}).Category)
+}
+
+func TestClassifyTimeout(t *testing.T) {
+	err := &smtp.CommandError{Command: "DATA", Err: fmt.Errorf("write: %w", os.ErrDeadlineExceeded)}
+
+	assert.Equal(t, CategoryTimeout, Classify(err).Category)
+	assert.Equal(t, CategoryTimeout, Classify(context.DeadlineExceeded).Category)
+}
+
+func TestClassifyUnknownIsInternal(t *testing.T) {
+	assert.Equal(t, Reason{Category: CategoryInternal, Message: "boom"}, Classify(errors.New("boom")))
+}
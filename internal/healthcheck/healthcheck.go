@@ -2,20 +2,85 @@ package healthcheck
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"sync"
 	"time"
+
+	"mailculator-processor/internal/metrics"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+const (
+	defaultCheckTimeout = 2 * time.Second
+	defaultCacheTTL     = 10 * time.Second
 )
 
+// Probe checks a dependency the processor needs to do its work, a nil error means ready.
+type Probe func(ctx context.Context) error
+
+// CheckResult is the outcome of a probe as reported by /readyz.
+type CheckResult struct {
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+type readinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type check struct {
+	name   string
+	probe  Probe
+	mu     sync.Mutex
+	result CheckResult
+}
+
 type Server struct {
-	port int
+	port         int
+	checkTimeout time.Duration
+	cacheTTL     time.Duration
+	mu           sync.Mutex
+	checks       []*check
 }
 
 func NewServer(port int) *Server {
-	return &Server{port: port}
+	return &Server{
+		port:         port,
+		checkTimeout: defaultCheckTimeout,
+		cacheTTL:     defaultCacheTTL,
+	}
+}
+
+// SetProbeTimeouts bounds each probe run and how long its result is reused by /readyz.
+// Zero values keep the defaults.
+func (hs *Server) SetProbeTimeouts(checkTimeout time.Duration, cacheTTL time.Duration) {
+	if checkTimeout > 0 {
+		hs.checkTimeout = checkTimeout
+	}
+	if cacheTTL > 0 {
+		hs.cacheTTL = cacheTTL
+	}
+}
+
+// AddCheck registers a probe evaluated by /readyz.
+func (hs *Server) AddCheck(name string, probe Probe) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hs.checks = append(hs.checks, &check{name: name, probe: probe})
 }
 
+// handle reports liveness: the process is alive as long as its context is not cancelled.
 func (hs *Server) handle(w http.ResponseWriter, r *http.Request) {
 	select {
 	case <-r.Context().Done():
@@ -27,10 +92,80 @@ func (hs *Server) handle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleReady runs the registered probes concurrently and reports 503 if any of them fails.
+func (hs *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	response := readinessResponse{Status: StatusOK, Checks: hs.Check(r.Context())}
+	for _, result := range response.Checks {
+		if result.Status != StatusOK {
+			response.Status = StatusFail
+		}
+	}
+
+	statusCode := http.StatusOK
+	if response.Status != StatusOK || r.Context().Err() != nil {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// Check returns the result of every probe, reusing results younger than the cache TTL.
+func (hs *Server) Check(ctx context.Context) map[string]CheckResult {
+	hs.mu.Lock()
+	checks := append([]*check(nil), hs.checks...)
+	hs.mu.Unlock()
+
+	results := make(map[string]CheckResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := hs.run(ctx, c)
+			mu.Lock()
+			results[c.name] = result
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+func (hs *Server) run(ctx context.Context, c *check) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.result.CheckedAt.IsZero() && time.Since(c.result.CheckedAt) < hs.cacheTTL {
+		return c.result
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, hs.checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.probe(probeCtx)
+	result := CheckResult{Status: StatusOK, CheckedAt: start, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+
+	c.result = result
+	return result
+}
+
 func (hs *Server) ListenAndServe(ctx context.Context) error {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/health-check", hs.handle)
+	mux.HandleFunc("/livez", hs.handle)
+	mux.HandleFunc("GET /readyz", hs.handleReady)
+	mux.Handle("/metrics", metrics.Handler())
 
 	baseContextFunc := func(_ net.Listener) context.Context {
 		return ctx
@@ -0,0 +1,139 @@
+//go:build unit
+
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadyz_WhenAllChecksPass_ShouldReturnOk(t *testing.T) {
+	t.Parallel()
+
+	sut := NewServer(0)
+	sut.AddCheck("mysql", func(context.Context) error { return nil })
+
+	rec := httptest.NewRecorder()
+	sut.handleReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var body readinessResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, StatusOK, body.Status)
+	assert.Equal(t, StatusOK, body.Checks["mysql"].Status)
+}
+
+func TestReadyz_WhenACheckFails_ShouldReturnServiceUnavailableWithDetails(t *testing.T) {
+	t.Parallel()
+
+	sut := NewServer(0)
+	sut.AddCheck("mysql", func(context.Context) error { return nil })
+	sut.AddCheck("smtp", func(context.Context) error { return errors.New("535 authentication failed") })
+
+	rec := httptest.NewRecorder()
+	sut.handleReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var body readinessResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, StatusFail, body.Status)
+	assert.Equal(t, StatusOK, body.Checks["mysql"].Status)
+	assert.Equal(t, StatusFail, body.Checks["smtp"].Status)
+	assert.Equal(t, "535 authentication failed", body.Checks["smtp"].Error)
+}
+
+func TestCheck_ShouldReuseResultsWithinCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	sut := NewServer(0)
+	sut.SetProbeTimeouts(0, time.Minute)
+	sut.AddCheck("mysql", func(context.Context) error {
+		calls++
+		return nil
+	})
+
+	sut.Check(context.TODO())
+	sut.Check(context.TODO())
+
+	assert.Equal(t, 1, calls)
+}
+
+func TestCheck_WhenProbeHangs_ShouldFailAfterTimeout(t *testing.T) {
+	t.Parallel()
+
+	sut := NewServer(0)
+	sut.SetProbeTimeouts(20*time.Millisecond, 0)
+	sut.AddCheck("callback", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	results := sut.Check(context.TODO())
+
+	assert.Equal(t, StatusFail, results["callback"].Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), results["callback"].Error)
+}
+
+func TestLivez_ShouldReturnOk(t *testing.T) {
+	t.Parallel()
+
+	sut := NewServer(0)
+	sut.AddCheck("mysql", func(context.Context) error { return errors.New("unreachable") })
+
+	rec := httptest.NewRecorder()
+	sut.handle(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestHeartbeat_WhenSilentTooLong_ShouldFail(t *testing.T) {
+	t.Parallel()
+
+	sut := NewHeartbeat()
+	sut.last.Store(time.Now().Add(-time.Minute).UnixNano())
+
+	assert.ErrorContains(t, sut.Probe(30*time.Second)(context.TODO()), "last tick 1m0s ago")
+
+	sut.Beat()
+
+	assert.NoError(t, sut.Probe(30*time.Second)(context.TODO()))
+}
+
+func TestDirProbe(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file, err := os.CreateTemp(dir, "file")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	assert.NoError(t, DirProbe(dir)(context.TODO()))
+	assert.ErrorContains(t, DirProbe(file.Name())(context.TODO()), "is not a directory")
+	assert.Error(t, DirProbe(dir+"/missing")(context.TODO()))
+}
+
+func TestHTTPProbe_ShouldAcceptAnyResponse(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	url := server.URL
+
+	assert.NoError(t, HTTPProbe(url)(context.TODO()))
+
+	server.Close()
+
+	assert.Error(t, HTTPProbe(url)(context.TODO()))
+}
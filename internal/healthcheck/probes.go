@@ -0,0 +1,82 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ReadinessConfig tunes the /readyz probes.
+type ReadinessConfig struct {
+	// CheckTimeout bounds each probe run
+	CheckTimeout time.Duration
+	// CacheTTL is how long a probe result is reused
+	CacheTTL time.Duration
+	// PipelineStaleAfter is how long a pipeline may go without ticking beyond its longest poll wait
+	PipelineStaleAfter time.Duration
+}
+
+// Heartbeat records when a pipeline loop last ticked, so a wedged goroutine makes the process not ready.
+type Heartbeat struct {
+	last atomic.Int64
+}
+
+func NewHeartbeat() *Heartbeat {
+	h := &Heartbeat{}
+	h.Beat()
+	return h
+}
+
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Probe fails when the loop has not ticked for longer than maxSilence.
+func (h *Heartbeat) Probe(maxSilence time.Duration) Probe {
+	return func(_ context.Context) error {
+		silence := time.Since(time.Unix(0, h.last.Load()))
+		if silence > maxSilence {
+			return fmt.Errorf("last tick %v ago, expected within %v", silence.Round(time.Second), maxSilence)
+		}
+		return nil
+	}
+}
+
+// DirProbe fails when path is not a readable directory.
+func DirProbe(path string) Probe {
+	return func(_ context.Context) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", path)
+		}
+
+		dir, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		return dir.Close()
+	}
+}
+
+// HTTPProbe fails when url cannot be reached. Any HTTP response counts as reachable,
+// as the endpoint is not expected to answer a HEAD request successfully.
+func HTTPProbe(url string) Probe {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+}
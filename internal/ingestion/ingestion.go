@@ -0,0 +1,309 @@
+package ingestion
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/outbox"
+)
+
+const (
+	idempotencyKeyHeader  = "Idempotency-Key"
+	maxIdempotencyKeyLen  = 200
+	maxRequestBodyBytes   = 10 << 20
+	defaultMaxBatchSize   = 100
+	errKeyReusedDifferent = "idempotency key already used with a different payload"
+)
+
+type outboxService interface {
+	FindByIdempotencyKey(ctx context.Context, idempotencyKey string) (string, string, error)
+	Ingest(ctx context.Context, id string, payloadFilePath string, idempotencyKey string, requestHash string) error
+}
+
+type payloadStore interface {
+	Save(id string, data []byte) (string, error)
+	Remove(path string) error
+}
+
+type Config struct {
+	Port         int
+	MaxBatchSize int
+	// Tokens are the bearer tokens accepted from producers; empty rejects every request unless AllowAnonymous
+	Tokens []string
+	// AllowAnonymous accepts requests without a bearer token, for APIs reachable by trusted producers only
+	AllowAnonymous bool
+}
+
+type acceptedResponse struct {
+	Id       string `json:"id"`
+	Replayed bool   `json:"replayed,omitempty"`
+}
+
+type batchItemResponse struct {
+	Index    int    `json:"index"`
+	Status   int    `json:"status"`
+	Id       string `json:"id,omitempty"`
+	Replayed bool   `json:"replayed,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchItemResponse `json:"results"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// ingestError carries the HTTP status of a failed submission.
+type ingestError struct {
+	status  int
+	message string
+}
+
+func (e *ingestError) Error() string {
+	return e.message
+}
+
+type Server struct {
+	cfg    Config
+	outbox outboxService
+	store  payloadStore
+	logger *slog.Logger
+	newId  func() string
+}
+
+func NewServer(cfg Config, outbox outboxService, store payloadStore) *Server {
+	if cfg.MaxBatchSize <= 0 {
+		cfg.MaxBatchSize = defaultMaxBatchSize
+	}
+
+	return &Server{
+		cfg:    cfg,
+		outbox: outbox,
+		store:  store,
+		logger: slog.With("server", "ingestion"),
+		newId:  uuid.NewString,
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v1/emails", s.handleSubmit)
+	mux.HandleFunc("POST /v1/emails/batch", s.handleBatch)
+
+	return s.authenticate(mux)
+}
+
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	baseContextFunc := func(_ net.Listener) context.Context {
+		return ctx
+	}
+
+	srv := &http.Server{
+		Addr:        fmt.Sprintf(":%d", s.cfg.Port),
+		BaseContext: baseContextFunc,
+		Handler:     s.Handler(),
+	}
+
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+
+	<-ctx.Done()
+
+	ctxShutDown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer func() {
+		cancel()
+	}()
+
+	if err := srv.Shutdown(ctxShutDown); err != nil {
+		return fmt.Errorf("ingestion server shutdown failed:%v", err)
+	}
+
+	return nil
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.cfg.AllowAnonymous {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		authorized := false
+		for _, t := range s.cfg.Tokens {
+			if found && t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				authorized = true
+			}
+		}
+
+		if !authorized {
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "missing or invalid bearer token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLen {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "idempotency key too long"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{Error: "request body too large"})
+		return
+	}
+
+	resp, err := s.ingest(r.Context(), body, key)
+	if err != nil {
+		writeJSON(w, statusOf(err), errorResponse{Error: err.Error()})
+		return
+	}
+
+	status := http.StatusCreated
+	if resp.Replayed {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, resp)
+}
+
+// handleBatch ingests each payload of a JSON array independently and reports a result per item.
+// The Idempotency-Key of the batch is suffixed with the item index.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLen {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "idempotency key too long"})
+		return
+	}
+
+	var items []json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)).Decode(&items); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("batch must be a JSON array of payloads: %v", err)})
+		return
+	}
+
+	if len(items) == 0 || len(items) > s.cfg.MaxBatchSize {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("batch must contain between 1 and %d payloads", s.cfg.MaxBatchSize)})
+		return
+	}
+
+	resp := batchResponse{Results: make([]batchItemResponse, 0, len(items))}
+	for i, item := range items {
+		itemKey := ""
+		if key != "" {
+			itemKey = fmt.Sprintf("%s#%d", key, i)
+		}
+
+		accepted, err := s.ingest(r.Context(), item, itemKey)
+		if err != nil {
+			resp.Results = append(resp.Results, batchItemResponse{Index: i, Status: statusOf(err), Error: err.Error()})
+			continue
+		}
+
+		status := http.StatusCreated
+		if accepted.Replayed {
+			status = http.StatusOK
+		}
+		resp.Results = append(resp.Results, batchItemResponse{Index: i, Status: status, Id: accepted.Id, Replayed: accepted.Replayed})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// ingest validates the payload, stores it and creates the ACCEPTED email.
+// A retry with the same idempotency key and body returns the email created by the first request.
+func (s *Server) ingest(ctx context.Context, body []byte, key string) (acceptedResponse, error) {
+	if _, err := email.ParsePayload(body); err != nil {
+		return acceptedResponse{}, &ingestError{status: http.StatusUnprocessableEntity, message: err.Error()}
+	}
+
+	sum := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(sum[:])
+
+	if key != "" {
+		if resp, found, err := s.replay(ctx, key, requestHash); found || err != nil {
+			return resp, err
+		}
+	}
+
+	id := s.newId()
+
+	path, err := s.store.Save(id, body)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("failed to store payload %v: %v", id, err))
+		return acceptedResponse{}, &ingestError{status: http.StatusInternalServerError, message: "failed to store payload"}
+	}
+
+	if err := s.outbox.Ingest(ctx, id, path, key, requestHash); err != nil {
+		if removeErr := s.store.Remove(path); removeErr != nil {
+			s.logger.Warn(fmt.Sprintf("failed to remove orphan payload %v: %v", path, removeErr))
+		}
+
+		// a concurrent request with the same key won the race
+		if errors.Is(err, outbox.ErrIdempotencyKeyExists) {
+			if resp, found, replayErr := s.replay(ctx, key, requestHash); found || replayErr != nil {
+				return resp, replayErr
+			}
+		}
+
+		s.logger.Error(fmt.Sprintf("failed to create email %v: %v", id, err))
+		return acceptedResponse{}, &ingestError{status: http.StatusInternalServerError, message: "failed to create email"}
+	}
+
+	s.logger.Info(fmt.Sprintf("accepted email %v", id))
+
+	return acceptedResponse{Id: id}, nil
+}
+
+func (s *Server) replay(ctx context.Context, key string, requestHash string) (acceptedResponse, bool, error) {
+	emailId, storedHash, err := s.outbox.FindByIdempotencyKey(ctx, key)
+	if errors.Is(err, outbox.ErrNotFound) {
+		return acceptedResponse{}, false, nil
+	}
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("failed to look up idempotency key: %v", err))
+		return acceptedResponse{}, false, &ingestError{status: http.StatusInternalServerError, message: "failed to look up idempotency key"}
+	}
+
+	if storedHash != requestHash {
+		return acceptedResponse{}, false, &ingestError{status: http.StatusConflict, message: errKeyReusedDifferent}
+	}
+
+	return acceptedResponse{Id: emailId, Replayed: true}, true, nil
+}
+
+func statusOf(err error) int {
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		return ingestErr.status
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
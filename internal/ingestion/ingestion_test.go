@@ -0,0 +1,178 @@
+//go:build unit
+
+package ingestion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mailculator-processor/internal/outbox"
+)
+
+const validPayload = `{
+	"id": "550e8400-e29b-41d4-a716-446655440000",
+	"from": "sender@example.com",
+	"reply_to": "reply@example.com",
+	"to": "recipient@example.com",
+	"subject": "Test Subject",
+	"body_text": "Test"
+}`
+
+type outboxMock struct {
+	keys        map[string][2]string
+	ingested    []string
+	ingestError error
+}
+
+func newOutboxMock() *outboxMock {
+	return &outboxMock{keys: map[string][2]string{}}
+}
+
+func (m *outboxMock) FindByIdempotencyKey(ctx context.Context, idempotencyKey string) (string, string, error) {
+	entry, ok := m.keys[idempotencyKey]
+	if !ok {
+		return "", "", outbox.ErrNotFound
+	}
+	return entry[0], entry[1], nil
+}
+
+func (m *outboxMock) Ingest(ctx context.Context, id string, payloadFilePath string, idempotencyKey string, requestHash string) error {
+	if m.ingestError != nil {
+		return m.ingestError
+	}
+	m.ingested = append(m.ingested, payloadFilePath)
+	if idempotencyKey != "" {
+		m.keys[idempotencyKey] = [2]string{id, requestHash}
+	}
+	return nil
+}
+
+func newTestServer(t *testing.T, ob *outboxMock, tokens ...string) (*Server, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	s := NewServer(Config{MaxBatchSize: 2, Tokens: tokens, AllowAnonymous: len(tokens) == 0}, ob, NewFileStore(dir))
+	counter := 0
+	s.newId = func() string {
+		counter++
+		return "email-" + string(rune('0'+counter))
+	}
+	return s, dir
+}
+
+func doRequest(h http.Handler, target string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSubmitStoresPayloadAndCreatesEmail(t *testing.T) {
+	ob := newOutboxMock()
+	s, dir := newTestServer(t, ob)
+
+	rec := doRequest(s.Handler(), "/v1/emails", validPayload, nil)
+
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"id":"email-1"}`, rec.Body.String())
+	require.Equal(t, []string{dir + "/email-1.json"}, ob.ingested)
+
+	stored, err := os.ReadFile(ob.ingested[0])
+	require.NoError(t, err)
+	assert.Equal(t, validPayload, string(stored))
+}
+
+func TestSubmitInvalidPayload(t *testing.T) {
+	ob := newOutboxMock()
+	s, _ := newTestServer(t, ob)
+
+	rec := doRequest(s.Handler(), "/v1/emails", `{"id": "not-a-uuid"}`, nil)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "payload validation failed")
+	assert.Empty(t, ob.ingested)
+}
+
+func TestSubmitRetryWithIdempotencyKey(t *testing.T) {
+	ob := newOutboxMock()
+	s, _ := newTestServer(t, ob)
+	headers := map[string]string{"Idempotency-Key": "key-1"}
+
+	first := doRequest(s.Handler(), "/v1/emails", validPayload, headers)
+	retry := doRequest(s.Handler(), "/v1/emails", validPayload, headers)
+	different := doRequest(s.Handler(), "/v1/emails", strings.Replace(validPayload, "Test Subject", "Other", 1), headers)
+
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.JSONEq(t, `{"id":"email-1","replayed":true}`, retry.Body.String())
+	assert.Equal(t, http.StatusConflict, different.Code)
+	assert.Len(t, ob.ingested, 1)
+}
+
+func TestSubmitRemovesPayloadWhenEmailCreationFails(t *testing.T) {
+	ob := newOutboxMock()
+	ob.ingestError = errors.New("some ingest error")
+	s, dir := newTestServer(t, ob)
+
+	rec := doRequest(s.Handler(), "/v1/emails", validPayload, nil)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestSubmitRequiresTokenWhenConfigured(t *testing.T) {
+	ob := newOutboxMock()
+	s, _ := newTestServer(t, ob, "producer-token")
+
+	assert.Equal(t, http.StatusUnauthorized, doRequest(s.Handler(), "/v1/emails", validPayload, nil).Code)
+	assert.Equal(t, http.StatusCreated, doRequest(s.Handler(), "/v1/emails", validPayload, map[string]string{"Authorization": "Bearer producer-token"}).Code)
+}
+
+func TestSubmitRejectsEveryRequestWithoutTokens(t *testing.T) {
+	ob := newOutboxMock()
+	s := NewServer(Config{MaxBatchSize: 2}, ob, NewFileStore(t.TempDir()))
+
+	assert.Equal(t, http.StatusUnauthorized, doRequest(s.Handler(), "/v1/emails", validPayload, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(s.Handler(), "/v1/emails", validPayload, map[string]string{"Authorization": "Bearer "}).Code)
+	assert.Empty(t, ob.ingested)
+}
+
+func TestBatchReportsResultPerItem(t *testing.T) {
+	ob := newOutboxMock()
+	s, _ := newTestServer(t, ob)
+
+	rec := doRequest(s.Handler(), "/v1/emails/batch", "["+validPayload+`, {"id": "bad"}]`, map[string]string{"Idempotency-Key": "batch-1"})
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp batchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 2)
+	assert.Equal(t, http.StatusCreated, resp.Results[0].Status)
+	assert.Equal(t, "email-1", resp.Results[0].Id)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Results[1].Status)
+	assert.Contains(t, ob.keys, "batch-1#0")
+}
+
+func TestBatchTooLarge(t *testing.T) {
+	ob := newOutboxMock()
+	s, _ := newTestServer(t, ob)
+
+	rec := doRequest(s.Handler(), "/v1/emails/batch", "["+validPayload+","+validPayload+","+validPayload+"]", nil)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, ob.ingested)
+}
@@ -0,0 +1,53 @@
+package ingestion
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileStore saves payloads as JSON files in a directory shared with the pipelines (e.g. EFS).
+type FileStore struct {
+	basePath string
+}
+
+func NewFileStore(basePath string) *FileStore {
+	return &FileStore{basePath: basePath}
+}
+
+// Save writes the payload of the email identified by id and returns its path.
+// The file is written to a temporary name and renamed, so readers never see a partial payload.
+func (s *FileStore) Save(id string, data []byte) (string, error) {
+	if err := os.MkdirAll(s.basePath, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create payload directory: %w", err)
+	}
+
+	path := filepath.Join(s.basePath, id+".json")
+
+	tmp, err := os.CreateTemp(s.basePath, id+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create payload file: %w", err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write payload file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to close payload file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to rename payload file: %w", err)
+	}
+
+	return path, nil
+}
+
+func (s *FileStore) Remove(path string) error {
+	return os.Remove(path)
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+)
+
+// Default is the registry served on /metrics.
+var Default = NewRegistry()
+
+var (
+	PipelineProcessed = Default.NewCounterVec(
+		"mailculator_pipeline_processed_total",
+		"Emails picked up by a pipeline.",
+		"pipe",
+	)
+	PipelineSucceeded = Default.NewCounterVec(
+		"mailculator_pipeline_succeeded_total",
+		"Emails a pipeline completed successfully.",
+		"pipe",
+	)
+	PipelineFailed = Default.NewCounterVec(
+		"mailculator_pipeline_failed_total",
+		"Emails a pipeline completed with an error.",
+		"pipe",
+	)
+	PipelineLockConflicts = Default.NewCounterVec(
+		"mailculator_pipeline_lock_conflicts_total",
+		"Emails a pipeline could not claim because another process changed them first.",
+		"pipe",
+	)
+
+	SMTPSendDuration = Default.NewHistogramVec(
+		"mailculator_smtp_send_duration_seconds",
+		"Time spent handing an email to the SMTP server.",
+		DefaultBuckets,
+	)
+	SMTPReplies = Default.NewCounterVec(
+		"mailculator_smtp_replies_total",
+		"SMTP sends by reply code, \"none\" when the server gave no reply code.",
+		"code",
+	)
+
+	CallbackDuration = Default.NewHistogramVec(
+		"mailculator_callback_duration_seconds",
+		"Time spent on a callback request.",
+		DefaultBuckets,
+		"pipe",
+	)
+	CallbackResponses = Default.NewCounterVec(
+		"mailculator_callback_responses_total",
+		"Callback requests by HTTP status code, \"ack\" when confirmed by a broker, \"error\" when no response or confirm was received.",
+		"pipe", "code",
+	)
+
+	QueueDepth = Default.NewGaugeVec(
+		"mailculator_outbox_emails",
+		"Emails in the outbox per status, refreshed periodically.",
+		"status",
+	)
+
+	MySQLRetries = Default.NewCounterVec(
+		"mailculator_mysql_retries_total",
+		"Transient MySQL errors retried, by error number or \"bad_conn\".",
+		"error",
+	)
+)
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// Since returns the seconds elapsed since start, as observed by the histograms.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
@@ -0,0 +1,281 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the histogram upper bounds, in seconds, used for SMTP and callback latencies.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metric families exposed in the Prometheus text exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.families = append(r.families, c)
+}
+
+// WriteText writes every family in registration order, with its series sorted by label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]collector(nil), r.families...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		family.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry on GET requests.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		_ = r.WriteText(w)
+	})
+}
+
+// family holds the series of a metric, keyed by their label values.
+type family[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series[T]
+}
+
+type series[T any] struct {
+	values []string
+	value  T
+}
+
+func newFamily[T any](name string, help string, kind string, labels []string) *family[T] {
+	return &family[T]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series[T]),
+	}
+}
+
+// with calls fn on the series of the label values while holding the family lock.
+func (f *family[T]) with(values []string, fn func(value *T)) {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, found := f.series[key]
+	if !found {
+		s = &series[T]{values: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	fn(&s.value)
+}
+
+func (f *family[T]) write(w *bufio.Writer, writeSeries func(w *bufio.Writer, labels string, value T)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	_, _ = fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		writeSeries(w, formatLabels(f.labels, s.values), s.value)
+	}
+}
+
+// CounterVec is a monotonically increasing value per combination of label values.
+type CounterVec struct {
+	f *family[float64]
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{f: newFamily[float64](name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.f.name))
+	}
+	c.f.with(values, func(value *float64) { *value += delta })
+}
+
+// Value returns the current value of the series, 0 when it was never incremented.
+func (c *CounterVec) Value(values ...string) float64 {
+	var current float64
+	c.f.with(values, func(value *float64) { current = *value })
+	return current
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.f.write(w, func(w *bufio.Writer, labels string, value float64) {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.f.name, labels, formatFloat(value))
+	})
+}
+
+// GaugeVec is a value that can go up and down per combination of label values.
+type GaugeVec struct {
+	f *family[float64]
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{f: newFamily[float64](name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, values ...string) {
+	g.f.with(values, func(v *float64) { *v = value })
+}
+
+// Value returns the current value of the series, 0 when it was never set.
+func (g *GaugeVec) Value(values ...string) float64 {
+	var current float64
+	g.f.with(values, func(v *float64) { current = *v })
+	return current
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.f.write(w, func(w *bufio.Writer, labels string, value float64) {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", g.f.name, labels, formatFloat(value))
+	})
+}
+
+// HistogramVec counts observations in cumulative buckets per combination of label values.
+type HistogramVec struct {
+	f       *family[histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		f:       newFamily[histogram](name, help, "histogram", labels),
+		buckets: append([]float64(nil), buckets...),
+	}
+	sort.Float64s(h.buckets)
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.f.with(values, func(hist *histogram) {
+		if hist.counts == nil {
+			hist.counts = make([]uint64, len(h.buckets))
+		}
+		for i, bound := range h.buckets {
+			if value <= bound {
+				hist.counts[i]++
+			}
+		}
+		hist.sum += value
+		hist.count++
+	})
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.f.write(w, func(w *bufio.Writer, labels string, hist histogram) {
+		for i, bound := range h.buckets {
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.f.name, withLe(labels, formatFloat(bound)), hist.counts[i])
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.f.name, withLe(labels, "+Inf"), hist.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.f.name, labels, formatFloat(hist.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.f.name, labels, hist.count)
+	})
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func withLe(labels string, le string) string {
+	if labels == "" {
+		return fmt.Sprintf("{le=\"%s\"}", le)
+	}
+	return fmt.Sprintf("%s,le=\"%s\"}", strings.TrimSuffix(labels, "}"), le)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(v string) string {
+	return helpReplacer.Replace(v)
+}
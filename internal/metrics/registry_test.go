@@ -0,0 +1,102 @@
+//go:build unit
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText_ShouldExposeCountersAndGaugesSortedByLabels(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	counter := r.NewCounterVec("test_total", "A counter.", "pipe")
+	gauge := r.NewGaugeVec("test_depth", "A gauge.", "status")
+	counter.Inc("main")
+	counter.Add(2, "intake")
+	gauge.Set(5, "READY")
+
+	var sb strings.Builder
+	require.NoError(t, r.WriteText(&sb))
+
+	assert.Equal(t, `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{pipe="intake"} 2
+test_total{pipe="main"} 1
+# HELP test_depth A gauge.
+# TYPE test_depth gauge
+test_depth{status="READY"} 5
+`, sb.String())
+}
+
+func TestWriteText_ShouldExposeCumulativeHistogramBuckets(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	histogram := r.NewHistogramVec("test_seconds", "A histogram.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(3)
+
+	var sb strings.Builder
+	require.NoError(t, r.WriteText(&sb))
+
+	assert.Equal(t, `# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 3.55
+test_seconds_count 3
+`, sb.String())
+}
+
+func TestWriteText_ShouldEscapeLabelValues(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	counter := r.NewCounterVec("test_total", "A counter.", "code")
+	counter.Inc("say \"hi\"\n")
+
+	var sb strings.Builder
+	require.NoError(t, r.WriteText(&sb))
+
+	assert.Contains(t, sb.String(), `test_total{code="say \"hi\"\n"} 1`)
+}
+
+func TestCounterVec_WhenLabelValuesMismatch_ShouldPanic(t *testing.T) {
+	t.Parallel()
+
+	counter := NewRegistry().NewCounterVec("test_total", "A counter.", "pipe")
+
+	assert.Panics(t, func() { counter.Inc() })
+}
+
+func TestHandler_ShouldServeTextFormat(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "A counter.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "test_total 1\n")
+}
+
+func TestHandler_WhenNotGet_ShouldReturnMethodNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	NewRegistry().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,114 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+
+	"mailculator-processor/internal/tracing"
+)
+
+var ErrIdempotencyKeyExists = errors.New("idempotency key already used")
+
+// FindByIdempotencyKey returns the email id and the request hash recorded for an ingestion idempotency key,
+// searching the keys of the emails removed by retention too.
+// It returns ErrNotFound if the key was never used.
+func (o *Outbox) FindByIdempotencyKey(ctx context.Context, idempotencyKey string) (string, string, error) {
+	query := `
+		SELECT email_id, request_hash FROM ingestion_keys WHERE idempotency_key = ?
+		UNION ALL
+		SELECT email_id, request_hash FROM ingestion_keys_archive WHERE idempotency_key = ?
+		LIMIT 1
+	`
+
+	rows, err := o.db.QueryContext(ctx, query, idempotencyKey, idempotencyKey)
+	if err != nil {
+		return "", "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrNotFound
+	}
+
+	var emailId, requestHash string
+	if err := rows.Scan(&emailId, &requestHash); err != nil {
+		return "", "", err
+	}
+
+	return emailId, requestHash, nil
+}
+
+// Ingest creates an ACCEPTED email, its first history row and, when idempotencyKey is not empty,
+// the ingestion key pointing to it, all in one transaction.
+// It returns ErrIdempotencyKeyExists if the key was used concurrently by another request.
+// The operation is executed within a transaction with retry logic for transient errors.
+func (o *Outbox) Ingest(ctx context.Context, id string, payloadFilePath string, idempotencyKey string, requestHash string) (err error) {
+	ctx, span := tracing.Start(ctx, "outbox.Ingest", attribute.String("email.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	emailQuery := `
+		INSERT INTO emails (id, status, payload_file_path)
+		VALUES (?, ?, ?)
+	`
+	historyQuery := `
+		INSERT INTO email_statuses (email_id, status, reason)
+		VALUES (?, ?, ?)
+	`
+	keyQuery := `
+		INSERT INTO ingestion_keys (idempotency_key, email_id, request_hash)
+		VALUES (?, ?, ?)
+	`
+
+	for attempt := range maxAttempts {
+		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
+			if _, execErr := tx.ExecContext(ctx, emailQuery, id, StatusAccepted, payloadFilePath); execErr != nil {
+				return execErr
+			}
+
+			if _, histErr := tx.ExecContext(ctx, historyQuery, id, StatusAccepted, ""); histErr != nil {
+				return histErr
+			}
+
+			if idempotencyKey == "" {
+				return nil
+			}
+
+			_, keyErr := tx.ExecContext(ctx, keyQuery, idempotencyKey, id, requestHash)
+
+			var mysqlErr *mysql.MySQLError
+			if errors.As(keyErr, &mysqlErr) && mysqlErr.Number == duplicateKeyErrNo {
+				return ErrIdempotencyKeyExists
+			}
+
+			return keyErr
+		})
+
+		if err == nil {
+			o.notify(StatusAccepted)
+			return nil
+		}
+
+		if !o.shouldRetryMySQL(err) {
+			return err
+		}
+
+		sleep := o.backoffDuration(attempt)
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return err
+}
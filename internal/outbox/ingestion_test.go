@@ -0,0 +1,102 @@
+//go:build unit
+
+package outbox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindByIdempotencyKey_WhenKeyExists_ShouldReturnEmailIdAndHash(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT email_id, request_hash FROM ingestion_keys").
+		WithArgs("key-1", "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"email_id", "request_hash"}).AddRow("test-id", "hash"))
+
+	sut := NewOutboxWithDB(db)
+
+	emailId, hash, err := sut.FindByIdempotencyKey(context.TODO(), "key-1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test-id", emailId)
+	assert.Equal(t, "hash", hash)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindByIdempotencyKey_WhenKeyDoesNotExist_ShouldReturnNotFound(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT email_id, request_hash FROM ingestion_keys").
+		WithArgs("key-1", "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"email_id", "request_hash"}))
+
+	sut := NewOutboxWithDB(db)
+
+	_, _, err = sut.FindByIdempotencyKey(context.TODO(), "key-1")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIngest_WhenKeyProvided_ShouldInsertEmailHistoryAndKey(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO emails").
+		WithArgs("test-id", "ACCEPTED", "/path/to/payload").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "ACCEPTED", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO ingestion_keys").
+		WithArgs("key-1", "test-id", "hash").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.Ingest(context.TODO(), "test-id", "/path/to/payload", "key-1", "hash")
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIngest_WhenKeyAlreadyUsed_ShouldRollbackAndReturnError(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO emails").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO ingestion_keys").
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	mock.ExpectRollback()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.Ingest(context.TODO(), "test-id", "/path/to/payload", "key-1", "hash")
+
+	assert.ErrorIs(t, err, ErrIdempotencyKeyExists)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,224 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+var ErrNotFound = errors.New("email not found")
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListFilter selects the emails returned by List. Zero values disable the corresponding filter.
+type ListFilter struct {
+	Status         string
+	UpdatedFrom    time.Time
+	UpdatedTo      time.Time
+	ReasonContains string
+	// IncludeArchived also searches the emails_archive cold table
+	IncludeArchived bool
+	// Cursor is the NextCursor of a previous page
+	Cursor string
+	Limit  int
+}
+
+// ListPage is a page of emails ordered by updated_at and id.
+// NextCursor is empty when there are no more emails.
+type ListPage struct {
+	Emails     []Email
+	NextCursor string
+}
+
+// Get returns a single email, searching both the hot and the cold table.
+func (o *Outbox) Get(ctx context.Context, id string) (Email, error) {
+	query := `
+		SELECT id, status, payload_file_path, reason, failure, version, updated_at FROM emails WHERE id = ?
+		UNION ALL
+		SELECT id, status, payload_file_path, reason, failure, version, updated_at FROM emails_archive WHERE id = ?
+		LIMIT 1
+	`
+
+	rows, err := o.db.QueryContext(ctx, query, id, id)
+	if err != nil {
+		return Email{}, err
+	}
+	defer rows.Close()
+
+	emails, err := scanEmails(rows)
+	if err != nil {
+		return Email{}, err
+	}
+
+	if len(emails) == 0 {
+		return Email{}, ErrNotFound
+	}
+
+	return emails[0], nil
+}
+
+// List returns a page of emails matching the filter, without locking them.
+func (o *Outbox) List(ctx context.Context, filter ListFilter) (ListPage, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+
+	var conditions []string
+	var args []any
+
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if !filter.UpdatedFrom.IsZero() {
+		conditions = append(conditions, "updated_at >= ?")
+		args = append(args, filter.UpdatedFrom)
+	}
+	if !filter.UpdatedTo.IsZero() {
+		conditions = append(conditions, "updated_at < ?")
+		args = append(args, filter.UpdatedTo)
+	}
+	if filter.ReasonContains != "" {
+		conditions = append(conditions, "reason LIKE ?")
+		args = append(args, "%"+escapeLike(filter.ReasonContains)+"%")
+	}
+	if filter.Cursor != "" {
+		updatedAt, id, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return ListPage{}, err
+		}
+		conditions = append(conditions, "(updated_at > ? OR (updated_at = ? AND id > ?))")
+		args = append(args, updatedAt, updatedAt, id)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	columns := "id, status, payload_file_path, reason, failure, version, updated_at"
+	query := fmt.Sprintf("SELECT %s FROM emails %s", columns, where)
+	queryArgs := args
+	if filter.IncludeArchived {
+		query += fmt.Sprintf(" UNION ALL SELECT %s FROM emails_archive %s", columns, where)
+		queryArgs = append(append([]any{}, args...), args...)
+	}
+	// one extra row tells whether a next page exists
+	query += " ORDER BY updated_at ASC, id ASC LIMIT ?"
+	queryArgs = append(queryArgs, limit+1)
+
+	rows, err := o.db.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return ListPage{}, err
+	}
+	defer rows.Close()
+
+	emails, err := scanEmails(rows)
+	if err != nil {
+		return ListPage{}, err
+	}
+
+	page := ListPage{Emails: emails}
+	if len(emails) > limit {
+		page.Emails = emails[:limit]
+		last := page.Emails[limit-1]
+		page.NextCursor = encodeCursor(last.UpdatedAt, last.Id)
+	}
+
+	return page, nil
+}
+
+// CountByStatus returns the number of emails in each status of the hot table.
+// Statuses without emails are not included.
+func (o *Outbox) CountByStatus(ctx context.Context) (map[string]int, error) {
+	rows, err := o.db.QueryContext(ctx, "SELECT status, COUNT(*) FROM emails GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+func scanEmails(rows *sql.Rows) ([]Email, error) {
+	var emails []Email
+	for rows.Next() {
+		var e Email
+		var payloadFilePath, reason, failureJSON sql.NullString
+		var updatedAt time.Time
+
+		err := rows.Scan(
+			&e.Id,
+			&e.Status,
+			&payloadFilePath,
+			&reason,
+			&failureJSON,
+			&e.Version,
+			&updatedAt,
+		)
+		if err != nil {
+			return []Email{}, err
+		}
+
+		e.PayloadFilePath = payloadFilePath.String
+		e.Reason = reason.String
+		e.Failure = decodeFailure(failureJSON)
+		e.UpdatedAt = updatedAt.Format(time.RFC3339)
+
+		emails = append(emails, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []Email{}, err
+	}
+
+	return emails, nil
+}
+
+func encodeCursor(updatedAt string, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(updatedAt + "|" + id))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	updatedAt, id, found := strings.Cut(string(raw), "|")
+	if !found || id == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339, updatedAt)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	return t, id, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
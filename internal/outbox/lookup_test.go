@@ -0,0 +1,146 @@
+//go:build unit
+
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var emailColumns = []string{"id", "status", "payload_file_path", "reason", "failure", "version", "updated_at"}
+
+func TestGet_WhenEmailExists_ShouldReturnEmail(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT (.+) FROM emails WHERE id = \\? UNION ALL SELECT (.+) FROM emails_archive WHERE id = \\?").
+		WithArgs("test-id", "test-id").
+		WillReturnRows(sqlmock.NewRows(emailColumns).AddRow("test-id", "SENT", "/path/to/payload", nil, nil, 3, time.Now()))
+
+	sut := NewOutboxWithDB(db)
+
+	email, err := sut.Get(context.TODO(), "test-id")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test-id", email.Id)
+	assert.Equal(t, "SENT", email.Status)
+	assert.Equal(t, 3, email.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGet_WhenEmailDoesNotExist_ShouldReturnNotFound(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT").
+		WithArgs("test-id", "test-id").
+		WillReturnRows(sqlmock.NewRows(emailColumns))
+
+	sut := NewOutboxWithDB(db)
+
+	_, err = sut.Get(context.TODO(), "test-id")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestList_WhenMoreRowsThanLimit_ShouldReturnNextCursor(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	updatedAt := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT (.+) FROM emails WHERE status = \\? AND updated_at >= \\? AND reason LIKE \\? ORDER BY updated_at ASC, id ASC LIMIT \\?").
+		WithArgs("FAILED", from, "%50\\%%", 3).
+		WillReturnRows(sqlmock.NewRows(emailColumns).
+			AddRow("id-1", "FAILED", "/p1", "50% quota", nil, 1, updatedAt).
+			AddRow("id-2", "FAILED", "/p2", "50% quota", nil, 1, updatedAt).
+			AddRow("id-3", "FAILED", "/p3", "50% quota", nil, 1, updatedAt))
+
+	sut := NewOutboxWithDB(db)
+
+	page, err := sut.List(context.TODO(), ListFilter{Status: StatusFailed, UpdatedFrom: from, ReasonContains: "50%", Limit: 2})
+
+	assert.NoError(t, err)
+	require.Len(t, page.Emails, 2)
+	assert.Equal(t, "id-2", page.Emails[1].Id)
+	require.NotEmpty(t, page.NextCursor)
+
+	cursorTime, cursorId, err := decodeCursor(page.NextCursor)
+	assert.NoError(t, err)
+	assert.True(t, updatedAt.Equal(cursorTime))
+	assert.Equal(t, "id-2", cursorId)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestList_WhenCursorAndArchiveRequested_ShouldQueryBothTables(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	updatedAt := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+	cursor := encodeCursor(updatedAt.Format(time.RFC3339), "id-2")
+
+	mock.ExpectQuery("SELECT (.+) FROM emails WHERE \\(updated_at > \\? OR \\(updated_at = \\? AND id > \\?\\)\\) UNION ALL SELECT (.+) FROM emails_archive WHERE").
+		WithArgs(updatedAt, updatedAt, "id-2", updatedAt, updatedAt, "id-2", 51).
+		WillReturnRows(sqlmock.NewRows(emailColumns).AddRow("id-3", "SENT-ACKNOWLEDGED", "/p3", "", nil, 4, updatedAt))
+
+	sut := NewOutboxWithDB(db)
+
+	page, err := sut.List(context.TODO(), ListFilter{Cursor: cursor, IncludeArchived: true})
+
+	assert.NoError(t, err)
+	require.Len(t, page.Emails, 1)
+	assert.Empty(t, page.NextCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestList_WhenCursorIsInvalid_ShouldReturnError(t *testing.T) {
+	t.Parallel()
+
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sut := NewOutboxWithDB(db)
+
+	_, err = sut.List(context.TODO(), ListFilter{Cursor: "not-a-cursor"})
+
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestCountByStatus_ShouldReturnCountPerStatus(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT status, COUNT\\(\\*\\) FROM emails GROUP BY status").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow("READY", 12).AddRow("FAILED", 3))
+
+	sut := NewOutboxWithDB(db)
+
+	counts, err := sut.CountByStatus(context.TODO())
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"READY": 12, "FAILED": 3}, counts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,146 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"mailculator-processor/internal/tracing"
+)
+
+// Manual actions an operator may apply to an email.
+const (
+	ActionRequeue     = "requeue"
+	ActionCancel      = "cancel"
+	ActionAcknowledge = "acknowledge"
+	// ActionMarkSent records that a quarantined email was delivered
+	ActionMarkSent = "mark-sent"
+	// ActionFail records that a quarantined email was not delivered
+	ActionFail = "fail"
+)
+
+var ErrUnknownAction = errors.New("unknown operator action")
+
+var ErrSendInProgress = errors.New("email was already handed to SMTP")
+
+// ApplyOperatorAction applies a manual action to an email on behalf of an operator.
+// The target status is derived from the current status; the history row records the operator identity.
+// The operator reason replaces the reason of the email and clears its failure and callback retry counters,
+// so the next callback of the email has its whole retry budget.
+// Requeueing or failing a quarantined email also clears its send marker, so it can be sent again;
+// marking it sent or cancelling it keeps the marker, which still correlates its bounces.
+// Requeueing a parked callback keeps the failure and validation errors, so the new callback reports the same outcome.
+// Cancelling a PROCESSING email only succeeds while it has no send marker, otherwise ErrSendInProgress is returned.
+// The operation is executed within a transaction with retry logic for transient errors.
+func (o *Outbox) ApplyOperatorAction(ctx context.Context, id string, action string, operator string, reason string) (_ Email, err error) {
+	ctx, span := tracing.Start(ctx, "outbox.ApplyOperatorAction", attribute.String("email.id", id), attribute.String("operator.action", action))
+	defer func() { tracing.End(span, err) }()
+
+	if !isOperatorAction(action) {
+		return Email{}, ErrUnknownAction
+	}
+
+	current, err := o.Get(ctx, id)
+	if err != nil {
+		return Email{}, err
+	}
+
+	toStatus, err := operatorTransition(action, current.Status)
+	if err != nil {
+		return Email{}, err
+	}
+
+	updateQuery := `
+		UPDATE emails
+		SET status = ?, reason = ?, failure = NULL, validation_errors = NULL, callback_attempts = 0, next_attempt_at = NULL, version = version + 1
+		WHERE id = ? AND status = ? AND version = ?
+	`
+	historyQuery := `
+		INSERT INTO email_statuses (email_id, status, reason, operator)
+		VALUES (?, ?, ?, ?)
+	`
+	markerQuery := `DELETE FROM email_send_markers WHERE email_id = ?`
+
+	// the row lock taken by the UPDATE serializes with MarkSending
+	cancelProcessing := action == ActionCancel && current.Status == StatusProcessing
+	if cancelProcessing {
+		updateQuery = `
+		UPDATE emails
+		SET status = ?, reason = ?, failure = NULL, validation_errors = NULL, callback_attempts = 0, next_attempt_at = NULL, version = version + 1
+		WHERE id = ? AND status = ? AND version = ?
+		AND NOT EXISTS (SELECT 1 FROM email_send_markers WHERE email_id = emails.id)
+	`
+	}
+
+	clearMarker := current.Status == StatusQuarantined && (action == ActionRequeue || action == ActionFail)
+	retryCallback := action == ActionRequeue && isCallbackParked(current.Status)
+	if retryCallback {
+		updateQuery = `
+		UPDATE emails
+		SET status = ?, reason = ?, callback_attempts = 0, next_attempt_at = NULL, version = version + 1
+		WHERE id = ? AND status = ? AND version = ?
+	`
+	}
+
+	for attempt := range maxAttempts {
+		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
+			result, execErr := tx.ExecContext(ctx, updateQuery, toStatus, reason, id, current.Status, current.Version)
+			if execErr != nil {
+				return execErr
+			}
+
+			affected, affErr := result.RowsAffected()
+			if affErr != nil {
+				return affErr
+			}
+
+			if affected == 0 && cancelProcessing {
+				return ErrSendInProgress
+			}
+
+			if affected == 0 {
+				return ErrLockNotAcquired
+			}
+
+			if clearMarker {
+				if _, markerErr := tx.ExecContext(ctx, markerQuery, id); markerErr != nil {
+					return markerErr
+				}
+			}
+
+			_, histErr := tx.ExecContext(ctx, historyQuery, id, toStatus, reason, operator)
+			return histErr
+		})
+
+		if err == nil {
+			o.notify(toStatus)
+			current.Status = toStatus
+			current.Reason = reason
+			if !retryCallback {
+				current.Failure = nil
+				current.ValidationErrors = nil
+			}
+			current.CallbackAttempts = 0
+			current.Version++
+			return current, nil
+		}
+
+		if !o.shouldRetryMySQL(err) {
+			return Email{}, err
+		}
+
+		sleep := o.backoffDuration(attempt)
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Email{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return Email{}, err
+}
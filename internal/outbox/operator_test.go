@@ -0,0 +1,287 @@
+//go:build unit
+
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expectGet(mock sqlmock.Sqlmock, id string, status string, version int) {
+	mock.ExpectQuery("SELECT (.+) FROM emails WHERE id = \\?").
+		WithArgs(id, id).
+		WillReturnRows(sqlmock.NewRows(emailColumns).AddRow(id, status, "/path/to/payload", "some reason", `{"category":"internal","message":"some reason"}`, version, time.Now()))
+}
+
+func TestApplyOperatorAction_WhenRequeueFailed_ShouldMoveToReadyWithOperator(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectGet(mock, "test-id", StatusFailed, 4)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails SET status = \\?, reason = \\?, failure = NULL, validation_errors = NULL, callback_attempts = 0, next_attempt_at = NULL").
+		WithArgs("READY", "retry after fix", "test-id", "FAILED", 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "READY", "retry after fix", "alice").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	email, err := sut.ApplyOperatorAction(context.TODO(), "test-id", ActionRequeue, "alice", "retry after fix")
+
+	assert.NoError(t, err)
+	assert.Equal(t, StatusReady, email.Status)
+	assert.Equal(t, 5, email.Version)
+	assert.Nil(t, email.Failure)
+	assert.Nil(t, email.ValidationErrors)
+	assert.Equal(t, 0, email.CallbackAttempts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyOperatorAction_WhenRequeueQuarantined_ShouldClearSendMarker(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectGet(mock, "test-id", StatusQuarantined, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails").
+		WithArgs("READY", "", "test-id", "QUARANTINED", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM email_send_markers").
+		WithArgs("test-id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "READY", "", "bob").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	_, err = sut.ApplyOperatorAction(context.TODO(), "test-id", ActionRequeue, "bob", "")
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyOperatorAction_WhenFailQuarantined_ShouldClearSendMarker(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectGet(mock, "test-id", StatusQuarantined, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails").
+		WithArgs("FAILED", "not in the relay log", "test-id", "QUARANTINED", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM email_send_markers").
+		WithArgs("test-id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "FAILED", "not in the relay log", "bob").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	e, err := sut.ApplyOperatorAction(context.TODO(), "test-id", ActionFail, "bob", "not in the relay log")
+
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, e.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyOperatorAction_WhenMarkSentQuarantined_ShouldKeepSendMarker(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectGet(mock, "test-id", StatusQuarantined, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails").
+		WithArgs("SENT", "found in the relay log", "test-id", "QUARANTINED", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "SENT", "found in the relay log", "bob").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	e, err := sut.ApplyOperatorAction(context.TODO(), "test-id", ActionMarkSent, "bob", "found in the relay log")
+
+	require.NoError(t, err)
+	assert.Equal(t, StatusSent, e.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyOperatorAction_WhenTransitionNotAllowed_ShouldReturnError(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectGet(mock, "test-id", StatusSent, 3)
+
+	sut := NewOutboxWithDB(db)
+
+	_, err = sut.ApplyOperatorAction(context.TODO(), "test-id", ActionCancel, "alice", "")
+
+	assert.ErrorIs(t, err, ErrTransitionNotAllowed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyOperatorAction_WhenVersionChanged_ShouldReturnLockError(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectGet(mock, "test-id", StatusCallingSentCallback, 3)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails").
+		WithArgs("SENT-ACKNOWLEDGED", "", "test-id", "CALLING-SENT-CALLBACK", 3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	sut := NewOutboxWithDB(db)
+
+	_, err = sut.ApplyOperatorAction(context.TODO(), "test-id", ActionAcknowledge, "alice", "")
+
+	assert.ErrorIs(t, err, ErrLockNotAcquired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyOperatorAction_WhenRequeueParkedCallback_ShouldKeepFailure(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectGet(mock, "test-id", StatusFailedCallbackFailed, 6)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails SET status = \\?, reason = \\?, callback_attempts = 0, next_attempt_at = NULL, version = version \\+ 1").
+		WithArgs("FAILED", "receiver fixed", "test-id", "FAILED-CALLBACK-FAILED", 6).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "FAILED", "receiver fixed", "alice").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	e, err := sut.ApplyOperatorAction(context.TODO(), "test-id", ActionRequeue, "alice", "receiver fixed")
+
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, e.Status)
+	assert.Equal(t, "internal", string(e.Failure.Category))
+	assert.Equal(t, 0, e.CallbackAttempts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyOperatorAction_WhenAcknowledgeParkedCallback_ShouldKeepOutcome(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectGet(mock, "test-id", StatusSentCallbackFailed, 3)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails").
+		WithArgs("SENT-ACKNOWLEDGED", "", "test-id", "SENT-CALLBACK-FAILED", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "SENT-ACKNOWLEDGED", "", "alice").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	e, err := sut.ApplyOperatorAction(context.TODO(), "test-id", ActionAcknowledge, "alice", "")
+
+	require.NoError(t, err)
+	assert.Equal(t, StatusSentAcknowledged, e.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyOperatorAction_WhenCancelReady_ShouldMoveToCancelled(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectGet(mock, "test-id", StatusReady, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails").
+		WithArgs("CANCELLED", "no longer needed", "test-id", "READY", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "CANCELLED", "no longer needed", "alice").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	e, err := sut.ApplyOperatorAction(context.TODO(), "test-id", ActionCancel, "alice", "no longer needed")
+
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, e.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyOperatorAction_WhenCancelProcessingAlreadyMarked_ShouldReturnSendInProgress(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectGet(mock, "test-id", StatusProcessing, 5)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails (.+) NOT EXISTS \\(SELECT 1 FROM email_send_markers").
+		WithArgs("CANCELLED", "", "test-id", "PROCESSING", 5).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	sut := NewOutboxWithDB(db)
+
+	_, err = sut.ApplyOperatorAction(context.TODO(), "test-id", ActionCancel, "alice", "")
+
+	assert.ErrorIs(t, err, ErrSendInProgress)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyOperatorAction_WhenUnknownAction_ShouldReturnError(t *testing.T) {
+	t.Parallel()
+
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sut := NewOutboxWithDB(db)
+
+	_, err = sut.ApplyOperatorAction(context.TODO(), "test-id", "delete", "alice", "")
+
+	assert.ErrorIs(t, err, ErrUnknownAction)
+}
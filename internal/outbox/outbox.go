@@ -4,25 +4,53 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"math"
 	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+
+	"mailculator-processor/internal/failure"
+	"mailculator-processor/internal/metrics"
+	"mailculator-processor/internal/tracing"
 )
 
 const (
-	StatusAccepted              = "ACCEPTED"
-	StatusIntaking              = "INTAKING"
-	StatusReady                 = "READY"
-	StatusProcessing            = "PROCESSING"
-	StatusSent                  = "SENT"
-	StatusFailed                = "FAILED"
-	StatusInvalid               = "INVALID"
-	StatusCallingSentCallback   = "CALLING-SENT-CALLBACK"
-	StatusCallingFailedCallback = "CALLING-FAILED-CALLBACK"
-	StatusSentAcknowledged      = "SENT-ACKNOWLEDGED"
-	StatusFailedAcknowledged    = "FAILED-ACKNOWLEDGED"
+	StatusAccepted                 = "ACCEPTED"
+	StatusIntaking                 = "INTAKING"
+	StatusReady                    = "READY"
+	StatusProcessing               = "PROCESSING"
+	StatusSent                     = "SENT"
+	StatusFailed                   = "FAILED"
+	StatusInvalid                  = "INVALID"
+	StatusCallingSentCallback      = "CALLING-SENT-CALLBACK"
+	StatusCallingFailedCallback    = "CALLING-FAILED-CALLBACK"
+	StatusSentAcknowledged         = "SENT-ACKNOWLEDGED"
+	StatusFailedAcknowledged       = "FAILED-ACKNOWLEDGED"
+	StatusQuarantined              = "QUARANTINED"
+	StatusSendUncertain            = "SEND-UNCERTAIN"
+	StatusCancelled                = "CANCELLED"
+	StatusCallingCancelledCallback = "CALLING-CANCELLED-CALLBACK"
+	StatusCancelledAcknowledged    = "CANCELLED-ACKNOWLEDGED"
+	StatusSentCallbackFailed       = "SENT-CALLBACK-FAILED"
+	StatusFailedCallbackFailed     = "FAILED-CALLBACK-FAILED"
+	StatusCancelledCallbackFailed  = "CANCELLED-CALLBACK-FAILED"
+	StatusInvalidCallbackFailed    = "INVALID-CALLBACK-FAILED"
+	StatusBouncedCallbackFailed    = "BOUNCED-CALLBACK-FAILED"
+
+	StatusCallingSendUncertainCallback = "CALLING-SEND-UNCERTAIN-CALLBACK"
+	StatusSendUncertainAcknowledged    = "SEND-UNCERTAIN-ACKNOWLEDGED"
+	StatusSendUncertainCallbackFailed  = "SEND-UNCERTAIN-CALLBACK-FAILED"
+	StatusCallingInvalidCallback       = "CALLING-INVALID-CALLBACK"
+	StatusInvalidAcknowledged          = "INVALID-ACKNOWLEDGED"
+	StatusBounced                      = "BOUNCED"
+	StatusCallingBouncedCallback       = "CALLING-BOUNCED-CALLBACK"
+	StatusBouncedAcknowledged          = "BOUNCED-ACKNOWLEDGED"
 )
 
 const (
@@ -33,6 +61,13 @@ const (
 
 var ErrLockNotAcquired = errors.New("lock not acquired: record was modified by another process")
 
+var ErrDuplicateSend = errors.New("duplicate send: idempotency key already has a send marker")
+
+var ErrCancelled = errors.New("email was cancelled")
+
+// MySQL error number for duplicate primary/unique key
+const duplicateKeyErrNo = 1062
+
 // MySQL error numbers for retryable errors
 var retryableErrNos = map[uint16]bool{
 	1205: true, // Lock wait timeout exceeded
@@ -48,6 +83,22 @@ type Email struct {
 	UpdatedAt       string
 	Reason          string
 	Version         int
+	// CallbackAttempts counts the callback attempts already scheduled for a retry
+	CallbackAttempts int
+	// ValidationErrors is the JSON array of the payload fields rejected at intake, empty unless set by MarkInvalid
+	ValidationErrors json.RawMessage
+	// Failure describes why the email became FAILED or INVALID, nil unless set by Fail or MarkInvalid
+	Failure *failure.Reason
+}
+
+// StatusChange is a row of the email_statuses history table.
+// Operator is empty for changes applied by the pipelines.
+type StatusChange struct {
+	Status    string
+	Reason    string
+	Failure   *failure.Reason
+	Operator  string
+	CreatedAt string
 }
 
 // sqlDBInterface defines the minimal interface for database operations
@@ -57,8 +108,14 @@ type sqlDBInterface interface {
 	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 }
 
+// Notifier is told about every status an email enters, so the pipelines polling that status can be woken up.
+type Notifier interface {
+	Notify(status string)
+}
+
 type Outbox struct {
-	db sqlDBInterface
+	db       sqlDBInterface
+	notifier Notifier
 }
 
 func NewOutbox(db *sql.DB) *Outbox {
@@ -74,6 +131,18 @@ func NewOutboxWithDB(db sqlDBInterface) *Outbox {
 	}
 }
 
+// SetNotifier registers the notifier called after each committed status change.
+// Recovery transitions applied with UpdateFrom are not notified, so a throttled email is not retried immediately.
+func (o *Outbox) SetNotifier(n Notifier) {
+	o.notifier = n
+}
+
+func (o *Outbox) notify(status string) {
+	if o.notifier != nil {
+		o.notifier.Notify(status)
+	}
+}
+
 // shouldRetryMySQL checks if the error is a transient MySQL error that should be retried.
 // It returns false for ErrLockNotAcquired (optimistic lock conflict).
 func (o *Outbox) shouldRetryMySQL(err error) bool {
@@ -89,11 +158,16 @@ func (o *Outbox) shouldRetryMySQL(err error) bool {
 	// Check for MySQL-specific errors
 	var mysqlErr *mysql.MySQLError
 	if errors.As(err, &mysqlErr) {
-		return retryableErrNos[mysqlErr.Number]
+		if retryableErrNos[mysqlErr.Number] {
+			metrics.MySQLRetries.Inc(strconv.Itoa(int(mysqlErr.Number)))
+			return true
+		}
+		return false
 	}
 
 	// Check for connection errors
 	if errors.Is(err, driver.ErrBadConn) {
+		metrics.MySQLRetries.Inc("bad_conn")
 		return true
 	}
 
@@ -127,14 +201,18 @@ func (o *Outbox) executeInTransaction(ctx context.Context, fn func(tx *sql.Tx) e
 	return tx.Commit()
 }
 
-func (o *Outbox) Query(ctx context.Context, status string, limit int) ([]Email, error) {
+func (o *Outbox) Query(ctx context.Context, status string, limit int) (_ []Email, err error) {
+	ctx, span := tracing.Start(ctx, "outbox.Query", attribute.String("email.status", status))
+	defer func() { tracing.End(span, err) }()
+
 	// FOR UPDATE SKIP LOCKED ensures:
 	// - Rows currently locked by other transactions are skipped
 	// - Reduces contention when multiple workers poll simultaneously
+	// Emails with a retry scheduled in the future are skipped until it is due
 	query := `
-		SELECT id, status, payload_file_path, reason, version, updated_at
+		SELECT id, status, payload_file_path, reason, version, callback_attempts, validation_errors, failure, updated_at
 		FROM emails
-		WHERE status = ?
+		WHERE status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= NOW())
 		ORDER BY updated_at ASC
 		LIMIT ?
 		FOR UPDATE SKIP LOCKED
@@ -149,7 +227,7 @@ func (o *Outbox) Query(ctx context.Context, status string, limit int) ([]Email,
 	var emails []Email
 	for rows.Next() {
 		var e Email
-		var payloadFilePath, reason sql.NullString
+		var payloadFilePath, reason, validationErrors, failureJSON sql.NullString
 		var updatedAt time.Time
 
 		err := rows.Scan(
@@ -158,6 +236,9 @@ func (o *Outbox) Query(ctx context.Context, status string, limit int) ([]Email,
 			&payloadFilePath,
 			&reason,
 			&e.Version,
+			&e.CallbackAttempts,
+			&validationErrors,
+			&failureJSON,
 			&updatedAt,
 		)
 		if err != nil {
@@ -166,6 +247,10 @@ func (o *Outbox) Query(ctx context.Context, status string, limit int) ([]Email,
 
 		e.PayloadFilePath = payloadFilePath.String
 		e.Reason = reason.String
+		if validationErrors.Valid {
+			e.ValidationErrors = json.RawMessage(validationErrors.String)
+		}
+		e.Failure = decodeFailure(failureJSON)
 		e.UpdatedAt = updatedAt.Format(time.RFC3339)
 
 		emails = append(emails, e)
@@ -179,9 +264,12 @@ func (o *Outbox) Query(ctx context.Context, status string, limit int) ([]Email,
 }
 
 // QueryStale returns emails by status that are older than the provided duration.
-func (o *Outbox) QueryStale(ctx context.Context, status string, olderThan time.Duration, limit int) ([]Email, error) {
+func (o *Outbox) QueryStale(ctx context.Context, status string, olderThan time.Duration, limit int) (_ []Email, err error) {
+	ctx, span := tracing.Start(ctx, "outbox.QueryStale", attribute.String("email.status", status))
+	defer func() { tracing.End(span, err) }()
+
 	query := `
-		SELECT id, status, payload_file_path, reason, version, updated_at
+		SELECT id, status, payload_file_path, reason, version, callback_attempts, validation_errors, failure, updated_at
 		FROM emails
 		WHERE status = ? AND updated_at < ?
 		ORDER BY updated_at ASC
@@ -210,7 +298,7 @@ func (o *Outbox) QueryStale(ctx context.Context, status string, olderThan time.D
 	var emails []Email
 	for rows.Next() {
 		var e Email
-		var payloadFilePath, reason sql.NullString
+		var payloadFilePath, reason, validationErrors, failureJSON sql.NullString
 		var updatedAt time.Time
 
 		err := rows.Scan(
@@ -219,6 +307,9 @@ func (o *Outbox) QueryStale(ctx context.Context, status string, olderThan time.D
 			&payloadFilePath,
 			&reason,
 			&e.Version,
+			&e.CallbackAttempts,
+			&validationErrors,
+			&failureJSON,
 			&updatedAt,
 		)
 		if err != nil {
@@ -227,6 +318,10 @@ func (o *Outbox) QueryStale(ctx context.Context, status string, olderThan time.D
 
 		e.PayloadFilePath = payloadFilePath.String
 		e.Reason = reason.String
+		if validationErrors.Valid {
+			e.ValidationErrors = json.RawMessage(validationErrors.String)
+		}
+		e.Failure = decodeFailure(failureJSON)
 		e.UpdatedAt = updatedAt.Format(time.RFC3339)
 
 		emails = append(emails, e)
@@ -240,22 +335,36 @@ func (o *Outbox) QueryStale(ctx context.Context, status string, olderThan time.D
 }
 
 // Update changes the status of an email using optimistic locking based on version.
-// It determines the expected "from" status based on the target "to" status.
+// It determines the expected "from" status from the pipeline transitions of the state machine;
+// a target status no pipeline transition reaches returns ErrTransitionNotAllowed.
+// Reaching an acknowledged status resets the callback retry counters, so a later callback starts afresh.
 // The operation is executed within a transaction with retry logic for transient errors.
-func (o *Outbox) Update(ctx context.Context, id string, status string, errorReason string) error {
+func (o *Outbox) Update(ctx context.Context, id string, status string, errorReason string) (err error) {
+	ctx, span := tracing.Start(ctx, "outbox.Update", attribute.String("email.id", id), attribute.String("email.status", status))
+	defer func() { tracing.End(span, err) }()
+
 	fromStatus := getExpectedFromStatus(status)
+	if fromStatus == "" {
+		return ErrTransitionNotAllowed
+	}
 
 	updateQuery := `
 		UPDATE emails
 		SET status = ?, reason = ?, version = version + 1
 		WHERE id = ? AND status = ?
 	`
+	if isCallbackAcknowledged(status) {
+		updateQuery = `
+		UPDATE emails
+		SET status = ?, reason = ?, callback_attempts = 0, next_attempt_at = NULL, version = version + 1
+		WHERE id = ? AND status = ?
+	`
+	}
 	historyQuery := `
 		INSERT INTO email_statuses (email_id, status, reason)
 		VALUES (?, ?, ?)
 	`
 
-	var err error
 	for attempt := range maxAttempts {
 		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
 			result, execErr := tx.ExecContext(ctx, updateQuery, status, errorReason, id, fromStatus)
@@ -276,7 +385,12 @@ func (o *Outbox) Update(ctx context.Context, id string, status string, errorReas
 			return histErr
 		})
 
-		if err == nil || !o.shouldRetryMySQL(err) {
+		if err == nil {
+			o.notify(status)
+			return nil
+		}
+
+		if !o.shouldRetryMySQL(err) {
 			return err
 		}
 
@@ -294,8 +408,16 @@ func (o *Outbox) Update(ctx context.Context, id string, status string, errorReas
 }
 
 // UpdateFrom changes status using an explicit fromStatus (used for restore).
+// Only pipeline and recovery transitions of the state machine are accepted, others return ErrTransitionNotAllowed.
 // The operation is executed within a transaction with retry logic for transient errors.
-func (o *Outbox) UpdateFrom(ctx context.Context, id string, fromStatus string, toStatus string, errorReason string) error {
+func (o *Outbox) UpdateFrom(ctx context.Context, id string, fromStatus string, toStatus string, errorReason string) (err error) {
+	ctx, span := tracing.Start(ctx, "outbox.UpdateFrom", attribute.String("email.id", id), attribute.String("email.status", toStatus))
+	defer func() { tracing.End(span, err) }()
+
+	if !isAllowed(fromStatus, toStatus, ActorPipeline, ActorRecovery) {
+		return ErrTransitionNotAllowed
+	}
+
 	updateQuery := `
 		UPDATE emails
 		SET status = ?, reason = ?, version = version + 1
@@ -306,7 +428,6 @@ func (o *Outbox) UpdateFrom(ctx context.Context, id string, fromStatus string, t
 		VALUES (?, ?, ?)
 	`
 
-	var err error
 	for attempt := range maxAttempts {
 		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
 			result, execErr := tx.ExecContext(ctx, updateQuery, toStatus, errorReason, id, fromStatus)
@@ -344,10 +465,217 @@ func (o *Outbox) UpdateFrom(ctx context.Context, id string, fromStatus string, t
 	return err
 }
 
+// ScheduleCallbackRetry moves an email from a CALLING-* status back to the status its callback starts from,
+// counts the failed attempt and hides the email from Query until delay has elapsed on the database clock.
+// The reason is only recorded in the history, so the email keeps the reason forwarded by its callback.
+// The operation is executed within a transaction with retry logic for transient errors.
+func (o *Outbox) ScheduleCallbackRetry(ctx context.Context, id string, fromStatus string, toStatus string, delay time.Duration, reason string) (err error) {
+	ctx, span := tracing.Start(ctx, "outbox.ScheduleCallbackRetry", attribute.String("email.id", id), attribute.String("email.status", toStatus))
+	defer func() { tracing.End(span, err) }()
+
+	if !isAllowed(fromStatus, toStatus, ActorRecovery) {
+		return ErrTransitionNotAllowed
+	}
+
+	updateQuery := `
+		UPDATE emails
+		SET status = ?, callback_attempts = callback_attempts + 1,
+			next_attempt_at = NOW() + INTERVAL ? SECOND, version = version + 1
+		WHERE id = ? AND status = ?
+	`
+	historyQuery := `
+		INSERT INTO email_statuses (email_id, status, reason)
+		VALUES (?, ?, ?)
+	`
+	delaySeconds := int64(math.Ceil(delay.Seconds()))
+
+	for attempt := range maxAttempts {
+		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
+			result, execErr := tx.ExecContext(ctx, updateQuery, toStatus, delaySeconds, id, fromStatus)
+			if execErr != nil {
+				return execErr
+			}
+
+			affected, affErr := result.RowsAffected()
+			if affErr != nil {
+				return affErr
+			}
+
+			if affected == 0 {
+				return ErrLockNotAcquired
+			}
+
+			_, histErr := tx.ExecContext(ctx, historyQuery, id, toStatus, reason)
+			return histErr
+		})
+
+		if err == nil || !o.shouldRetryMySQL(err) {
+			return err
+		}
+
+		sleep := o.backoffDuration(attempt)
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return err
+}
+
+// Fail moves an email to a failure status along a pipeline transition, like Update, storing the message
+// of the failure as reason and the whole failure as JSON, both on the email and in its history.
+// The operation is executed within a transaction with retry logic for transient errors.
+func (o *Outbox) Fail(ctx context.Context, id string, status string, reason failure.Reason) error {
+	return o.FailFrom(ctx, id, getExpectedFromStatus(status), status, reason)
+}
+
+// FailFrom is Fail from an explicit fromStatus, like UpdateFrom: pipeline and recovery transitions are accepted,
+// others return ErrTransitionNotAllowed.
+func (o *Outbox) FailFrom(ctx context.Context, id string, fromStatus string, status string, reason failure.Reason) (err error) {
+	ctx, span := tracing.Start(ctx, "outbox.Fail", attribute.String("email.id", id), attribute.String("email.status", status))
+	defer func() { tracing.End(span, err) }()
+
+	if !isAllowed(fromStatus, status, ActorPipeline, ActorRecovery) {
+		return ErrTransitionNotAllowed
+	}
+
+	updateQuery := `
+		UPDATE emails
+		SET status = ?, reason = ?, failure = ?, version = version + 1
+		WHERE id = ? AND status = ?
+	`
+	historyQuery := `
+		INSERT INTO email_statuses (email_id, status, reason, failure)
+		VALUES (?, ?, ?, ?)
+	`
+
+	storedFailure, err := json.Marshal(reason)
+	if err != nil {
+		return err
+	}
+
+	for attempt := range maxAttempts {
+		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
+			result, execErr := tx.ExecContext(ctx, updateQuery, status, reason.Message, string(storedFailure), id, fromStatus)
+			if execErr != nil {
+				return execErr
+			}
+
+			affected, affErr := result.RowsAffected()
+			if affErr != nil {
+				return affErr
+			}
+
+			if affected == 0 {
+				return ErrLockNotAcquired
+			}
+
+			_, histErr := tx.ExecContext(ctx, historyQuery, id, status, reason.Message, string(storedFailure))
+			return histErr
+		})
+
+		if err == nil {
+			o.notify(status)
+			return nil
+		}
+
+		if !o.shouldRetryMySQL(err) {
+			return err
+		}
+
+		sleep := o.backoffDuration(attempt)
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return err
+}
+
+// MarkInvalid moves an email from INTAKING to INVALID, storing the failure and the payload fields rejected by
+// the validation as a JSON array for the invalid callback; nil stores no fields.
+// The operation is executed within a transaction with retry logic for transient errors.
+func (o *Outbox) MarkInvalid(ctx context.Context, id string, reason failure.Reason, validationErrors json.RawMessage) (err error) {
+	ctx, span := tracing.Start(ctx, "outbox.MarkInvalid", attribute.String("email.id", id), attribute.String("email.status", StatusInvalid))
+	defer func() { tracing.End(span, err) }()
+
+	updateQuery := `
+		UPDATE emails
+		SET status = ?, reason = ?, failure = ?, validation_errors = ?, version = version + 1
+		WHERE id = ? AND status = ?
+	`
+	historyQuery := `
+		INSERT INTO email_statuses (email_id, status, reason, failure)
+		VALUES (?, ?, ?, ?)
+	`
+
+	storedFailure, err := json.Marshal(reason)
+	if err != nil {
+		return err
+	}
+
+	var storedErrors any
+	if len(validationErrors) > 0 {
+		storedErrors = string(validationErrors)
+	}
+
+	for attempt := range maxAttempts {
+		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
+			result, execErr := tx.ExecContext(ctx, updateQuery, StatusInvalid, reason.Message, string(storedFailure), storedErrors, id, StatusIntaking)
+			if execErr != nil {
+				return execErr
+			}
+
+			affected, affErr := result.RowsAffected()
+			if affErr != nil {
+				return affErr
+			}
+
+			if affected == 0 {
+				return ErrLockNotAcquired
+			}
+
+			_, histErr := tx.ExecContext(ctx, historyQuery, id, StatusInvalid, reason.Message, string(storedFailure))
+			return histErr
+		})
+
+		if err == nil {
+			o.notify(StatusInvalid)
+			return nil
+		}
+
+		if !o.shouldRetryMySQL(err) {
+			return err
+		}
+
+		sleep := o.backoffDuration(attempt)
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return err
+}
+
 // Ready updates the email to READY status.
 // Expected from status is INTAKING.
 // The operation is executed within a transaction with retry logic for transient errors.
-func (o *Outbox) Ready(ctx context.Context, id string) error {
+func (o *Outbox) Ready(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "outbox.Ready", attribute.String("email.id", id))
+	defer func() { tracing.End(span, err) }()
+
 	updateQuery := `
 		UPDATE emails
 		SET status = ?, version = version + 1
@@ -358,7 +686,6 @@ func (o *Outbox) Ready(ctx context.Context, id string) error {
 		VALUES (?, ?, ?)
 	`
 
-	var err error
 	for attempt := range maxAttempts {
 		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
 			result, execErr := tx.ExecContext(ctx, updateQuery, StatusReady, id, StatusIntaking)
@@ -379,6 +706,83 @@ func (o *Outbox) Ready(ctx context.Context, id string) error {
 			return histErr
 		})
 
+		if err == nil {
+			o.notify(StatusReady)
+			return nil
+		}
+
+		if !o.shouldRetryMySQL(err) {
+			return err
+		}
+
+		sleep := o.backoffDuration(attempt)
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return err
+}
+
+// MarkSending durably records that the email identified by id is about to be handed to SMTP.
+// The idempotency key (the payload id) is unique: a second marker for the same key returns ErrDuplicateSend.
+// The email row is locked first, so a concurrent cancellation either wins (ErrCancelled) or sees the marker.
+// The envelope sender the email is handed over with is stored as its return_path, see FindByReturnPath.
+// The operation is executed within a transaction with retry logic for transient errors.
+func (o *Outbox) MarkSending(ctx context.Context, id string, idempotencyKey string, returnPath string) (err error) {
+	ctx, span := tracing.Start(ctx, "outbox.MarkSending", attribute.String("email.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	lockQuery := `SELECT status FROM emails WHERE id = ? FOR UPDATE`
+	markerQuery := `
+		INSERT INTO email_send_markers (idempotency_key, email_id)
+		SELECT ?, ? FROM DUAL
+		WHERE NOT EXISTS (SELECT 1 FROM email_send_markers_archive WHERE idempotency_key = ?)
+	`
+	returnPathQuery := `UPDATE emails SET return_path = ? WHERE id = ?`
+
+	for attempt := range maxAttempts {
+		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
+			var status string
+			if lockErr := tx.QueryRowContext(ctx, lockQuery, id).Scan(&status); lockErr != nil {
+				return lockErr
+			}
+
+			if status == StatusCancelled {
+				return ErrCancelled
+			}
+
+			if status != StatusProcessing {
+				return ErrLockNotAcquired
+			}
+
+			result, execErr := tx.ExecContext(ctx, markerQuery, idempotencyKey, id, idempotencyKey)
+
+			var mysqlErr *mysql.MySQLError
+			if errors.As(execErr, &mysqlErr) && mysqlErr.Number == duplicateKeyErrNo {
+				return ErrDuplicateSend
+			}
+			if execErr != nil {
+				return execErr
+			}
+
+			affected, execErr := result.RowsAffected()
+			if execErr != nil {
+				return execErr
+			}
+			// the key is in the archive: the email that used it was removed by retention
+			if affected == 0 {
+				return ErrDuplicateSend
+			}
+
+			_, execErr = tx.ExecContext(ctx, returnPathQuery, returnPath, id)
+			return execErr
+		})
+
 		if err == nil || !o.shouldRetryMySQL(err) {
 			return err
 		}
@@ -396,31 +800,365 @@ func (o *Outbox) Ready(ctx context.Context, id string) error {
 	return err
 }
 
-// getExpectedFromStatus returns the expected previous status for a given target status.
-// This maps the state machine transitions.
-func getExpectedFromStatus(toStatus string) string {
-	transitions := map[string]string{
-		StatusIntaking:              StatusAccepted,
-		StatusReady:                 StatusIntaking,
-		StatusProcessing:            StatusReady,
-		StatusSent:                  StatusProcessing,
-		StatusFailed:                StatusProcessing,
-		StatusInvalid:               StatusIntaking,
-		StatusCallingSentCallback:   StatusSent,
-		StatusCallingFailedCallback: StatusFailed,
-		StatusSentAcknowledged:      StatusCallingSentCallback,
-		StatusFailedAcknowledged:    StatusCallingFailedCallback,
+// ClearSendMarker removes the send marker of an email, allowing it to be sent again.
+func (o *Outbox) ClearSendMarker(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "outbox.ClearSendMarker", attribute.String("email.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	query := `DELETE FROM email_send_markers WHERE email_id = ?`
+	_, err = o.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// FindBySendMarker returns the id of the email sent with the given idempotency key (the payload id),
+// searching the send markers of the emails removed by retention too.
+// It returns ErrNotFound if no send marker has the key.
+func (o *Outbox) FindBySendMarker(ctx context.Context, idempotencyKey string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "outbox.FindBySendMarker")
+	defer func() { tracing.End(span, err) }()
+
+	query := `
+		SELECT email_id FROM email_send_markers WHERE idempotency_key = ?
+		UNION ALL
+		SELECT email_id FROM email_send_markers_archive WHERE idempotency_key = ?
+		LIMIT 1
+	`
+
+	rows, err := o.db.QueryContext(ctx, query, idempotencyKey, idempotencyKey)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", ErrNotFound
 	}
 
-	if from, ok := transitions[toStatus]; ok {
-		return from
+	var emailId string
+	if err := rows.Scan(&emailId); err != nil {
+		return "", err
 	}
-	return ""
+
+	return emailId, nil
 }
 
-// Create inserts a new email into the database (used for testing and by producer)
+// FindByReturnPath returns the id of the email handed to SMTP with the given envelope sender.
+// Only a VERP return path identifies a single email: with a shared one the most recent email is returned.
+// Both the hot and the cold table are searched.
+// It returns ErrNotFound if no email was sent with the return path.
+func (o *Outbox) FindByReturnPath(ctx context.Context, returnPath string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "outbox.FindByReturnPath")
+	defer func() { tracing.End(span, err) }()
+
+	query := `
+		SELECT id FROM (
+			SELECT id, updated_at FROM emails WHERE return_path = ?
+			UNION ALL
+			SELECT id, updated_at FROM emails_archive WHERE return_path = ?
+		) AS sent
+		ORDER BY updated_at DESC
+		LIMIT 1
+	`
+
+	rows, err := o.db.QueryContext(ctx, query, returnPath, returnPath)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", ErrNotFound
+	}
+
+	var emailId string
+	if err := rows.Scan(&emailId); err != nil {
+		return "", err
+	}
+
+	return emailId, nil
+}
+
+// HasSendMarker reports whether the email has a send marker, meaning it may already have been accepted by SMTP.
+func (o *Outbox) HasSendMarker(ctx context.Context, id string) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "outbox.HasSendMarker", attribute.String("email.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	query := `SELECT COUNT(*) FROM email_send_markers WHERE email_id = ?`
+
+	rows, err := o.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var count int
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return false, err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// History returns the status changes of an email ordered from the oldest to the newest.
+// Both the hot email_statuses table and the cold email_statuses_archive table are searched.
+func (o *Outbox) History(ctx context.Context, id string) ([]StatusChange, error) {
+	query := `
+		SELECT status, reason, failure, operator, created_at FROM (
+			SELECT id, status, reason, failure, operator, created_at FROM email_statuses WHERE email_id = ?
+			UNION ALL
+			SELECT id, status, reason, failure, operator, created_at FROM email_statuses_archive WHERE email_id = ?
+		) AS history
+		ORDER BY id ASC
+	`
+
+	rows, err := o.db.QueryContext(ctx, query, id, id)
+	if err != nil {
+		return []StatusChange{}, err
+	}
+	defer rows.Close()
+
+	var history []StatusChange
+	for rows.Next() {
+		var c StatusChange
+		var reason, failureJSON, operator sql.NullString
+		var createdAt time.Time
+
+		if err := rows.Scan(&c.Status, &reason, &failureJSON, &operator, &createdAt); err != nil {
+			return []StatusChange{}, err
+		}
+
+		c.Reason = reason.String
+		c.Failure = decodeFailure(failureJSON)
+		c.Operator = operator.String
+		c.CreatedAt = createdAt.Format(time.RFC3339)
+
+		history = append(history, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return []StatusChange{}, err
+	}
+
+	return history, nil
+}
+
+// Purge deletes the given emails, together with their history, if they are still in the given status.
+// Their send markers and ingestion keys are kept in the archive tables, see archiveKeys.
+// It returns the ids of the deleted emails: an email whose status changed in the meantime is left untouched.
 // The operation is executed within a transaction with retry logic for transient errors.
-func (o *Outbox) Create(ctx context.Context, id string, status string, payloadFilePath string) error {
+func (o *Outbox) Purge(ctx context.Context, status string, ids []string) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "outbox.Purge", attribute.String("email.status", status), attribute.Int("email.count", len(ids)))
+	defer func() { tracing.End(span, err) }()
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var purged []string
+	for attempt := range maxAttempts {
+		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
+			locked, lockErr := lockInStatus(ctx, tx, status, ids)
+			if lockErr != nil || len(locked) == 0 {
+				purged = nil
+				return lockErr
+			}
+
+			placeholders := "?" + strings.Repeat(", ?", len(locked)-1)
+			args := statusAndIds(status, locked)
+			if keysErr := archiveKeys(ctx, tx, placeholders, args); keysErr != nil {
+				return keysErr
+			}
+
+			// History, send markers and ingestion keys will be deleted via CASCADE
+			deleteQuery := `DELETE FROM emails WHERE status = ? AND id IN (` + placeholders + `)`
+			if _, execErr := tx.ExecContext(ctx, deleteQuery, args...); execErr != nil {
+				return execErr
+			}
+
+			purged = locked
+			return nil
+		})
+
+		if err == nil {
+			return purged, nil
+		}
+
+		if !o.shouldRetryMySQL(err) {
+			return nil, err
+		}
+
+		sleep := o.backoffDuration(attempt)
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return nil, err
+}
+
+// MoveToArchive moves the given emails, together with their history, from the hot tables to
+// emails_archive and email_statuses_archive if they are still in the given status. Their send markers and
+// ingestion keys are kept in the archive tables, see archiveKeys.
+// It returns the ids of the moved emails: an email whose status changed in the meantime is left untouched.
+// The operation is executed within a transaction with retry logic for transient errors.
+func (o *Outbox) MoveToArchive(ctx context.Context, status string, ids []string) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "outbox.MoveToArchive", attribute.String("email.status", status), attribute.Int("email.count", len(ids)))
+	defer func() { tracing.End(span, err) }()
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var moved []string
+	for attempt := range maxAttempts {
+		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
+			locked, lockErr := lockInStatus(ctx, tx, status, ids)
+			if lockErr != nil || len(locked) == 0 {
+				moved = nil
+				return lockErr
+			}
+
+			placeholders := "?" + strings.Repeat(", ?", len(locked)-1)
+			emailQuery := `
+				INSERT INTO emails_archive (id, status, payload_file_path, return_path, reason, failure, version, created_at, updated_at)
+				SELECT id, status, payload_file_path, return_path, reason, failure, version, created_at, updated_at
+				FROM emails
+				WHERE status = ? AND id IN (` + placeholders + `)
+			`
+			historyQuery := `
+				INSERT INTO email_statuses_archive (id, email_id, status, reason, failure, operator, created_at)
+				SELECT s.id, s.email_id, s.status, s.reason, s.failure, s.operator, s.created_at
+				FROM email_statuses s
+				JOIN emails e ON e.id = s.email_id
+				WHERE e.status = ? AND e.id IN (` + placeholders + `)
+			`
+			deleteQuery := `DELETE FROM emails WHERE status = ? AND id IN (` + placeholders + `)`
+			args := statusAndIds(status, locked)
+
+			if _, execErr := tx.ExecContext(ctx, emailQuery, args...); execErr != nil {
+				return execErr
+			}
+
+			if _, execErr := tx.ExecContext(ctx, historyQuery, args...); execErr != nil {
+				return execErr
+			}
+
+			if keysErr := archiveKeys(ctx, tx, placeholders, args); keysErr != nil {
+				return keysErr
+			}
+
+			// History, send markers and ingestion keys of the hot tables will be deleted via CASCADE
+			if _, execErr := tx.ExecContext(ctx, deleteQuery, args...); execErr != nil {
+				return execErr
+			}
+
+			moved = locked
+			return nil
+		})
+
+		if err == nil {
+			return moved, nil
+		}
+
+		if !o.shouldRetryMySQL(err) {
+			return nil, err
+		}
+
+		sleep := o.backoffDuration(attempt)
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return nil, err
+}
+
+// archiveKeys copies the send markers and ingestion keys of the emails about to be removed to
+// email_send_markers_archive and ingestion_keys_archive, so that the payload ids and ingestion keys cannot be
+// used again and FindBySendMarker and FindByIdempotencyKey still find them. args are the status and the ids.
+func archiveKeys(ctx context.Context, tx *sql.Tx, placeholders string, args []any) error {
+	markersQuery := `
+		INSERT INTO email_send_markers_archive (idempotency_key, email_id, created_at)
+		SELECT m.idempotency_key, m.email_id, m.created_at
+		FROM email_send_markers m
+		JOIN emails e ON e.id = m.email_id
+		WHERE e.status = ? AND e.id IN (` + placeholders + `)
+	`
+	keysQuery := `
+		INSERT INTO ingestion_keys_archive (idempotency_key, email_id, request_hash, created_at)
+		SELECT k.idempotency_key, k.email_id, k.request_hash, k.created_at
+		FROM ingestion_keys k
+		JOIN emails e ON e.id = k.email_id
+		WHERE e.status = ? AND e.id IN (` + placeholders + `)
+	`
+
+	if _, err := tx.ExecContext(ctx, markersQuery, args...); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, keysQuery, args...)
+	return err
+}
+
+// lockInStatus locks the rows of the given emails still in status and returns their ids.
+func lockInStatus(ctx context.Context, tx *sql.Tx, status string, ids []string) ([]string, error) {
+	lockQuery := `
+		SELECT id FROM emails
+		WHERE status = ? AND id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)
+		FOR UPDATE
+	`
+
+	rows, err := tx.QueryContext(ctx, lockQuery, statusAndIds(status, ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locked []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		locked = append(locked, id)
+	}
+	return locked, rows.Err()
+}
+
+func statusAndIds(status string, ids []string) []any {
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, status)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return args
+}
+
+// Create inserts a new email into the database (used for testing; producers should use the ingestion API)
+// The operation is executed within a transaction with retry logic for transient errors.
+func (o *Outbox) Create(ctx context.Context, id string, status string, payloadFilePath string) (err error) {
+	ctx, span := tracing.Start(ctx, "outbox.Create", attribute.String("email.id", id), attribute.String("email.status", status))
+	defer func() { tracing.End(span, err) }()
+
 	emailQuery := `
 		INSERT INTO emails (id, status, payload_file_path)
 		VALUES (?, ?, ?)
@@ -430,7 +1168,6 @@ func (o *Outbox) Create(ctx context.Context, id string, status string, payloadFi
 		VALUES (?, ?, ?)
 	`
 
-	var err error
 	for attempt := range maxAttempts {
 		err = o.executeInTransaction(ctx, func(tx *sql.Tx) error {
 			if _, execErr := tx.ExecContext(ctx, emailQuery, id, status, payloadFilePath); execErr != nil {
@@ -441,7 +1178,12 @@ func (o *Outbox) Create(ctx context.Context, id string, status string, payloadFi
 			return histErr
 		})
 
-		if err == nil || !o.shouldRetryMySQL(err) {
+		if err == nil {
+			o.notify(status)
+			return nil
+		}
+
+		if !o.shouldRetryMySQL(err) {
 			return err
 		}
 
@@ -465,3 +1207,16 @@ func (o *Outbox) Delete(ctx context.Context, id string) error {
 	_, err := o.db.ExecContext(ctx, query, id)
 	return err
 }
+
+// decodeFailure reads a failure column, a NULL or unreadable value yields nil.
+func decodeFailure(column sql.NullString) *failure.Reason {
+	if !column.Valid {
+		return nil
+	}
+
+	var reason failure.Reason
+	if err := json.Unmarshal([]byte(column.String), &reason); err != nil {
+		return nil
+	}
+	return &reason
+}
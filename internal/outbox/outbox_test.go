@@ -4,13 +4,18 @@ package outbox
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"mailculator-processor/internal/failure"
+	"mailculator-processor/internal/metrics"
 )
 
 func TestQuery_WhenDatabaseHasRecords_ShouldReturnEmails(t *testing.T) {
@@ -22,11 +27,11 @@ func TestQuery_WhenDatabaseHasRecords_ShouldReturnEmails(t *testing.T) {
 
 	now := time.Now()
 
-	rows := sqlmock.NewRows([]string{"id", "status", "payload_file_path", "reason", "version", "updated_at"}).
-		AddRow("test-id-1", "READY", "/path/to/payload", "", 1, now).
-		AddRow("test-id-2", "READY", "/path/to/payload2", "some reason", 2, now)
+	rows := sqlmock.NewRows([]string{"id", "status", "payload_file_path", "reason", "version", "callback_attempts", "validation_errors", "failure", "updated_at"}).
+		AddRow("test-id-1", "READY", "/path/to/payload", "", 1, 0, nil, nil, now).
+		AddRow("test-id-2", "INVALID", "/path/to/payload2", "some reason", 2, 1, `[{"field":"to","tag":"email","value":"nope"}]`, `{"category":"validation","field":"to","message":"invalid payload: to (email)"}`, now)
 
-	mock.ExpectQuery("SELECT id, status, payload_file_path, reason, version, updated_at FROM emails").
+	mock.ExpectQuery("SELECT id, status, payload_file_path, reason, version, callback_attempts, validation_errors, failure, updated_at FROM emails").
 		WithArgs("READY", 25).
 		WillReturnRows(rows)
 
@@ -39,6 +44,10 @@ func TestQuery_WhenDatabaseHasRecords_ShouldReturnEmails(t *testing.T) {
 	assert.Equal(t, "test-id-1", emails[0].Id)
 	assert.Equal(t, "READY", emails[0].Status)
 	assert.Equal(t, "test-id-2", emails[1].Id)
+	assert.Empty(t, emails[0].ValidationErrors)
+	assert.JSONEq(t, `[{"field":"to","tag":"email","value":"nope"}]`, string(emails[1].ValidationErrors))
+	assert.Nil(t, emails[0].Failure)
+	assert.Equal(t, &failure.Reason{Category: failure.CategoryValidation, Field: "to", Message: "invalid payload: to (email)"}, emails[1].Failure)
 
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -69,7 +78,7 @@ func TestQuery_WhenDatabaseHasNoRecords_ShouldReturnEmptySlice(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	rows := sqlmock.NewRows([]string{"id", "status", "payload_file_path", "reason", "version", "updated_at"})
+	rows := sqlmock.NewRows([]string{"id", "status", "payload_file_path", "reason", "version", "callback_attempts", "validation_errors", "failure", "updated_at"})
 
 	mock.ExpectQuery("SELECT").
 		WithArgs("READY", 10).
@@ -92,10 +101,10 @@ func TestQueryStale_WhenDatabaseHasRecords_ShouldReturnEmails(t *testing.T) {
 	defer db.Close()
 
 	now := time.Now()
-	rows := sqlmock.NewRows([]string{"id", "status", "payload_file_path", "reason", "version", "updated_at"}).
-		AddRow("test-id-1", "READY", "/path/to/payload", "", 1, now)
+	rows := sqlmock.NewRows([]string{"id", "status", "payload_file_path", "reason", "version", "callback_attempts", "validation_errors", "failure", "updated_at"}).
+		AddRow("test-id-1", "READY", "/path/to/payload", "", 1, 0, nil, nil, now)
 
-	mock.ExpectQuery("SELECT id, status, payload_file_path, reason, version, updated_at FROM emails").
+	mock.ExpectQuery("SELECT id, status, payload_file_path, reason, version, callback_attempts, validation_errors, failure, updated_at FROM emails").
 		WithArgs("READY", sqlmock.AnyArg(), 25).
 		WillReturnRows(rows)
 
@@ -133,6 +142,30 @@ func TestUpdate_WhenUpdateSucceeds_ShouldReturnNoError(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestUpdate_WhenCallbackAcknowledged_ShouldResetCallbackRetry(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails SET status = \\?, reason = \\?, callback_attempts = 0, next_attempt_at = NULL").
+		WithArgs("SENT-ACKNOWLEDGED", "", "test-id", "CALLING-SENT-CALLBACK").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "SENT-ACKNOWLEDGED", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.Update(context.TODO(), "test-id", StatusSentAcknowledged, "")
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestUpdateFrom_WhenUpdateSucceeds_ShouldReturnNoError(t *testing.T) {
 	t.Parallel()
 
@@ -157,6 +190,175 @@ func TestUpdateFrom_WhenUpdateSucceeds_ShouldReturnNoError(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestScheduleCallbackRetry_ShouldCountAttemptAndKeepReason(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails SET status = \\?, callback_attempts = callback_attempts \\+ 1, next_attempt_at = NOW\\(\\) \\+ INTERVAL \\? SECOND").
+		WithArgs("FAILED", int64(3), "test-id", "CALLING-FAILED-CALLBACK").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "FAILED", "callback status 503").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.ScheduleCallbackRetry(context.TODO(), "test-id", StatusCallingFailedCallback, StatusFailed, 2500*time.Millisecond, "callback status 503")
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestScheduleCallbackRetry_WhenNotACallbackRollback_ShouldReturnTransitionNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.ScheduleCallbackRetry(context.TODO(), "test-id", StatusSent, StatusCallingSentCallback, time.Second, "")
+
+	assert.ErrorIs(t, err, ErrTransitionNotAllowed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFail_ShouldStoreReasonAndFailure(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storedFailure := `{"category":"smtp-permanent","smtp_code":550,"enhanced_code":"5.1.1","message":"550 5.1.1 User unknown"}`
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails SET status = \\?, reason = \\?, failure = \\?").
+		WithArgs("FAILED", "550 5.1.1 User unknown", storedFailure, "test-id", "PROCESSING").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses \\(email_id, status, reason, failure\\)").
+		WithArgs("test-id", "FAILED", "550 5.1.1 User unknown", storedFailure).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	reason := failure.Reason{Category: failure.CategorySMTPPermanent, SMTPCode: 550, EnhancedCode: "5.1.1", Message: "550 5.1.1 User unknown"}
+	err = sut.Fail(context.TODO(), "test-id", StatusFailed, reason)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFail_WhenNoPipelineTransitionReachesStatus_ShouldReturnError(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.Fail(context.TODO(), "test-id", StatusSentCallbackFailed, failure.Reason{Category: failure.CategoryInternal})
+
+	assert.ErrorIs(t, err, ErrTransitionNotAllowed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFailFrom_WhenSentCallbackWasGivenUp_ShouldRecordBounce(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storedFailure := `{"category":"bounce-hard","smtp_code":550,"enhanced_code":"5.1.1","message":"550 5.1.1 User unknown"}`
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails SET status = \\?, reason = \\?, failure = \\?").
+		WithArgs("BOUNCED", "550 5.1.1 User unknown", storedFailure, "test-id", "SENT-CALLBACK-FAILED").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "BOUNCED", "550 5.1.1 User unknown", storedFailure).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	reason := failure.Reason{Category: failure.CategoryBounceHard, SMTPCode: 550, EnhancedCode: "5.1.1", Message: "550 5.1.1 User unknown"}
+	err = sut.FailFrom(context.TODO(), "test-id", StatusSentCallbackFailed, StatusBounced, reason)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFailFrom_WhenTransitionIsOperatorOnly_ShouldReturnError(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.FailFrom(context.TODO(), "test-id", StatusQuarantined, StatusFailed, failure.Reason{Category: failure.CategoryInternal})
+
+	assert.ErrorIs(t, err, ErrTransitionNotAllowed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMarkInvalid_ShouldStoreValidationErrors(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	validationErrors := `[{"field":"to","tag":"email","value":"nope"}]`
+	storedFailure := `{"category":"validation","field":"to","message":"invalid payload: to (email)"}`
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails SET status = \\?, reason = \\?, failure = \\?, validation_errors = \\?").
+		WithArgs("INVALID", "invalid payload: to (email)", storedFailure, validationErrors, "test-id", "INTAKING").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "INVALID", "invalid payload: to (email)", storedFailure).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	reason := failure.Reason{Category: failure.CategoryValidation, Field: "to", Message: "invalid payload: to (email)"}
+	err = sut.MarkInvalid(context.TODO(), "test-id", reason, json.RawMessage(validationErrors))
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMarkInvalid_WithoutValidationErrors_ShouldStoreNull(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails").
+		WithArgs("INVALID", "failed to read payload file", `{"category":"internal","message":"failed to read payload file"}`, nil, "test-id", "INTAKING").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.MarkInvalid(context.TODO(), "test-id", failure.Reason{Category: failure.CategoryInternal, Message: "failed to read payload file"}, nil)
+
+	assert.ErrorIs(t, err, ErrLockNotAcquired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestUpdate_WhenNoRowsAffected_ShouldReturnLockError(t *testing.T) {
 	t.Parallel()
 
@@ -248,6 +450,41 @@ func TestReady_WhenNoRowsAffected_ShouldReturnLockError(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+type notifierMock struct {
+	statuses []string
+}
+
+func (n *notifierMock) Notify(status string) {
+	n.statuses = append(n.statuses, status)
+}
+
+func TestUpdate_WhenCommitted_ShouldNotifyNewStatus(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE emails").
+		WithArgs("SENT", "", "test-id", "PROCESSING").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses").
+		WithArgs("test-id", "SENT", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	notifier := &notifierMock{}
+	sut := NewOutboxWithDB(db)
+	sut.SetNotifier(notifier)
+
+	err = sut.Update(context.TODO(), "test-id", StatusSent, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{StatusSent}, notifier.statuses)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestGetExpectedFromStatus_ShouldReturnCorrectTransitions(t *testing.T) {
 	t.Parallel()
 
@@ -265,6 +502,9 @@ func TestGetExpectedFromStatus_ShouldReturnCorrectTransitions(t *testing.T) {
 		{StatusCallingFailedCallback, StatusFailed},
 		{StatusSentAcknowledged, StatusCallingSentCallback},
 		{StatusFailedAcknowledged, StatusCallingFailedCallback},
+		{StatusCallingCancelledCallback, StatusCancelled},
+		{StatusCancelledAcknowledged, StatusCallingCancelledCallback},
+		{StatusQuarantined, ""},
 	}
 
 	for _, tc := range testCases {
@@ -346,6 +586,22 @@ func TestShouldRetryMySQL_WhenNilError_ShouldReturnFalse(t *testing.T) {
 	assert.False(t, result)
 }
 
+func TestShouldRetryMySQL_WhenDeadlock_ShouldReturnTrueAndCountRetry(t *testing.T) {
+	t.Parallel()
+
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sut := NewOutboxWithDB(db)
+	before := metrics.MySQLRetries.Value("1213")
+
+	result := sut.shouldRetryMySQL(&mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+
+	assert.True(t, result)
+	assert.Equal(t, before+1, metrics.MySQLRetries.Value("1213"))
+}
+
 func TestShouldRetryMySQL_WhenGenericError_ShouldReturnFalse(t *testing.T) {
 	t.Parallel()
 
@@ -375,3 +631,410 @@ func TestBackoffDuration_ShouldReturnPositiveDuration(t *testing.T) {
 		assert.LessOrEqual(t, duration, maxDelay)
 	}
 }
+
+func TestMarkSending_WhenInsertSucceeds_ShouldReturnNoError(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM emails").
+		WithArgs("test-id").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(StatusProcessing))
+	mock.ExpectExec("INSERT INTO email_send_markers").
+		WithArgs("payload-id", "test-id", "payload-id").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE emails SET return_path").
+		WithArgs("bounces+test-id@example.com", "test-id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.MarkSending(context.TODO(), "test-id", "payload-id", "bounces+test-id@example.com")
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMarkSending_WhenKeyAlreadyExists_ShouldReturnDuplicateSendError(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM emails").
+		WithArgs("test-id").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(StatusProcessing))
+	mock.ExpectExec("INSERT INTO email_send_markers").
+		WithArgs("payload-id", "test-id", "payload-id").
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	mock.ExpectRollback()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.MarkSending(context.TODO(), "test-id", "payload-id", "bounces+test-id@example.com")
+
+	assert.ErrorIs(t, err, ErrDuplicateSend)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMarkSending_WhenKeyIsArchived_ShouldReturnDuplicateSendError(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM emails").
+		WithArgs("test-id").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(StatusProcessing))
+	mock.ExpectExec("INSERT INTO email_send_markers .*WHERE NOT EXISTS \\(SELECT 1 FROM email_send_markers_archive").
+		WithArgs("payload-id", "test-id", "payload-id").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.MarkSending(context.TODO(), "test-id", "payload-id", "bounces+test-id@example.com")
+
+	assert.ErrorIs(t, err, ErrDuplicateSend)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMarkSending_WhenEmailCancelled_ShouldReturnCancelledError(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM emails").
+		WithArgs("test-id").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(StatusCancelled))
+	mock.ExpectRollback()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.MarkSending(context.TODO(), "test-id", "payload-id", "bounces+test-id@example.com")
+
+	assert.ErrorIs(t, err, ErrCancelled)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHasSendMarker_WhenMarkerExists_ShouldReturnTrue(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM email_send_markers").
+		WithArgs("test-id").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	sut := NewOutboxWithDB(db)
+
+	marked, err := sut.HasSendMarker(context.TODO(), "test-id")
+
+	assert.NoError(t, err)
+	assert.True(t, marked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindByReturnPath_WhenEmailExists_ShouldReturnEmailId(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id FROM \\(.*FROM emails WHERE return_path = \\?.*UNION ALL.*FROM emails_archive WHERE return_path = \\?").
+		WithArgs("bounces+test-id@example.com", "bounces+test-id@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("test-id"))
+
+	sut := NewOutboxWithDB(db)
+
+	emailId, err := sut.FindByReturnPath(context.TODO(), "bounces+test-id@example.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test-id", emailId)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindByReturnPath_WhenEmailMissing_ShouldReturnNotFound(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id FROM \\(.*FROM emails WHERE return_path = \\?").
+		WithArgs("bounces+unknown@example.com", "bounces+unknown@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	sut := NewOutboxWithDB(db)
+
+	_, err = sut.FindByReturnPath(context.TODO(), "bounces+unknown@example.com")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindBySendMarker_WhenMarkerExists_ShouldReturnEmailId(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT email_id FROM email_send_markers .*UNION ALL.*FROM email_send_markers_archive").
+		WithArgs("payload-id", "payload-id").
+		WillReturnRows(sqlmock.NewRows([]string{"email_id"}).AddRow("test-id"))
+
+	sut := NewOutboxWithDB(db)
+
+	emailId, err := sut.FindBySendMarker(context.TODO(), "payload-id")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test-id", emailId)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindBySendMarker_WhenMarkerMissing_ShouldReturnNotFound(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT email_id FROM email_send_markers .*UNION ALL.*FROM email_send_markers_archive").
+		WithArgs("payload-id", "payload-id").
+		WillReturnRows(sqlmock.NewRows([]string{"email_id"}))
+
+	sut := NewOutboxWithDB(db)
+
+	_, err = sut.FindBySendMarker(context.TODO(), "payload-id")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClearSendMarker_WhenDeleteSucceeds_ShouldReturnNoError(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM email_send_markers").
+		WithArgs("test-id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.ClearSendMarker(context.TODO(), "test-id")
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHistory_WhenDatabaseHasRecords_ShouldReturnStatusChanges(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	rows := sqlmock.NewRows([]string{"status", "reason", "failure", "operator", "created_at"}).
+		AddRow("ACCEPTED", nil, nil, nil, now).
+		AddRow("INVALID", "payload validation failed", `{"category":"validation","message":"payload validation failed"}`, nil, now).
+		AddRow("ACCEPTED", "requeued", nil, "alice", now)
+
+	mock.ExpectQuery("SELECT status, reason, failure, operator, created_at FROM \\(.*FROM email_statuses .*UNION ALL.*FROM email_statuses_archive ").
+		WithArgs("test-id", "test-id").
+		WillReturnRows(rows)
+
+	sut := NewOutboxWithDB(db)
+
+	history, err := sut.History(context.TODO(), "test-id")
+
+	assert.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, "ACCEPTED", history[0].Status)
+	assert.Equal(t, "", history[0].Reason)
+	assert.Equal(t, "payload validation failed", history[1].Reason)
+	assert.Equal(t, failure.CategoryValidation, history[1].Failure.Category)
+	assert.Nil(t, history[2].Failure)
+	assert.Equal(t, "", history[1].Operator)
+	assert.Equal(t, "alice", history[2].Operator)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurge_WhenDeleteSucceeds_ShouldReturnPurgedIds(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM emails WHERE status = \\? AND id IN \\(\\?, \\?\\) FOR UPDATE").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1", "id-2").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id-1").AddRow("id-2"))
+	mock.ExpectExec("INSERT INTO email_send_markers_archive").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1", "id-2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO ingestion_keys_archive").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1", "id-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM emails WHERE status = \\? AND id IN \\(\\?, \\?\\)").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1", "id-2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	purged, err := sut.Purge(context.TODO(), StatusSentAcknowledged, []string{"id-1", "id-2"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id-1", "id-2"}, purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurge_WhenStatusChanged_ShouldDeleteOnlyEmailsStillInStatus(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM emails").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1", "id-2").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id-2"))
+	mock.ExpectExec("INSERT INTO email_send_markers_archive").
+		WithArgs("SENT-ACKNOWLEDGED", "id-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO ingestion_keys_archive").
+		WithArgs("SENT-ACKNOWLEDGED", "id-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM emails WHERE status = \\? AND id IN \\(\\?\\)").
+		WithArgs("SENT-ACKNOWLEDGED", "id-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	purged, err := sut.Purge(context.TODO(), StatusSentAcknowledged, []string{"id-1", "id-2"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id-2"}, purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurge_WhenNoIds_ShouldNotQueryDatabase(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sut := NewOutboxWithDB(db)
+
+	purged, err := sut.Purge(context.TODO(), StatusSentAcknowledged, nil)
+
+	assert.NoError(t, err)
+	assert.Empty(t, purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMoveToArchive_WhenMoveSucceeds_ShouldReturnMovedIds(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM emails").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id-1"))
+	mock.ExpectExec("INSERT INTO emails_archive").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_statuses_archive").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1").
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("INSERT INTO email_send_markers_archive").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO ingestion_keys_archive").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM emails").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	moved, err := sut.MoveToArchive(context.TODO(), StatusSentAcknowledged, []string{"id-1"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id-1"}, moved)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMoveToArchive_WhenInsertFails_ShouldRollback(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectedError := errors.New("database error")
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM emails").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id-1"))
+	mock.ExpectExec("INSERT INTO emails_archive").
+		WillReturnError(expectedError)
+	mock.ExpectRollback()
+
+	sut := NewOutboxWithDB(db)
+
+	moved, err := sut.MoveToArchive(context.TODO(), StatusSentAcknowledged, []string{"id-1"})
+
+	assert.Equal(t, expectedError, err)
+	assert.Empty(t, moved)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMoveToArchive_WhenNoEmailStillInStatus_ShouldNotMoveAnything(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM emails").
+		WithArgs("SENT-ACKNOWLEDGED", "id-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	sut := NewOutboxWithDB(db)
+
+	moved, err := sut.MoveToArchive(context.TODO(), StatusSentAcknowledged, []string{"id-1"})
+
+	assert.NoError(t, err)
+	assert.Empty(t, moved)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,206 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Actors that perform a status transition.
+const (
+	// ActorPipeline is a forward step of a pipeline, applied with Update
+	ActorPipeline = "pipeline"
+	// ActorRecovery rolls an email back or parks it, applied with UpdateFrom by restore pipelines and the sender,
+	// or records a bounce of an email whose sent callback was given up, applied with FailFrom
+	ActorRecovery = "recovery"
+	// ActorOperator is a manual action, applied with ApplyOperatorAction
+	ActorOperator = "operator"
+)
+
+var ErrTransitionNotAllowed = errors.New("transition not allowed from the current status")
+
+// Transition is an allowed status change of the outbox state machine.
+type Transition struct {
+	From  string
+	To    string
+	Actor string
+	// Action is the operator action triggering the transition, set only for ActorOperator
+	Action string
+}
+
+// transitions is the single definition of the state machine: every status write is checked against it.
+var transitions = []Transition{
+	{From: StatusAccepted, To: StatusIntaking, Actor: ActorPipeline},
+	{From: StatusIntaking, To: StatusReady, Actor: ActorPipeline},
+	{From: StatusIntaking, To: StatusInvalid, Actor: ActorPipeline},
+	{From: StatusReady, To: StatusProcessing, Actor: ActorPipeline},
+	{From: StatusProcessing, To: StatusSent, Actor: ActorPipeline},
+	{From: StatusProcessing, To: StatusFailed, Actor: ActorPipeline},
+	{From: StatusSent, To: StatusCallingSentCallback, Actor: ActorPipeline},
+	{From: StatusFailed, To: StatusCallingFailedCallback, Actor: ActorPipeline},
+	{From: StatusCancelled, To: StatusCallingCancelledCallback, Actor: ActorPipeline},
+	{From: StatusInvalid, To: StatusCallingInvalidCallback, Actor: ActorPipeline},
+	{From: StatusCallingSentCallback, To: StatusSentAcknowledged, Actor: ActorPipeline},
+	{From: StatusCallingFailedCallback, To: StatusFailedAcknowledged, Actor: ActorPipeline},
+	{From: StatusCallingCancelledCallback, To: StatusCancelledAcknowledged, Actor: ActorPipeline},
+	{From: StatusCallingInvalidCallback, To: StatusInvalidAcknowledged, Actor: ActorPipeline},
+	{From: StatusSentAcknowledged, To: StatusBounced, Actor: ActorPipeline},
+	{From: StatusBounced, To: StatusCallingBouncedCallback, Actor: ActorPipeline},
+	{From: StatusCallingBouncedCallback, To: StatusBouncedAcknowledged, Actor: ActorPipeline},
+	{From: StatusSendUncertain, To: StatusCallingSendUncertainCallback, Actor: ActorPipeline},
+	{From: StatusCallingSendUncertainCallback, To: StatusSendUncertainAcknowledged, Actor: ActorPipeline},
+
+	{From: StatusIntaking, To: StatusAccepted, Actor: ActorRecovery},
+	{From: StatusProcessing, To: StatusReady, Actor: ActorRecovery},
+	{From: StatusProcessing, To: StatusQuarantined, Actor: ActorRecovery},
+	{From: StatusProcessing, To: StatusSendUncertain, Actor: ActorRecovery},
+	{From: StatusCallingSentCallback, To: StatusSent, Actor: ActorRecovery},
+	{From: StatusCallingFailedCallback, To: StatusFailed, Actor: ActorRecovery},
+	{From: StatusCallingCancelledCallback, To: StatusCancelled, Actor: ActorRecovery},
+	{From: StatusCallingInvalidCallback, To: StatusInvalid, Actor: ActorRecovery},
+	{From: StatusCallingBouncedCallback, To: StatusBounced, Actor: ActorRecovery},
+	{From: StatusCallingSendUncertainCallback, To: StatusSendUncertain, Actor: ActorRecovery},
+	{From: StatusCallingSentCallback, To: StatusSentCallbackFailed, Actor: ActorRecovery},
+	{From: StatusCallingFailedCallback, To: StatusFailedCallbackFailed, Actor: ActorRecovery},
+	{From: StatusCallingCancelledCallback, To: StatusCancelledCallbackFailed, Actor: ActorRecovery},
+	{From: StatusCallingInvalidCallback, To: StatusInvalidCallbackFailed, Actor: ActorRecovery},
+	{From: StatusCallingBouncedCallback, To: StatusBouncedCallbackFailed, Actor: ActorRecovery},
+	{From: StatusCallingSendUncertainCallback, To: StatusSendUncertainCallbackFailed, Actor: ActorRecovery},
+	{From: StatusSentCallbackFailed, To: StatusBounced, Actor: ActorRecovery},
+
+	{From: StatusFailed, To: StatusReady, Actor: ActorOperator, Action: ActionRequeue},
+	{From: StatusInvalid, To: StatusAccepted, Actor: ActorOperator, Action: ActionRequeue},
+	{From: StatusInvalidAcknowledged, To: StatusAccepted, Actor: ActorOperator, Action: ActionRequeue},
+	{From: StatusQuarantined, To: StatusReady, Actor: ActorOperator, Action: ActionRequeue},
+	{From: StatusSentCallbackFailed, To: StatusSent, Actor: ActorOperator, Action: ActionRequeue},
+	{From: StatusFailedCallbackFailed, To: StatusFailed, Actor: ActorOperator, Action: ActionRequeue},
+	{From: StatusCancelledCallbackFailed, To: StatusCancelled, Actor: ActorOperator, Action: ActionRequeue},
+	{From: StatusInvalidCallbackFailed, To: StatusInvalid, Actor: ActorOperator, Action: ActionRequeue},
+	{From: StatusBouncedCallbackFailed, To: StatusBounced, Actor: ActorOperator, Action: ActionRequeue},
+	{From: StatusSendUncertainCallbackFailed, To: StatusSendUncertain, Actor: ActorOperator, Action: ActionRequeue},
+	{From: StatusAccepted, To: StatusCancelled, Actor: ActorOperator, Action: ActionCancel},
+	{From: StatusIntaking, To: StatusCancelled, Actor: ActorOperator, Action: ActionCancel},
+	{From: StatusInvalid, To: StatusCancelled, Actor: ActorOperator, Action: ActionCancel},
+	{From: StatusReady, To: StatusCancelled, Actor: ActorOperator, Action: ActionCancel},
+	{From: StatusProcessing, To: StatusCancelled, Actor: ActorOperator, Action: ActionCancel},
+	{From: StatusQuarantined, To: StatusCancelled, Actor: ActorOperator, Action: ActionCancel},
+	{From: StatusCallingSentCallback, To: StatusSentAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusCallingFailedCallback, To: StatusFailedAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusCallingCancelledCallback, To: StatusCancelledAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusCallingInvalidCallback, To: StatusInvalidAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusCallingBouncedCallback, To: StatusBouncedAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusSentCallbackFailed, To: StatusSentAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusFailedCallbackFailed, To: StatusFailedAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusCancelledCallbackFailed, To: StatusCancelledAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusInvalidCallbackFailed, To: StatusInvalidAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusBouncedCallbackFailed, To: StatusBouncedAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusCallingSendUncertainCallback, To: StatusSendUncertainAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusSendUncertainCallbackFailed, To: StatusSendUncertainAcknowledged, Actor: ActorOperator, Action: ActionAcknowledge},
+	{From: StatusQuarantined, To: StatusSent, Actor: ActorOperator, Action: ActionMarkSent},
+	{From: StatusQuarantined, To: StatusFailed, Actor: ActorOperator, Action: ActionFail},
+}
+
+// Transitions returns a copy of the state machine definition.
+func Transitions() []Transition {
+	return append([]Transition{}, transitions...)
+}
+
+// isAllowed reports whether from -> to is defined for one of the given actors.
+func isAllowed(from string, to string, actors ...string) bool {
+	for _, t := range transitions {
+		if t.From == from && t.To == to && slices.Contains(actors, t.Actor) {
+			return true
+		}
+	}
+	return false
+}
+
+// getExpectedFromStatus returns the expected previous status for a given target status.
+// Only pipeline transitions are considered, each of them reaches its target from a single status.
+func getExpectedFromStatus(toStatus string) string {
+	for _, t := range transitions {
+		if t.Actor == ActorPipeline && t.To == toStatus {
+			return t.From
+		}
+	}
+	return ""
+}
+
+// isCallbackAcknowledged reports whether status is reached by a pipeline when a callback is acknowledged.
+func isCallbackAcknowledged(status string) bool {
+	for _, t := range transitions {
+		if t.Actor == ActorPipeline && t.To == status && strings.HasPrefix(t.From, "CALLING-") {
+			return true
+		}
+	}
+	return false
+}
+
+// isCallbackParked reports whether status is reached by a recovery when a callback is given up.
+func isCallbackParked(status string) bool {
+	for _, t := range transitions {
+		if t.Actor == ActorRecovery && t.To == status && strings.HasPrefix(t.From, "CALLING-") && strings.HasSuffix(t.To, "-CALLBACK-FAILED") {
+			return true
+		}
+	}
+	return false
+}
+
+func isOperatorAction(action string) bool {
+	for _, t := range transitions {
+		if t.Actor == ActorOperator && t.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// operatorTransition returns the target status of an operator action applied to an email in the from status.
+func operatorTransition(action string, from string) (string, error) {
+	for _, t := range transitions {
+		if t.Actor == ActorOperator && t.Action == action && t.From == from {
+			return t.To, nil
+		}
+	}
+	return "", ErrTransitionNotAllowed
+}
+
+// Mermaid renders the state machine as a Mermaid state diagram.
+func Mermaid() string {
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	fmt.Fprintf(&b, "    [*] --> %s\n", mermaidState(StatusAccepted))
+	for _, t := range transitions {
+		fmt.Fprintf(&b, "    %s --> %s: %s\n", mermaidState(t.From), mermaidState(t.To), transitionLabel(t))
+	}
+	return b.String()
+}
+
+// Graphviz renders the state machine as a Graphviz digraph.
+func Graphviz() string {
+	var b strings.Builder
+	b.WriteString("digraph outbox {\n")
+	b.WriteString("    rankdir=LR;\n")
+	for _, t := range transitions {
+		style := ""
+		if t.Actor != ActorPipeline {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "    %q -> %q [label=%q%s];\n", t.From, t.To, transitionLabel(t), style)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// mermaidState replaces the dashes that Mermaid does not accept in state identifiers.
+func mermaidState(status string) string {
+	return strings.ReplaceAll(status, "-", "_")
+}
+
+func transitionLabel(t Transition) string {
+	if t.Action != "" {
+		return t.Actor + " " + t.Action
+	}
+	return t.Actor
+}
@@ -0,0 +1,89 @@
+//go:build unit
+
+package outbox
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransitions_PipelineTargetsShouldHaveSingleSource(t *testing.T) {
+	t.Parallel()
+
+	sources := map[string]string{}
+	for _, tr := range Transitions() {
+		if tr.Actor != ActorPipeline {
+			continue
+		}
+		from, found := sources[tr.To]
+		assert.False(t, found, "%s is reached by pipeline transitions from both %s and %s", tr.To, from, tr.From)
+		sources[tr.To] = tr.From
+	}
+}
+
+func TestTransitions_OperatorTransitionsShouldHaveAction(t *testing.T) {
+	t.Parallel()
+
+	for _, tr := range Transitions() {
+		assert.Equal(t, tr.Actor == ActorOperator, tr.Action != "", "%s -> %s", tr.From, tr.To)
+	}
+}
+
+func TestTransitions_MermaidShouldMatchPipelineDocs(t *testing.T) {
+	t.Parallel()
+
+	doc, err := os.ReadFile("../../docs/pipeline.md")
+	require.NoError(t, err)
+
+	_, block, found := strings.Cut(string(doc), "```mermaid\n")
+	require.True(t, found, "docs/pipeline.md has no mermaid diagram")
+	block, _, found = strings.Cut(block, "```")
+	require.True(t, found)
+
+	assert.Equal(t, Mermaid(), block, "docs/pipeline.md is out of date, regenerate it with go run ./cmd/statemachine")
+}
+
+func TestGraphviz_ShouldRenderEveryTransition(t *testing.T) {
+	t.Parallel()
+
+	dot := Graphviz()
+
+	assert.Equal(t, len(Transitions()), strings.Count(dot, " -> "))
+	assert.Contains(t, dot, `"PROCESSING" -> "QUARANTINED" [label="recovery", style=dashed];`)
+}
+
+func TestUpdate_WhenNoPipelineTransitionReachesStatus_ShouldReturnTransitionNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.Update(context.TODO(), "test-id", StatusQuarantined, "")
+
+	assert.ErrorIs(t, err, ErrTransitionNotAllowed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateFrom_WhenTransitionUnknown_ShouldReturnTransitionNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sut := NewOutboxWithDB(db)
+
+	err = sut.UpdateFrom(context.TODO(), "test-id", StatusSentAcknowledged, StatusReady, "")
+
+	assert.ErrorIs(t, err, ErrTransitionNotAllowed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
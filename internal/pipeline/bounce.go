@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"mailculator-processor/internal/bounce"
+	"mailculator-processor/internal/failure"
+	"mailculator-processor/internal/metrics"
+	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/tracing"
+)
+
+var errBounceNotCorrelated = errors.New("neither a VERP recipient nor a Message-ID of this processor")
+
+type BounceConfig struct {
+	// BatchSize is the maximum number of messages read from the mailbox on each run, default 25
+	BatchSize int
+	// VERPAddress is the base of the VERP envelope senders: bounces@example.com for bounces+<email id>@example.com.
+	// Empty correlates the notifications by Message-ID only
+	VERPAddress string
+}
+
+// WithDefaults returns the config with zero values replaced by the defaults.
+func (c BounceConfig) WithDefaults() BounceConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	return c
+}
+
+type BouncePipeline struct {
+	outbox outboxService
+	source bounce.Source
+	cfg    BounceConfig
+	name   string
+	logger *slog.Logger
+}
+
+// NewBouncePipeline reads the delivery status notifications from source and moves the emails they report
+// as failed from SENT-ACKNOWLEDGED, or SENT-CALLBACK-FAILED, to BOUNCED, where the bounced callback picks them up.
+func NewBouncePipeline(ob outboxService, source bounce.Source, cfg BounceConfig) *BouncePipeline {
+	return &BouncePipeline{
+		outbox: ob,
+		source: source,
+		cfg:    cfg.WithDefaults(),
+		name:   "bounce",
+		logger: slog.With("pipe", "bounce"),
+	}
+}
+
+// Process returns the number of messages removed from the mailbox: the notifications kept for a later run
+// do not count, so they cannot make the pipeline poll again immediately.
+func (p *BouncePipeline) Process(ctx context.Context) int {
+	removed, err := p.source.Fetch(ctx, p.cfg.BatchSize, func(raw []byte) bool {
+		return p.handle(ctx, raw)
+	})
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("error while reading the bounce mailbox: %v", err))
+	}
+	return removed
+}
+
+// handle records the bounce reported by a message and returns whether the message can be removed from the mailbox.
+// Messages that are not a bounce of a known email are discarded.
+func (p *BouncePipeline) handle(ctx context.Context, raw []byte) bool {
+	metrics.PipelineProcessed.Inc(p.name)
+
+	report, err := bounce.Parse(raw)
+	if err != nil {
+		p.logger.Warn(fmt.Sprintf("discarding message, error: %v", err))
+		return true
+	}
+
+	recipient, failed := report.Failed()
+	if !failed {
+		p.logger.Info("discarding delivery status notification without failed recipients")
+		return true
+	}
+
+	id, err := p.correlate(ctx, report)
+	if errors.Is(err, errBounceNotCorrelated) || errors.Is(err, outbox.ErrNotFound) {
+		p.logger.Warn(fmt.Sprintf("discarding bounce for %s, error: %v", recipient.FinalRecipient, err))
+		return true
+	}
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("failed to correlate bounce for %s, error: %v", recipient.FinalRecipient, err))
+		metrics.PipelineFailed.Inc(p.name)
+		return false
+	}
+
+	subLogger := p.logger.With("outbox", id)
+	ctx, span := tracing.Start(ctx, "bounce.process", attribute.String("email.id", id))
+	defer span.End()
+
+	reason := recipient.Reason()
+	err = p.outbox.Fail(ctx, id, outbox.StatusBounced, reason)
+	if errors.Is(err, outbox.ErrLockNotAcquired) {
+		metrics.PipelineLockConflicts.Inc(p.name)
+		return p.resolveConflict(ctx, subLogger, id, reason)
+	}
+	if err != nil {
+		subLogger.Error(fmt.Sprintf("error updating status to %v, error: %v", outbox.StatusBounced, err))
+		metrics.PipelineFailed.Inc(p.name)
+		return false
+	}
+
+	subLogger.Info(fmt.Sprintf("recorded %v: %v", reason.Category, reason.Message))
+	metrics.PipelineSucceeded.Inc(p.name)
+	return true
+}
+
+// correlate returns the id of the email a notification is about: the VERP address it was returned to is
+// the return path stored when the email was sent, the Message-ID of the original message carries its payload id.
+func (p *BouncePipeline) correlate(ctx context.Context, report bounce.Report) (string, error) {
+	if p.cfg.VERPAddress != "" {
+		for _, address := range report.EnvelopeRecipients {
+			if _, found := bounce.ParseVERP(p.cfg.VERPAddress, address); !found {
+				continue
+			}
+			id, err := p.outbox.FindByReturnPath(ctx, address)
+			if errors.Is(err, outbox.ErrNotFound) {
+				continue
+			}
+			return id, err
+		}
+	}
+
+	if key := messageIDKey(report.MessageID); key != "" {
+		return p.outbox.FindBySendMarker(ctx, key)
+	}
+	return "", errBounceNotCorrelated
+}
+
+// resolveConflict decides about a bounce that could not be recorded because the email is not SENT-ACKNOWLEDGED.
+// A bounce can arrive before the sent callback is acknowledged: it is kept and recorded on a later run.
+// A bounce of an email whose sent callback was given up is recorded from SENT-CALLBACK-FAILED.
+// Any other status means a duplicate notification or an email that was never sent, and the bounce is discarded.
+func (p *BouncePipeline) resolveConflict(ctx context.Context, logger *slog.Logger, id string, reason failure.Reason) bool {
+	current, err := p.outbox.Get(ctx, id)
+	if errors.Is(err, outbox.ErrNotFound) {
+		logger.Warn("discarding bounce of an unknown email")
+		return true
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("failed to read email status, error: %v", err))
+		return false
+	}
+
+	switch current.Status {
+	case outbox.StatusSent, outbox.StatusCallingSentCallback:
+		logger.Info(fmt.Sprintf("keeping bounce until the sent callback is acknowledged, status: %v", current.Status))
+		return false
+	case outbox.StatusSentCallbackFailed:
+		err := p.outbox.FailFrom(ctx, id, current.Status, outbox.StatusBounced, reason)
+		if errors.Is(err, outbox.ErrLockNotAcquired) {
+			logger.Info("keeping bounce, the email changed status while recording it")
+			return false
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("error updating status to %v, error: %v", outbox.StatusBounced, err))
+			metrics.PipelineFailed.Inc(p.name)
+			return false
+		}
+		logger.Info(fmt.Sprintf("recorded %v: %v", reason.Category, reason.Message))
+		metrics.PipelineSucceeded.Inc(p.name)
+		return true
+	default:
+		logger.Warn(fmt.Sprintf("discarding bounce of email in status %v", current.Status))
+		return true
+	}
+}
+
+// messageIDKey returns the local part of a Message-ID, the payload id for the emails sent by this processor.
+func messageIDKey(messageID string) string {
+	local, _, found := strings.Cut(strings.Trim(strings.TrimSpace(messageID), "<>"), "@")
+	if !found {
+		return ""
+	}
+	return local
+}
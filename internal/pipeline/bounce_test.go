@@ -0,0 +1,205 @@
+//go:build unit
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mailculator-processor/internal/bounce"
+	"mailculator-processor/internal/failure"
+	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/testutils/mocks"
+)
+
+const (
+	verpEmailId    = "4b1c7a52-0d3e-4f7a-9a63-2f0f6c1b9e11"
+	verpReturnPath = "bounces+" + verpEmailId + "@mailculator.example"
+	bouncedPayload = "550e8400-e29b-41d4-a716-446655440000"
+)
+
+// newBounceMaildir delivers the DSN fixtures of the bounce package to a new maildir.
+func newBounceMaildir(t *testing.T, fixtures ...string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for _, dir := range []string{"new", "cur", "tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(root, dir), 0o755))
+	}
+	for i, name := range fixtures {
+		raw, err := os.ReadFile(filepath.Join("../bounce/testdata", name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(root, "new", fmt.Sprintf("17606952%02d.M1P1.mx", i)), raw, 0o644))
+	}
+	return root
+}
+
+func maildirSize(t *testing.T, root string) int {
+	t.Helper()
+
+	entries, err := os.ReadDir(filepath.Join(root, "new"))
+	require.NoError(t, err)
+	return len(entries)
+}
+
+func TestBounceRecordsHardBounceCorrelatedByVERP(t *testing.T) {
+	root := newBounceMaildir(t, "hard-bounce.eml")
+	outboxServiceMock := mocks.NewOutboxMock(mocks.ReturnPath(verpReturnPath, verpEmailId))
+	pipe := NewBouncePipeline(outboxServiceMock, bounce.NewMaildir(bounce.MaildirConfig{Path: root}),
+		BounceConfig{BatchSize: 10, VERPAddress: "bounces@mailculator.example"})
+	_, pipe.logger = mocks.NewLoggerMock()
+
+	handled := pipe.Process(context.TODO())
+
+	assert.Equal(t, 1, handled)
+	assert.Equal(t, outbox.StatusBounced, outboxServiceMock.LastStatuses()[verpEmailId])
+	assert.Equal(t, &failure.Reason{
+		Category:     failure.CategoryBounceHard,
+		SMTPCode:     550,
+		EnhancedCode: "5.1.1",
+		Message:      "550 5.1.1 <nobody@example.com>: Recipient address rejected: User unknown in virtual mailbox table",
+	}, outboxServiceMock.Failure())
+	assert.Equal(t, 0, maildirSize(t, root))
+}
+
+func TestBounceCorrelatesByMessageID(t *testing.T) {
+	root := newBounceMaildir(t, "soft-bounce.eml")
+	outboxServiceMock := mocks.NewOutboxMock(mocks.SendMarker(bouncedPayload, "email-1"))
+	pipe := NewBouncePipeline(outboxServiceMock, bounce.NewMaildir(bounce.MaildirConfig{Path: root}), BounceConfig{BatchSize: 10})
+	_, pipe.logger = mocks.NewLoggerMock()
+
+	pipe.Process(context.TODO())
+
+	assert.Equal(t, outbox.StatusBounced, outboxServiceMock.LastStatuses()["email-1"])
+	assert.Equal(t, failure.CategoryBounceSoft, outboxServiceMock.Failure().Category)
+	assert.Equal(t, 0, maildirSize(t, root))
+}
+
+func TestBounceFallsBackToMessageIDWhenVERPAddressIsUnknown(t *testing.T) {
+	root := newBounceMaildir(t, "hard-bounce.eml")
+	outboxServiceMock := mocks.NewOutboxMock(mocks.SendMarker(bouncedPayload, "email-1"))
+	pipe := NewBouncePipeline(outboxServiceMock, bounce.NewMaildir(bounce.MaildirConfig{Path: root}),
+		BounceConfig{BatchSize: 10, VERPAddress: "bounces@mailculator.example"})
+	_, pipe.logger = mocks.NewLoggerMock()
+
+	pipe.Process(context.TODO())
+
+	assert.Equal(t, outbox.StatusBounced, outboxServiceMock.LastStatuses()["email-1"])
+	assert.NotContains(t, outboxServiceMock.LastStatuses(), verpEmailId)
+}
+
+func TestBounceKeepsNotificationUntilSentCallbackIsAcknowledged(t *testing.T) {
+	root := newBounceMaildir(t, "hard-bounce.eml")
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: verpEmailId, Status: outbox.StatusCallingSentCallback}),
+		mocks.ReturnPath(verpReturnPath, verpEmailId),
+		mocks.UpdateMethodError(outbox.ErrLockNotAcquired),
+	)
+	pipe := NewBouncePipeline(outboxServiceMock, bounce.NewMaildir(bounce.MaildirConfig{Path: root}),
+		BounceConfig{BatchSize: 10, VERPAddress: "bounces@mailculator.example"})
+	buf, logger := mocks.NewLoggerMock()
+	pipe.logger = logger
+
+	handled := pipe.Process(context.TODO())
+
+	assert.Equal(t, 0, handled)
+	assert.Equal(t, 1, maildirSize(t, root))
+	assert.Contains(t, buf.String(), "keeping bounce until the sent callback is acknowledged")
+}
+
+func TestBounceRecordsBounceOfEmailWithGivenUpSentCallback(t *testing.T) {
+	root := newBounceMaildir(t, "hard-bounce.eml")
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: verpEmailId, Status: outbox.StatusSentCallbackFailed}),
+		mocks.ReturnPath(verpReturnPath, verpEmailId),
+		mocks.UpdateMethodError(outbox.ErrLockNotAcquired),
+	)
+	pipe := NewBouncePipeline(outboxServiceMock, bounce.NewMaildir(bounce.MaildirConfig{Path: root}),
+		BounceConfig{BatchSize: 10, VERPAddress: "bounces@mailculator.example"})
+	_, pipe.logger = mocks.NewLoggerMock()
+
+	handled := pipe.Process(context.TODO())
+
+	assert.Equal(t, 1, handled)
+	assert.Equal(t, "failFrom", outboxServiceMock.LastMethod())
+	assert.Equal(t, outbox.StatusBounced, outboxServiceMock.LastStatuses()[verpEmailId])
+	assert.Equal(t, failure.CategoryBounceHard, outboxServiceMock.Failure().Category)
+	assert.Equal(t, 0, maildirSize(t, root))
+}
+
+func TestBounceDiscardsDuplicateBounce(t *testing.T) {
+	root := newBounceMaildir(t, "hard-bounce.eml")
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: verpEmailId, Status: outbox.StatusBouncedAcknowledged}),
+		mocks.ReturnPath(verpReturnPath, verpEmailId),
+		mocks.UpdateMethodError(outbox.ErrLockNotAcquired),
+	)
+	pipe := NewBouncePipeline(outboxServiceMock, bounce.NewMaildir(bounce.MaildirConfig{Path: root}),
+		BounceConfig{BatchSize: 10, VERPAddress: "bounces@mailculator.example"})
+	_, pipe.logger = mocks.NewLoggerMock()
+
+	handled := pipe.Process(context.TODO())
+
+	assert.Equal(t, 1, handled)
+	assert.Equal(t, 0, maildirSize(t, root))
+}
+
+func TestBounceDiscardsLateNotificationOfEmailPastSentAcknowledged(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   outbox.Email
+		message string
+	}{
+		{"already bounced", outbox.Email{Id: verpEmailId, Status: outbox.StatusBounced}, "discarding bounce of email in status BOUNCED"},
+		{"bounced callback in progress", outbox.Email{Id: verpEmailId, Status: outbox.StatusCallingBouncedCallback}, "discarding bounce of email in status CALLING-BOUNCED-CALLBACK"},
+		{"removed by retention", outbox.Email{Id: "another-email"}, "discarding bounce of an unknown email"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := newBounceMaildir(t, "hard-bounce.eml")
+			outboxServiceMock := mocks.NewOutboxMock(
+				mocks.Email(tt.email),
+				mocks.ReturnPath(verpReturnPath, verpEmailId),
+				mocks.UpdateMethodError(outbox.ErrLockNotAcquired),
+			)
+			pipe := NewBouncePipeline(outboxServiceMock, bounce.NewMaildir(bounce.MaildirConfig{Path: root}),
+				BounceConfig{BatchSize: 10, VERPAddress: "bounces@mailculator.example"})
+			buf, logger := mocks.NewLoggerMock()
+			pipe.logger = logger
+
+			handled := pipe.Process(context.TODO())
+
+			assert.Equal(t, 1, handled)
+			assert.NotContains(t, outboxServiceMock.LastStatuses(), verpEmailId)
+			assert.Contains(t, buf.String(), tt.message)
+			assert.Equal(t, 0, maildirSize(t, root))
+		})
+	}
+}
+
+func TestBounceDiscardsMessagesWithoutBounceOfKnownEmail(t *testing.T) {
+	root := newBounceMaildir(t, "autoreply.eml", "delayed.eml", "hard-bounce.eml")
+	outboxServiceMock := mocks.NewOutboxMock()
+	pipe := NewBouncePipeline(outboxServiceMock, bounce.NewMaildir(bounce.MaildirConfig{Path: root}), BounceConfig{BatchSize: 10})
+	_, pipe.logger = mocks.NewLoggerMock()
+
+	handled := pipe.Process(context.TODO())
+
+	assert.Equal(t, 3, handled)
+	assert.Empty(t, outboxServiceMock.LastStatuses())
+	assert.Nil(t, outboxServiceMock.Failure())
+	assert.Equal(t, 0, maildirSize(t, root))
+}
+
+func TestMessageIDKey(t *testing.T) {
+	assert.Equal(t, bouncedPayload, messageIDKey("<"+bouncedPayload+"@mailculator.example>"))
+	assert.Equal(t, "", messageIDKey(""))
+	assert.Equal(t, "", messageIDKey("<no-domain>"))
+}
@@ -1,176 +1,295 @@
 package pipeline
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+
+	"mailculator-processor/internal/callbacksink"
+	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/metrics"
 	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/tracing"
+	"mailculator-processor/pkg/callbacksig"
 )
 
 type CallbackConfig struct {
-	MaxRetries    int
+	// MaxRetries is the number of attempts before the email is parked in the <outcome>-CALLBACK-FAILED status
+	MaxRetries int
+	// RetryInterval is the backoff before the first retry, doubled at each further attempt
 	RetryInterval time.Duration
-	Url           string
+	// MaxRetryInterval caps the backoff and the Retry-After asked by the receiver
+	MaxRetryInterval time.Duration
+	Url              string
+	// AllowedHosts are the hosts a payload callback_url may point to
+	AllowedHosts []string
+	// SigningSecrets sign every request with HMAC-SHA256, one signature per secret; empty disables signing
+	SigningSecrets []string
+	// Template renders the request body and headers, nil means the built-in preset
+	Template *CallbackTemplate
+	// Batch groups several emails in one request, disabled by default
+	Batch CallbackBatchConfig
+	// Sink delivers the rendered callbacks, nil means HTTP to Url or the payload callback_url
+	Sink callbacksink.Sink
 }
 
-const defaultCallbackTimeout = 10 * time.Second
-
 type CallbackPipeline struct {
 	outbox             outboxService
 	cfg                CallbackConfig
+	pool               PoolConfig
+	sink               callbacksink.Sink
+	name               string
 	logger             *slog.Logger
 	startStatus        string
 	processingStatus   string
 	acknowledgedStatus string
+	failedStatus       string
+	template           *CallbackTemplate
+	signer             *callbacksig.Signer
 }
 
-func (p *CallbackPipeline) Process(ctx context.Context) {
-	callbackList, err := p.outbox.Query(ctx, p.startStatus, 25)
+// Process makes one callback attempt per email, or per batch of emails when batching is enabled.
+// A 2xx response acknowledges the email; network errors, 409, 429 and 5xx responses move it back to its
+// start status with a backoff, persisted in the outbox, until MaxRetries attempts are made.
+// Any other response, or the last failed attempt, parks it in the callback failed status of its outcome.
+func (p *CallbackPipeline) Process(ctx context.Context) int {
+	pool := p.pool.WithDefaults()
+	callbackList, err := p.outbox.Query(ctx, p.startStatus, pool.BatchSize)
 	if err != nil {
 		p.logger.Error(fmt.Sprintf("error while querying emails to process: %v", err))
+		return 0
+	}
+
+	if p.cfg.Batch.Enabled() {
+		return p.processBatches(ctx, callbackList, pool.Workers)
+	}
+
+	forEachEmail(ctx, callbackList, pool.Workers, func(email outbox.Email) {
+		p.logger.Info(fmt.Sprintf("processing email %v", email.Id))
+		subLogger := p.logger.With("email", email.Id)
+		metrics.PipelineProcessed.Inc(p.name)
+
+		ctx, span := tracing.Start(ctx, "callback.process", attribute.String("email.id", email.Id))
+		defer span.End()
+
+		if err := p.outbox.Update(ctx, email.Id, p.processingStatus, email.Reason); err != nil {
+			subLogger.Warn(fmt.Sprintf("failed to acquire processing lock, error: %v", err))
+			metrics.PipelineLockConflicts.Inc(p.name)
+			return
+		}
+
+		data := p.callbackData(ctx, email)
+		body, headers, err := p.template.Render(data)
+		if err != nil {
+			subLogger.Error(fmt.Sprintf("error while rendering callback request: %v", err))
+			metrics.PipelineFailed.Inc(p.name)
+			p.park(context.WithoutCancel(ctx), subLogger, email.Id, err.Error())
+			return
+		}
+
+		url := p.url(data)
+		resp, err := p.send(ctx, url, email.Id, body, headers, email.CallbackAttempts+1)
+		p.settle(ctx, subLogger, email, url, resp, err)
+	})
+
+	return len(callbackList)
+}
+
+// settle moves a claimed email on after its callback attempt: acknowledged for a nil error, otherwise back to
+// its start status for a retry or parked in its callback failed status.
+func (p *CallbackPipeline) settle(ctx context.Context, logger *slog.Logger, e outbox.Email, url string, resp callbacksink.Receipt, err error) {
+	ctx = context.WithoutCancel(ctx)
+
+	if err == nil {
+		logger.Info("callback successfully processed")
+		metrics.PipelineSucceeded.Inc(p.name)
+		if err := p.outbox.Update(ctx, e.Id, p.acknowledgedStatus, e.Reason); err != nil {
+			logger.Error(fmt.Sprintf("error while updating status after callback, error: %v", err))
+		}
 		return
 	}
 
-	var wg sync.WaitGroup
-
-	for _, e := range callbackList {
-		wg.Add(1)
-		go func(email outbox.Email) {
-			defer wg.Done()
-
-			p.logger.Info(fmt.Sprintf("processing email %v", email.Id))
-			subLogger := p.logger.With("email", email.Id)
-
-			if err = p.outbox.Update(ctx, email.Id, p.processingStatus, email.Reason); err != nil {
-				subLogger.Warn(fmt.Sprintf("failed to acquire processing lock, error: %v", err))
-				return
-			}
-
-			var statusCode string
-			var reason string
-
-			if p.startStatus == outbox.StatusSent {
-				statusCode = "TRAVELING"
-				reason = "Consegnato al server di posta"
-			} else {
-				statusCode = "DISPATCH-ERROR"
-				reason = email.Reason
-			}
-
-			payload := map[string]any{
-				"code":        statusCode,
-				"reached_at":  email.UpdatedAt,
-				"message_ids": []string{email.Id},
-				"reason":      reason,
-			}
-
-			jsonBody, errJson := json.Marshal(payload)
-			if errJson != nil {
-				subLogger.Error(fmt.Sprintf("Error during data conversion to JSON: %v", errJson))
-				return
-			}
-
-			// TODO this could be clearer
-			resp := &http.Response{StatusCode: http.StatusConflict}
-			client := &http.Client{Timeout: defaultCallbackTimeout}
-
-			attempt := 0
-			for attempt < p.cfg.MaxRetries && resp.StatusCode == http.StatusConflict {
-				bodyReader := bytes.NewReader(jsonBody)
-				req, errReq := http.NewRequest(http.MethodPost, p.cfg.Url, bodyReader)
-				if errReq != nil {
-					subLogger.Error(fmt.Sprintf("Error during request creation: %v", errReq))
-					return
-				}
-
-				// TODO remove non-agnostic headers
-				req.Header.Set("Content-Type", "application/json")
-				req.Header.Set("X-MTRAX-SOURCE", "MULTIDIALOGO")
-
-				resp, err = client.Do(req)
-				if err != nil {
-					subLogger.Error(fmt.Sprintf("Error in the request: %v", err))
-					return
-				}
-
-				if resp.StatusCode == http.StatusConflict {
-					attempt++
-					retryMsg := ""
-					// TODO this could be clearer
-					var retryInterval time.Duration = 0
-
-					if attempt < p.cfg.MaxRetries {
-						retryMsg = fmt.Sprintf(" Try to call again %s in %d seconds.", p.cfg.Url, p.cfg.RetryInterval)
-						retryInterval = p.cfg.RetryInterval * time.Second
-					}
-
-					subLogger.Warn(fmt.Sprintf(
-						"Response status code is %d.%s Attempt %d/%d",
-						resp.StatusCode, retryMsg, attempt, p.cfg.MaxRetries,
-					))
-
-					if attempt < p.cfg.MaxRetries {
-						if resp.Body != nil {
-							_ = resp.Body.Close()
-						}
-						time.Sleep(retryInterval)
-					}
-				}
-			}
-
-			if attempt == p.cfg.MaxRetries {
-				subLogger.Error(fmt.Sprintf("Max retries exceeded for the url %s", p.cfg.Url))
-			}
-
-			if resp.Body != nil {
-				defer resp.Body.Close()
-			}
-
-			if resp.StatusCode != http.StatusOK {
-				bodyBytes, err := io.ReadAll(resp.Body)
-				if err != nil {
-					subLogger.Error(fmt.Sprintf("error reading callback response body %v", err))
-				} else {
-					subLogger.Error(fmt.Sprintf("error on callback, status: %v, response: %v", resp.StatusCode, string(bodyBytes)))
-				}
-			} else {
-				subLogger.Info("callback successfully processed")
-			}
-
-			if err = p.outbox.Update(context.Background(), email.Id, p.acknowledgedStatus, email.Reason); err != nil {
-				subLogger.Error(fmt.Sprintf("error while updating status after callback, error: %v", err))
-			}
-		}(e)
-	}
-
-	wg.Wait()
-}
-
-func NewSentCallbackPipeline(ob outboxService, cfg CallbackConfig) *CallbackPipeline {
-	return &CallbackPipeline{
-		outbox:             ob,
-		cfg:                cfg,
-		logger:             slog.With("pipe", "sent-callback"),
-		startStatus:        outbox.StatusSent,
-		processingStatus:   outbox.StatusCallingSentCallback,
-		acknowledgedStatus: outbox.StatusSentAcknowledged,
+	attempt := e.CallbackAttempts + 1
+	if retryable(resp.StatusCode) && attempt < p.cfg.MaxRetries {
+		delay := p.retryDelay(attempt, resp.RetryAfter)
+		logger.Warn(fmt.Sprintf("callback to %s failed: %v. Attempt %d/%d, retrying in %v", url, err, attempt, p.cfg.MaxRetries, delay))
+		if err := p.outbox.ScheduleCallbackRetry(ctx, e.Id, p.processingStatus, p.startStatus, delay, err.Error()); err != nil {
+			logger.Error(fmt.Sprintf("error while scheduling callback retry, error: %v", err))
+		}
+		return
 	}
+
+	logger.Error(fmt.Sprintf("callback to %s failed: %v. Attempt %d/%d, giving up", url, err, attempt, p.cfg.MaxRetries))
+	metrics.PipelineFailed.Inc(p.name)
+	p.park(ctx, logger, e.Id, fmt.Sprintf("callback failed after %d attempts: %v", attempt, err))
 }
 
-func NewFailedCallbackPipeline(ob outboxService, cfg CallbackConfig) *CallbackPipeline {
+// send delivers a single callback through the sink. It returns a nil error only once the receiver acknowledged it.
+func (p *CallbackPipeline) send(ctx context.Context, url string, key string, body []byte, headers http.Header, attempt int) (_ callbacksink.Receipt, err error) {
+	reqCtx, span := tracing.Start(context.WithoutCancel(ctx), "callback.request", attribute.Int("callback.attempt", attempt))
+	defer func() { tracing.End(span, err) }()
+
+	msg := callbacksink.Message{Destination: url, Key: key, Body: body, Headers: headers.Clone()}
+	if p.signer != nil {
+		p.signer.Sign(msg.Headers, body, time.Now())
+	}
+	tracing.Inject(reqCtx, propagation.HeaderCarrier(msg.Headers))
+
+	requestStart := time.Now()
+	receipt, err := p.sink.Deliver(reqCtx, msg)
+	metrics.CallbackDuration.Observe(metrics.Since(requestStart), p.name)
+
+	switch {
+	case receipt.StatusCode != 0:
+		span.SetAttributes(attribute.Int("http.response.status_code", receipt.StatusCode))
+		metrics.CallbackResponses.Inc(p.name, strconv.Itoa(receipt.StatusCode))
+	case err != nil:
+		metrics.CallbackResponses.Inc(p.name, "error")
+	default:
+		metrics.CallbackResponses.Inc(p.name, "ack")
+	}
+
+	return receipt, err
+}
+
+func (p *CallbackPipeline) park(ctx context.Context, logger *slog.Logger, id string, reason string) {
+	if err := p.outbox.UpdateFrom(ctx, id, p.processingStatus, p.failedStatus, reason); err != nil {
+		logger.Error(fmt.Sprintf("error updating status to %v, error: %v", p.failedStatus, err))
+	}
+}
+
+// retryable reports whether a failed attempt may succeed later: no response at all, 409, 429 or 5xx.
+func retryable(statusCode int) bool {
+	return statusCode == 0 ||
+		statusCode == http.StatusConflict ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= http.StatusInternalServerError
+}
+
+// retryDelay is the Retry-After of the receiver if any, otherwise an exponential backoff with jitter:
+// a random duration between half and all of RetryInterval doubled attempt-1 times. Both are capped at MaxRetryInterval.
+func (p *CallbackPipeline) retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	delay := retryAfter
+	if delay <= 0 {
+		backoff := p.cfg.RetryInterval << min(attempt-1, 20)
+		if backoff <= 0 || (p.cfg.MaxRetryInterval > 0 && backoff > p.cfg.MaxRetryInterval) {
+			backoff = p.cfg.MaxRetryInterval
+		}
+		delay = backoff/2 + rand.N(backoff/2+1)
+	}
+
+	if p.cfg.MaxRetryInterval > 0 && delay > p.cfg.MaxRetryInterval {
+		delay = p.cfg.MaxRetryInterval
+	}
+	return delay
+}
+
+// callbackData collects what the templates can access. The payload is best effort:
+// the callback is still sent when the file is gone or no longer valid, as for every INVALID email.
+func (p *CallbackPipeline) callbackData(ctx context.Context, e outbox.Email) CallbackData {
+	data := CallbackData{
+		Id:        e.Id,
+		Ids:       []string{e.Id},
+		Status:    p.startStatus,
+		Reason:    e.Reason,
+		Failure:   e.Failure,
+		ReachedAt: e.UpdatedAt,
+		CalledAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+
+	// validation errors describe why the email became INVALID, no other callback reports them
+	if p.startStatus == outbox.StatusInvalid && len(e.ValidationErrors) > 0 {
+		if err := json.Unmarshal(e.ValidationErrors, &data.ValidationErrors); err != nil {
+			p.logger.With("email", e.Id).Warn(fmt.Sprintf("ignoring unreadable validation errors: %v", err))
+		}
+	}
+
+	if e.PayloadFilePath != "" {
+		if payload, err := email.LoadPayload(ctx, e.PayloadFilePath); err == nil {
+			data.Payload = &payload
+			data.Metadata = payload.CallbackMetadata
+		}
+	}
+
+	return data
+}
+
+// url is the callback_url of the payload, or the configured one. The host is checked again here: an email
+// made INVALID at intake because of its callback_url must not be reported to that host.
+func (p *CallbackPipeline) url(data CallbackData) string {
+	if data.Payload == nil || data.Payload.CallbackURL == "" {
+		return p.cfg.Url
+	}
+	if err := data.Payload.CheckCallbackHost(p.cfg.AllowedHosts); err != nil {
+		p.logger.With("email", data.Id).Warn(fmt.Sprintf("using the configured callback url, %v", err))
+		return p.cfg.Url
+	}
+	return data.Payload.CallbackURL
+}
+
+func newCallbackPipeline(ob outboxService, cfg CallbackConfig, pool PoolConfig, name string, startStatus string, processingStatus string, acknowledgedStatus string, failedStatus string) *CallbackPipeline {
+	template := cfg.Template
+	if template == nil {
+		template = DefaultCallbackTemplate()
+	}
+
+	sink := cfg.Sink
+	if sink == nil {
+		sink = callbacksink.NewHTTP(callbacksink.DefaultTimeout)
+	}
+
+	var signer *callbacksig.Signer
+	if len(cfg.SigningSecrets) > 0 {
+		signer = callbacksig.NewSigner(cfg.SigningSecrets...)
+	}
+
 	return &CallbackPipeline{
 		outbox:             ob,
 		cfg:                cfg,
-		logger:             slog.With("pipe", "failed-callback"),
-		startStatus:        outbox.StatusFailed,
-		processingStatus:   outbox.StatusCallingFailedCallback,
-		acknowledgedStatus: outbox.StatusFailedAcknowledged,
+		pool:               pool,
+		name:               name,
+		logger:             slog.With("pipe", name),
+		startStatus:        startStatus,
+		processingStatus:   processingStatus,
+		acknowledgedStatus: acknowledgedStatus,
+		failedStatus:       failedStatus,
+		template:           template,
+		sink:               sink,
+		signer:             signer,
 	}
 }
+
+func NewSentCallbackPipeline(ob outboxService, cfg CallbackConfig, pool PoolConfig) *CallbackPipeline {
+	return newCallbackPipeline(ob, cfg, pool, "sent-callback", outbox.StatusSent, outbox.StatusCallingSentCallback, outbox.StatusSentAcknowledged, outbox.StatusSentCallbackFailed)
+}
+
+func NewFailedCallbackPipeline(ob outboxService, cfg CallbackConfig, pool PoolConfig) *CallbackPipeline {
+	return newCallbackPipeline(ob, cfg, pool, "failed-callback", outbox.StatusFailed, outbox.StatusCallingFailedCallback, outbox.StatusFailedAcknowledged, outbox.StatusFailedCallbackFailed)
+}
+
+func NewCancelledCallbackPipeline(ob outboxService, cfg CallbackConfig, pool PoolConfig) *CallbackPipeline {
+	return newCallbackPipeline(ob, cfg, pool, "cancelled-callback", outbox.StatusCancelled, outbox.StatusCallingCancelledCallback, outbox.StatusCancelledAcknowledged, outbox.StatusCancelledCallbackFailed)
+}
+
+// NewInvalidCallbackPipeline reports the payloads rejected at intake, with the fields that failed validation.
+func NewInvalidCallbackPipeline(ob outboxService, cfg CallbackConfig, pool PoolConfig) *CallbackPipeline {
+	return newCallbackPipeline(ob, cfg, pool, "invalid-callback", outbox.StatusInvalid, outbox.StatusCallingInvalidCallback, outbox.StatusInvalidAcknowledged, outbox.StatusInvalidCallbackFailed)
+}
+
+// NewSendUncertainCallbackPipeline reports the emails whose send outcome is unknown, moved to SEND-UNCERTAIN by the restore pipeline.
+func NewSendUncertainCallbackPipeline(ob outboxService, cfg CallbackConfig, pool PoolConfig) *CallbackPipeline {
+	return newCallbackPipeline(ob, cfg, pool, "send-uncertain-callback", outbox.StatusSendUncertain, outbox.StatusCallingSendUncertainCallback, outbox.StatusSendUncertainAcknowledged, outbox.StatusSendUncertainCallbackFailed)
+}
+
+// NewBouncedCallbackPipeline reports the bounces received after the send, with their diagnostic.
+func NewBouncedCallbackPipeline(ob outboxService, cfg CallbackConfig, pool PoolConfig) *CallbackPipeline {
+	return newCallbackPipeline(ob, cfg, pool, "bounced-callback", outbox.StatusBounced, outbox.StatusCallingBouncedCallback, outbox.StatusBouncedAcknowledged, outbox.StatusBouncedCallbackFailed)
+}
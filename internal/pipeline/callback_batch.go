@@ -0,0 +1,205 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"mailculator-processor/internal/callbacksink"
+	"mailculator-processor/internal/metrics"
+	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/tracing"
+)
+
+// CallbackBatchConfig groups the emails sharing the same destination and body in a single request.
+type CallbackBatchConfig struct {
+	// MaxSize is the most emails sent in one request, 0 or 1 disables batching
+	MaxSize int
+	// MaxWait is how long an incomplete batch is held back waiting for more emails, 0 sends it right away
+	MaxWait time.Duration
+}
+
+func (c CallbackBatchConfig) Enabled() bool {
+	return c.MaxSize > 1
+}
+
+// callbackBatchResponse is the optional body a receiver returns to acknowledge a batch email by email.
+// Emails it does not list are acknowledged with the batch.
+type callbackBatchResponse struct {
+	Results []callbackBatchResult `json:"results"`
+}
+
+type callbackBatchResult struct {
+	MessageId string `json:"message_id"`
+	Status    int    `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+type callbackBatchItem struct {
+	email outbox.Email
+	data  CallbackData
+}
+
+type callbackBatch struct {
+	url   string
+	items []callbackBatchItem
+}
+
+// processBatches sends one request per batch and settles its emails as a unit, or one by one when the
+// receiver returns per-email results. It returns the number of emails dispatched: the ones held back in an
+// incomplete batch do not count, so they cannot make the pipeline poll again immediately.
+func (p *CallbackPipeline) processBatches(ctx context.Context, emails []outbox.Email, workers int) int {
+	batches := p.batches(ctx, emails, time.Now())
+
+	dispatched := 0
+	for _, batch := range batches {
+		dispatched += len(batch.items)
+	}
+
+	forEach(ctx, batches, workers, func(batch callbackBatch) {
+		p.processBatch(ctx, batch)
+	})
+
+	return dispatched
+}
+
+// batches groups the emails by destination URL, reason, failure, metadata and validation errors, keeping the query order, and splits each
+// group in batches of at most MaxSize emails. An incomplete batch is held back while its oldest email has
+// waited less than MaxWait, a later run picks it up with the emails reached in the meantime.
+func (p *CallbackPipeline) batches(ctx context.Context, emails []outbox.Email, now time.Time) []callbackBatch {
+	var keys []string
+	groups := make(map[string]*callbackBatch)
+
+	for _, e := range emails {
+		data := p.callbackData(ctx, e)
+		url := p.url(data)
+		failureKey, _ := json.Marshal(data.Failure)
+		validationKey, _ := json.Marshal(data.ValidationErrors)
+		key := url + "\x00" + data.Reason + "\x00" + string(failureKey) + "\x00" + string(data.Metadata) + "\x00" + string(validationKey)
+
+		group, ok := groups[key]
+		if !ok {
+			group = &callbackBatch{url: url}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		group.items = append(group.items, callbackBatchItem{email: e, data: data})
+	}
+
+	var batches []callbackBatch
+	for _, key := range keys {
+		group := groups[key]
+		for start := 0; start < len(group.items); start += p.cfg.Batch.MaxSize {
+			items := group.items[start:min(start+p.cfg.Batch.MaxSize, len(group.items))]
+			if len(items) < p.cfg.Batch.MaxSize && !p.batchExpired(items, now) {
+				p.logger.Debug(fmt.Sprintf("holding back a batch of %d emails to %s", len(items), group.url))
+				continue
+			}
+			batches = append(batches, callbackBatch{url: group.url, items: items})
+		}
+	}
+
+	return batches
+}
+
+// batchExpired reports whether the oldest email of the batch has waited at least MaxWait.
+func (p *CallbackPipeline) batchExpired(items []callbackBatchItem, now time.Time) bool {
+	for _, item := range items {
+		reachedAt, err := time.Parse(time.RFC3339, item.email.UpdatedAt)
+		if err != nil || !reachedAt.Add(p.cfg.Batch.MaxWait).After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CallbackPipeline) processBatch(ctx context.Context, batch callbackBatch) {
+	ctx, span := tracing.Start(ctx, "callback.batch", attribute.Int("callback.batch.size", len(batch.items)))
+	defer span.End()
+
+	claimed := make([]callbackBatchItem, 0, len(batch.items))
+	for _, item := range batch.items {
+		p.logger.Info(fmt.Sprintf("processing email %v", item.email.Id))
+		metrics.PipelineProcessed.Inc(p.name)
+
+		if err := p.outbox.Update(ctx, item.email.Id, p.processingStatus, item.email.Reason); err != nil {
+			p.logger.With("email", item.email.Id).Warn(fmt.Sprintf("failed to acquire processing lock, error: %v", err))
+			metrics.PipelineLockConflicts.Inc(p.name)
+			continue
+		}
+		claimed = append(claimed, item)
+	}
+
+	if len(claimed) == 0 {
+		return
+	}
+
+	data := batchCallbackData(claimed)
+	body, headers, err := p.template.Render(data)
+	if err != nil {
+		for _, item := range claimed {
+			subLogger := p.logger.With("email", item.email.Id)
+			subLogger.Error(fmt.Sprintf("error while rendering callback request: %v", err))
+			metrics.PipelineFailed.Inc(p.name)
+			p.park(context.WithoutCancel(ctx), subLogger, item.email.Id, err.Error())
+		}
+		return
+	}
+
+	// the batch is retried as a unit, its first attempt counter stands for all of its emails
+	resp, err := p.send(ctx, batch.url, claimed[0].email.Id, body, headers, claimed[0].email.CallbackAttempts+1)
+
+	var results map[string]callbackBatchResult
+	if err == nil {
+		results = parseCallbackBatchResults(resp.Body)
+	}
+
+	for _, item := range claimed {
+		subLogger := p.logger.With("email", item.email.Id)
+		result, ok := results[item.email.Id]
+		if !ok || (result.Status >= 200 && result.Status < 300) {
+			p.settle(ctx, subLogger, item.email, batch.url, resp, err)
+			continue
+		}
+
+		itemResp := callbacksink.Receipt{StatusCode: result.Status}
+		p.settle(ctx, subLogger, item.email, batch.url, itemResp, fmt.Errorf("status %d, response: %s", result.Status, result.Error))
+	}
+}
+
+// batchCallbackData merges the data of the batch emails: they share reason, failure, metadata and validation errors by construction,
+// ReachedAt is the latest of them.
+func batchCallbackData(items []callbackBatchItem) CallbackData {
+	data := items[0].data
+	data.Payload = nil
+	data.Ids = make([]string, 0, len(items))
+	data.Emails = make([]CallbackData, 0, len(items))
+
+	for _, item := range items {
+		data.Ids = append(data.Ids, item.email.Id)
+		data.Emails = append(data.Emails, item.data)
+		if item.data.ReachedAt > data.ReachedAt {
+			data.ReachedAt = item.data.ReachedAt
+		}
+	}
+
+	return data
+}
+
+// parseCallbackBatchResults indexes the per-email results of a batch response by message id.
+// A body that is empty or not in the expected shape yields no results, acknowledging the whole batch.
+func parseCallbackBatchResults(body []byte) map[string]callbackBatchResult {
+	var response callbackBatchResponse
+	if len(body) == 0 || json.Unmarshal(body, &response) != nil {
+		return nil
+	}
+
+	results := make(map[string]callbackBatchResult, len(response.Results))
+	for _, result := range response.Results {
+		results[result.MessageId] = result
+	}
+	return results
+}
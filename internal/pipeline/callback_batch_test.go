@@ -0,0 +1,136 @@
+//go:build unit
+
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/testutils/mocks"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchEmails(reachedAt time.Time, ids ...string) []outbox.Email {
+	emails := make([]outbox.Email, 0, len(ids))
+	for _, id := range ids {
+		emails = append(emails, outbox.Email{Id: id, UpdatedAt: reachedAt.UTC().Format(time.RFC3339)})
+	}
+	return emails
+}
+
+func TestCallbackBatchesSendOneRequestPerBatch(t *testing.T) {
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Emails(batchEmails(time.Now().Add(-time.Hour), "1", "2", "3", "4", "5")...))
+	callbackConfig := CallbackConfig{Url: server.URL, MaxRetries: 3, Batch: CallbackBatchConfig{MaxSize: 2}}
+	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{Workers: 1})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	assert.Equal(t, 5, callback.Process(context.TODO()))
+
+	require.Len(t, bodies, 3)
+	assert.Equal(t, []any{"1", "2"}, bodies[0]["message_ids"])
+	assert.Equal(t, []any{"3", "4"}, bodies[1]["message_ids"])
+	assert.Equal(t, []any{"5"}, bodies[2]["message_ids"])
+	assert.Equal(t, "TRAVELING", bodies[0]["code"])
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		assert.Equal(t, outbox.StatusSentAcknowledged, outboxServiceMock.LastStatuses()[id])
+	}
+}
+
+func TestCallbackBatchesGroupByDestinationAndReason(t *testing.T) {
+	callback := NewFailedCallbackPipeline(nil, CallbackConfig{Url: "http://crm.example.com", Batch: CallbackBatchConfig{MaxSize: 10}}, PoolConfig{})
+	reachedAt := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	emails := []outbox.Email{
+		{Id: "1", Reason: "mailbox full", UpdatedAt: reachedAt},
+		{Id: "2", Reason: "unknown user", UpdatedAt: reachedAt},
+		{Id: "3", Reason: "mailbox full", UpdatedAt: reachedAt},
+	}
+
+	batches := callback.batches(context.TODO(), emails, time.Now())
+
+	require.Len(t, batches, 2)
+	assert.Equal(t, []string{"1", "3"}, batchCallbackData(batches[0].items).Ids)
+	assert.Equal(t, []string{"2"}, batchCallbackData(batches[1].items).Ids)
+	assert.Equal(t, "unknown user", batchCallbackData(batches[1].items).Reason)
+}
+
+func TestCallbackBatchesHoldBackIncompleteBatchUntilMaxWait(t *testing.T) {
+	now := time.Now()
+	callback := NewSentCallbackPipeline(nil, CallbackConfig{Url: "http://crm.example.com", Batch: CallbackBatchConfig{MaxSize: 2, MaxWait: time.Minute}}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	batches := callback.batches(context.TODO(), batchEmails(now.Add(-10*time.Second), "1", "2", "3"), now)
+	require.Len(t, batches, 1)
+	assert.Len(t, batches[0].items, 2)
+
+	batches = callback.batches(context.TODO(), batchEmails(now.Add(-2*time.Minute), "1", "2", "3"), now)
+	assert.Len(t, batches, 2)
+}
+
+func TestCallbackBatchesProcessCountsDispatchedEmailsOnly(t *testing.T) {
+	ts := newTestServer(http.StatusOK)
+	defer ts.server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Emails(batchEmails(time.Now().Add(-10*time.Second), "1", "2", "3")...))
+	callbackConfig := CallbackConfig{Url: ts.server.URL, MaxRetries: 3, Batch: CallbackBatchConfig{MaxSize: 2, MaxWait: time.Minute}}
+	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{Workers: 1})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	assert.Equal(t, 2, callback.Process(context.TODO()))
+	assert.Equal(t, 1, ts.invocationCount)
+	assert.NotContains(t, outboxServiceMock.LastStatuses(), "3")
+}
+
+func TestCallbackBatchAppliesPerEmailResults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[{"message_id":"1","status":200},{"message_id":"2","status":503,"error":"busy"},{"message_id":"3","status":422,"error":"unknown id"}]}`))
+	}))
+	defer server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Emails(batchEmails(time.Now().Add(-time.Hour), "1", "2", "3", "4")...))
+	callbackConfig := CallbackConfig{Url: server.URL, RetryInterval: time.Second, MaxRetries: 3, Batch: CallbackBatchConfig{MaxSize: 10}}
+	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{Workers: 1})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	callback.Process(context.TODO())
+
+	assert.Equal(t, map[string]string{
+		"1": outbox.StatusSentAcknowledged,
+		"2": outbox.StatusSent,
+		"3": outbox.StatusSentCallbackFailed,
+		"4": outbox.StatusSentAcknowledged,
+	}, outboxServiceMock.LastStatuses())
+}
+
+func TestCallbackBatchIsRetriedAsAUnit(t *testing.T) {
+	ts := newTestServer(http.StatusServiceUnavailable)
+	defer ts.server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Emails(batchEmails(time.Now().Add(-time.Hour), "1", "2", "3")...))
+	callbackConfig := CallbackConfig{Url: ts.server.URL, RetryInterval: time.Second, MaxRetries: 3, Batch: CallbackBatchConfig{MaxSize: 10}}
+	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{Workers: 1})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	callback.Process(context.TODO())
+
+	assert.Equal(t, 1, ts.invocationCount)
+	assert.Len(t, outboxServiceMock.RetryDelays(), 3)
+	for _, id := range []string{"1", "2", "3"} {
+		assert.Equal(t, outbox.StatusSent, outboxServiceMock.LastStatuses()[id])
+	}
+}
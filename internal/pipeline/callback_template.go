@@ -0,0 +1,138 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"text/template"
+
+	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/failure"
+)
+
+// DefaultCallbackBodyTemplate is the built-in preset used when no body template is configured.
+// It renders the historical body: code, reached_at, message_ids and reason, plus the producer metadata
+// when the payload has any, the structured failure of a FAILED, INVALID or BOUNCED email and its rejected fields.
+const DefaultCallbackBodyTemplate = `{{- $code := "DISPATCH-ERROR" -}}
+{{- $reason := .Reason -}}
+{{- if eq .Status "SENT" -}}
+	{{- $code = "TRAVELING" -}}
+	{{- $reason = "Consegnato al server di posta" -}}
+{{- else if eq .Status "CANCELLED" -}}
+	{{- $code = "CANCELLED" -}}
+{{- else if eq .Status "INVALID" -}}
+	{{- $code = "VALIDATION-ERROR" -}}
+{{- else if eq .Status "BOUNCED" -}}
+	{{- $code = "BOUNCED" -}}
+{{- else if eq .Status "SEND-UNCERTAIN" -}}
+	{{- $code = "SEND-UNCERTAIN" -}}
+{{- end -}}
+{"code":{{ json $code }},"reached_at":{{ json .ReachedAt }},"message_ids":{{ if .Ids }}{{ json .Ids }}{{ else }}[{{ json .Id }}]{{ end }},"reason":{{ json $reason }}
+{{- with .Metadata }},"metadata":{{ json . }}{{ end }}
+{{- with .Failure }},"failure":{{ json . }}{{ end }}
+{{- with .ValidationErrors }},"errors":{{ json . }}{{ end }}}`
+
+const defaultCallbackContentType = "application/json"
+
+// CallbackData is what the body and header templates can access.
+// A batched request carries the reason and metadata its emails share, the latest ReachedAt and one entry per email in Emails.
+type CallbackData struct {
+	// Id is the email of a single request, the first email of a batch
+	Id string
+	// Ids lists every email of the request
+	Ids []string
+	// Status is the status the email reached: SENT, FAILED, CANCELLED, INVALID, BOUNCED or SEND-UNCERTAIN
+	Status string
+	Reason string
+	// Failure is the structured reason of a FAILED or INVALID email, nil otherwise
+	Failure *failure.Reason
+	// ReachedAt is when the email entered Status, in RFC 3339
+	ReachedAt string
+	// CalledAt is when the callback is rendered, in RFC 3339
+	CalledAt string
+	// Metadata is the callback_metadata of the payload, empty when the producer set none
+	Metadata json.RawMessage
+	// ValidationErrors are the payload fields rejected at intake, only for INVALID
+	ValidationErrors []email.ValidationError
+	// Payload is the email payload, nil when it can no longer be loaded or for a batch
+	Payload *email.Payload
+	// Emails holds the data of each email of a batch, empty for a single request
+	Emails []CallbackData
+}
+
+// CallbackTemplate renders the body and the headers of a callback request.
+type CallbackTemplate struct {
+	body    *template.Template
+	headers map[string]*template.Template
+}
+
+var callbackTemplateFuncs = template.FuncMap{
+	// json encodes a value as a JSON literal, to embed strings safely in a JSON body
+	"json": func(v any) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}
+
+// NewCallbackTemplate parses a body template, empty for the built-in preset, and header templates.
+// Header values without template actions are sent as they are.
+func NewCallbackTemplate(body string, headers map[string]string) (*CallbackTemplate, error) {
+	if body == "" {
+		body = DefaultCallbackBodyTemplate
+	}
+
+	bodyTemplate, err := template.New("body").Funcs(callbackTemplateFuncs).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid callback body template: %w", err)
+	}
+
+	t := &CallbackTemplate{body: bodyTemplate, headers: make(map[string]*template.Template, len(headers))}
+	for name, value := range headers {
+		headerTemplate, err := template.New(name).Funcs(callbackTemplateFuncs).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid callback header template %s: %w", name, err)
+		}
+		t.headers[name] = headerTemplate
+	}
+
+	return t, nil
+}
+
+// DefaultCallbackTemplate returns the built-in preset without extra headers.
+func DefaultCallbackTemplate() *CallbackTemplate {
+	t, err := NewCallbackTemplate("", nil)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Render returns the body and the headers of the callback request for data.
+// Content-Type defaults to application/json unless a configured header sets it.
+func (t *CallbackTemplate) Render(data CallbackData) ([]byte, http.Header, error) {
+	var body bytes.Buffer
+	if err := t.body.Execute(&body, data); err != nil {
+		return nil, nil, fmt.Errorf("failed to render callback body: %w", err)
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", defaultCallbackContentType)
+
+	names := make([]string, 0, len(t.headers))
+	for name := range t.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var value bytes.Buffer
+		if err := t.headers[name].Execute(&value, data); err != nil {
+			return nil, nil, fmt.Errorf("failed to render callback header %s: %w", name, err)
+		}
+		headers.Set(name, value.String())
+	}
+
+	return body.Bytes(), headers, nil
+}
@@ -0,0 +1,80 @@
+//go:build unit
+
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/outbox"
+)
+
+func TestDefaultCallbackTemplatePreset(t *testing.T) {
+	tests := []struct {
+		status   string
+		reason   string
+		expected string
+	}{
+		{outbox.StatusSent, "", `{"code":"TRAVELING","reached_at":"2025-01-01T10:00:00Z","message_ids":["1"],"reason":"Consegnato al server di posta"}`},
+		{outbox.StatusFailed, `550 "mailbox" unavailable`, `{"code":"DISPATCH-ERROR","reached_at":"2025-01-01T10:00:00Z","message_ids":["1"],"reason":"550 \"mailbox\" unavailable"}`},
+		{outbox.StatusCancelled, "no longer needed", `{"code":"CANCELLED","reached_at":"2025-01-01T10:00:00Z","message_ids":["1"],"reason":"no longer needed"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			body, headers, err := DefaultCallbackTemplate().Render(CallbackData{Id: "1", Status: tt.status, Reason: tt.reason, ReachedAt: "2025-01-01T10:00:00Z"})
+
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.expected, string(body))
+			assert.Equal(t, "application/json", headers.Get("Content-Type"))
+		})
+	}
+}
+
+func TestDefaultCallbackTemplateEchoesMetadata(t *testing.T) {
+	body, _, err := DefaultCallbackTemplate().Render(CallbackData{
+		Id:        "1",
+		Status:    outbox.StatusSent,
+		ReachedAt: "2025-01-01T10:00:00Z",
+		Metadata:  []byte(`{"tenant":"acme","ref":[1,2]}`),
+	})
+
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"code":"TRAVELING","reached_at":"2025-01-01T10:00:00Z","message_ids":["1"],"reason":"Consegnato al server di posta","metadata":{"tenant":"acme","ref":[1,2]}}`, string(body))
+}
+
+func TestCallbackTemplateRendersBodyAndHeaders(t *testing.T) {
+	tmpl, err := NewCallbackTemplate(
+		`{"id":{{ json .Id }},"status":{{ json .Status }},"subject":{{ json .Payload.Subject }}}`,
+		map[string]string{"X-Source": "processor", "X-Email-Id": "{{ .Id }}", "Content-Type": "application/vnd.events+json"},
+	)
+	require.NoError(t, err)
+
+	body, headers, err := tmpl.Render(CallbackData{Id: "1", Status: outbox.StatusSent, Payload: &email.Payload{Subject: "Hello"}})
+
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"1","status":"SENT","subject":"Hello"}`, string(body))
+	assert.Equal(t, "processor", headers.Get("X-Source"))
+	assert.Equal(t, "1", headers.Get("X-Email-Id"))
+	assert.Equal(t, "application/vnd.events+json", headers.Get("Content-Type"))
+}
+
+func TestCallbackTemplateMissingPayload(t *testing.T) {
+	tmpl, err := NewCallbackTemplate(`{"subject":{{ json .Payload.Subject }}}`, nil)
+	require.NoError(t, err)
+
+	_, _, err = tmpl.Render(CallbackData{Id: "1"})
+
+	assert.ErrorContains(t, err, "failed to render callback body")
+}
+
+func TestNewCallbackTemplateInvalid(t *testing.T) {
+	_, err := NewCallbackTemplate(`{{ .Id `, nil)
+	assert.ErrorContains(t, err, "invalid callback body template")
+
+	_, err = NewCallbackTemplate("", map[string]string{"X-Email-Id": "{{ .Id"})
+	assert.ErrorContains(t, err, "invalid callback header template X-Email-Id")
+}
@@ -4,15 +4,24 @@ package pipeline
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"mailculator-processor/internal/callbacksink"
+	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/failure"
 	"mailculator-processor/internal/outbox"
 	"mailculator-processor/internal/testutils/mocks"
+	"mailculator-processor/internal/tracing"
+	"mailculator-processor/pkg/callbacksig"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type testServer struct {
@@ -41,8 +50,9 @@ func TestSuccessCallbackPipeline(t *testing.T) {
 	callbackConfig := CallbackConfig{RetryInterval: 2, MaxRetries: 3}
 
 	callbacks := []*CallbackPipeline{
-		NewSentCallbackPipeline(outboxServiceMock, callbackConfig),
-		NewFailedCallbackPipeline(outboxServiceMock, callbackConfig),
+		NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{}),
+		NewFailedCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{}),
+		NewCancelledCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{}),
 	}
 
 	for _, callback := range callbacks {
@@ -51,8 +61,8 @@ func TestSuccessCallbackPipeline(t *testing.T) {
 		defer ts.server.Close()
 		callback.cfg.Url = ts.server.URL
 		callback.logger = logger
-		callback.Process(context.TODO())
 
+		assert.Equal(t, 1, callback.Process(context.TODO()))
 		assert.Contains(t, ts.server.URL, ts.calledDomain)
 		assert.Equal(t, 1, ts.invocationCount)
 		assert.Equal(t,
@@ -62,14 +72,49 @@ func TestSuccessCallbackPipeline(t *testing.T) {
 	}
 }
 
+func TestCancelledCallbackPayload(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Status: "", Reason: "no longer needed"}))
+	callback := NewCancelledCallbackPipeline(outboxServiceMock, CallbackConfig{Url: server.URL, RetryInterval: 2, MaxRetries: 3}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+	callback.Process(context.TODO())
+
+	assert.Equal(t, "CANCELLED", body["code"])
+	assert.Equal(t, "no longer needed", body["reason"])
+	assert.Equal(t, outbox.StatusCancelledAcknowledged, outboxServiceMock.LastUpdateStatus())
+}
+
+func TestSendUncertainCallbackPayload(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Reason: "send outcome unknown after crash"}))
+	callback := NewSendUncertainCallbackPipeline(outboxServiceMock, CallbackConfig{Url: server.URL, RetryInterval: 2, MaxRetries: 3}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+	callback.Process(context.TODO())
+
+	assert.Equal(t, "SEND-UNCERTAIN", body["code"])
+	assert.Equal(t, outbox.StatusSendUncertainAcknowledged, outboxServiceMock.LastUpdateStatus())
+}
+
 func TestQueryCallbackError(t *testing.T) {
 	buf, logger := mocks.NewLoggerMock()
 	outboxServiceMock := mocks.NewOutboxMock(mocks.QueryMethodError(errors.New("some query error")))
 	callbackConfig := CallbackConfig{Url: "", RetryInterval: 2, MaxRetries: 3}
-	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig)
+	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{})
 	callback.logger = logger
-	callback.Process(context.TODO())
 
+	assert.Equal(t, 0, callback.Process(context.TODO()))
 	assert.Equal(t,
 		"level=ERROR msg=\"error while querying emails to process: some query error\"",
 		strings.TrimSpace(buf.String()),
@@ -80,7 +125,7 @@ func TestLockUpdateError(t *testing.T) {
 	buf, logger := mocks.NewLoggerMock()
 	outboxServiceMock := mocks.NewOutboxMock(mocks.UpdateMethodError(errors.New("some update error")))
 	callbackConfig := CallbackConfig{Url: "", RetryInterval: 2, MaxRetries: 3}
-	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig)
+	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{})
 	callback.logger = logger
 	callback.Process(context.TODO())
 
@@ -90,22 +135,19 @@ func TestLockUpdateError(t *testing.T) {
 	)
 }
 
-func TestHttpClientDoError(t *testing.T) {
+func TestHttpClientDoErrorSchedulesRetry(t *testing.T) {
 	buf, logger := mocks.NewLoggerMock()
-	outboxServiceMock := mocks.NewOutboxMock(
-		mocks.Email(outbox.Email{Id: "1", Status: ""}),
-		mocks.UpdateMethodError(errors.New("some update error")),
-		mocks.UpdateMethodFailsCall(2),
-	)
-	callbackConfig := CallbackConfig{Url: "pippo://pluto.it", RetryInterval: 2, MaxRetries: 3}
-	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig)
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Status: ""}))
+	callbackConfig := CallbackConfig{Url: "pippo://pluto.it", RetryInterval: 2 * time.Second, MaxRetries: 3}
+	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{})
 	callback.logger = logger
 	callback.Process(context.TODO())
 
-	assert.Equal(t,
-		"level=INFO msg=\"processing email 1\"\nlevel=ERROR msg=\"Error in the request: Post \\\"pippo://pluto.it\\\": unsupported protocol scheme \\\"pippo\\\"\" email=1",
-		strings.TrimSpace(buf.String()),
-	)
+	assert.Equal(t, "scheduleCallbackRetry", outboxServiceMock.LastMethod())
+	require.Len(t, outboxServiceMock.RetryDelays(), 1)
+	assert.GreaterOrEqual(t, outboxServiceMock.RetryDelays()[0], time.Second)
+	assert.LessOrEqual(t, outboxServiceMock.RetryDelays()[0], 2*time.Second)
+	assert.Contains(t, buf.String(), `unsupported protocol scheme \"pippo\". Attempt 1/3, retrying in`)
 }
 
 func TestAcknowledgedUpdateError(t *testing.T) {
@@ -118,7 +160,7 @@ func TestAcknowledgedUpdateError(t *testing.T) {
 	ts := newTestServer(http.StatusOK)
 	defer ts.server.Close()
 	callbackConfig := CallbackConfig{Url: ts.server.URL, RetryInterval: 2, MaxRetries: 3}
-	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig)
+	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{})
 	callback.logger = logger
 	callback.Process(context.TODO())
 
@@ -128,23 +170,364 @@ func TestAcknowledgedUpdateError(t *testing.T) {
 	)
 }
 
-func TestStatusConflict(t *testing.T) {
+func TestStatusConflictOnLastAttemptParksEmail(t *testing.T) {
 	buf, logger := mocks.NewLoggerMock()
-	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Status: ""}))
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Status: "", CallbackAttempts: 2}))
 	ts := newTestServer(http.StatusConflict)
 	defer ts.server.Close()
-	callbackConfig := CallbackConfig{Url: ts.server.URL, RetryInterval: 2, MaxRetries: 3}
-	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig)
+	callbackConfig := CallbackConfig{Url: ts.server.URL, RetryInterval: 2 * time.Second, MaxRetries: 3}
+	callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{})
 	callback.logger = logger
 	callback.Process(context.TODO())
 
-	assert.Contains(t, ts.server.URL, ts.calledDomain)
-	assert.Equal(t, 3, ts.invocationCount)
+	assert.Equal(t, 1, ts.invocationCount)
+	assert.Equal(t, outbox.StatusSentCallbackFailed, outboxServiceMock.LastUpdateFromStatus())
+	assert.Empty(t, outboxServiceMock.RetryDelays())
 	expectedMsgError := `level=INFO msg="processing email 1"
-level=WARN msg="Response status code is 409. Try to call again {url} in 2 seconds. Attempt 1/3" email=1
-level=WARN msg="Response status code is 409. Try to call again {url} in 2 seconds. Attempt 2/3" email=1
-level=WARN msg="Response status code is 409. Attempt 3/3" email=1
-level=ERROR msg="Max retries exceeded for the url {url}" email=1
-level=ERROR msg="error on callback, status: 409, response: " email=1`
+level=ERROR msg="callback to {url} failed: status 409, response: . Attempt 3/3, giving up" email=1`
 	assert.Equal(t, strings.ReplaceAll(expectedMsgError, "{url}", ts.server.URL), strings.TrimSpace(buf.String()))
 }
+
+func TestCallbackRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		wantMethod string
+	}{
+		{"accepted acknowledges", http.StatusAccepted, "update"},
+		{"no content acknowledges", http.StatusNoContent, "update"},
+		{"server error retries", http.StatusServiceUnavailable, "scheduleCallbackRetry"},
+		{"conflict retries", http.StatusConflict, "scheduleCallbackRetry"},
+		{"too many requests retries", http.StatusTooManyRequests, "scheduleCallbackRetry"},
+		{"bad request parks", http.StatusBadRequest, "updateFrom"},
+		{"not found parks", http.StatusNotFound, "updateFrom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(tt.statusCode)
+			defer ts.server.Close()
+
+			outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1"}))
+			callback := NewFailedCallbackPipeline(outboxServiceMock, CallbackConfig{Url: ts.server.URL, RetryInterval: time.Second, MaxRetries: 3}, PoolConfig{})
+			_, callback.logger = mocks.NewLoggerMock()
+			callback.Process(context.TODO())
+
+			assert.Equal(t, tt.wantMethod, outboxServiceMock.LastMethod())
+		})
+	}
+}
+
+func TestCallbackHonoursRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1"}))
+	callback := NewSentCallbackPipeline(outboxServiceMock, CallbackConfig{Url: server.URL, RetryInterval: time.Second, MaxRetryInterval: time.Hour, MaxRetries: 3}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+	callback.Process(context.TODO())
+
+	assert.Equal(t, []time.Duration{2 * time.Minute}, outboxServiceMock.RetryDelays())
+}
+
+func TestCallbackRetryDelay(t *testing.T) {
+	callback := &CallbackPipeline{cfg: CallbackConfig{RetryInterval: 10 * time.Second, MaxRetryInterval: time.Minute}}
+
+	for range 20 {
+		first := callback.retryDelay(1, 0)
+		assert.GreaterOrEqual(t, first, 5*time.Second)
+		assert.LessOrEqual(t, first, 10*time.Second)
+
+		third := callback.retryDelay(3, 0)
+		assert.GreaterOrEqual(t, third, 20*time.Second)
+		assert.LessOrEqual(t, third, 40*time.Second)
+
+		capped := callback.retryDelay(10, 0)
+		assert.GreaterOrEqual(t, capped, 30*time.Second)
+		assert.LessOrEqual(t, capped, time.Minute)
+	}
+
+	assert.Equal(t, 30*time.Second, callback.retryDelay(1, 30*time.Second))
+	assert.Equal(t, time.Minute, callback.retryDelay(1, time.Hour))
+}
+
+func TestCallbackPropagatesTraceContext(t *testing.T) {
+	shutdown, err := tracing.Setup(context.TODO(), tracing.Config{Exporter: tracing.ExporterStdout, Writer: io.Discard})
+	assert.NoError(t, err)
+	defer func() { _ = shutdown(context.TODO()) }()
+
+	var traceParent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	callback := NewSentCallbackPipeline(mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1"})), CallbackConfig{Url: server.URL, MaxRetries: 1}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	callback.Process(context.TODO())
+
+	assert.Regexp(t, "^00-[0-9a-f]{32}-[0-9a-f]{16}-01$", traceParent)
+}
+
+func TestCallbackSendsTemplatedRequest(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tmpl, err := NewCallbackTemplate(`{"id":{{ json .Id }},"status":{{ json .Status }}}`, map[string]string{"X-Source": "processor"})
+	assert.NoError(t, err)
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1"}))
+	callback := NewFailedCallbackPipeline(outboxServiceMock, CallbackConfig{Url: server.URL, MaxRetries: 1, Template: tmpl}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+	callback.Process(context.TODO())
+
+	assert.JSONEq(t, `{"id":"1","status":"FAILED"}`, string(body))
+	assert.Equal(t, "processor", header.Get("X-Source"))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, outbox.StatusFailedAcknowledged, outboxServiceMock.LastUpdateStatus())
+}
+
+func TestCallbackUsesPayloadURLAndMetadata(t *testing.T) {
+	globalServer := newTestServer(http.StatusOK)
+	defer globalServer.server.Close()
+
+	var body map[string]any
+	producerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer producerServer.Close()
+
+	payloadFile := createTestPayloadFile(t, email.Payload{
+		Id:               "550e8400-e29b-41d4-a716-446655440000",
+		From:             "sender@example.com",
+		ReplyTo:          "reply@example.com",
+		To:               "recipient@example.com",
+		Subject:          "Test Subject",
+		BodyText:         "Test",
+		CallbackURL:      producerServer.URL,
+		CallbackMetadata: []byte(`{"tenant":"acme"}`),
+	})
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", PayloadFilePath: payloadFile}))
+	callback := NewSentCallbackPipeline(outboxServiceMock, CallbackConfig{Url: globalServer.server.URL, AllowedHosts: []string{"127.0.0.1"}, MaxRetries: 1}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+	callback.Process(context.TODO())
+
+	assert.Equal(t, 0, globalServer.invocationCount)
+	assert.Equal(t, "TRAVELING", body["code"])
+	assert.Equal(t, map[string]any{"tenant": "acme"}, body["metadata"])
+}
+
+func TestInvalidCallbackNeverCallsRejectedHost(t *testing.T) {
+	globalServer := newTestServer(http.StatusOK)
+	defer globalServer.server.Close()
+	rejectedServer := newTestServer(http.StatusOK)
+	defer rejectedServer.server.Close()
+
+	payloadFile := createTestPayloadFile(t, email.Payload{
+		Id:          "550e8400-e29b-41d4-a716-446655440000",
+		From:        "sender@example.com",
+		ReplyTo:     "reply@example.com",
+		To:          "recipient@example.com",
+		Subject:     "Test Subject",
+		BodyText:    "Test",
+		CallbackURL: rejectedServer.server.URL,
+	})
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{
+		Id:               "1",
+		Reason:           "callback_url host 127.0.0.1 is not allowed",
+		PayloadFilePath:  payloadFile,
+		ValidationErrors: json.RawMessage(`[{"field":"callback_url","tag":"allowed_host","value":"` + rejectedServer.server.URL + `"}]`),
+	}))
+	callback := NewInvalidCallbackPipeline(outboxServiceMock, CallbackConfig{Url: globalServer.server.URL, AllowedHosts: []string{"crm.example.com"}, MaxRetries: 1}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	callback.Process(context.TODO())
+
+	assert.Equal(t, 0, rejectedServer.invocationCount)
+	assert.Equal(t, 1, globalServer.invocationCount)
+	assert.Equal(t, outbox.StatusInvalidAcknowledged, outboxServiceMock.LastStatuses()["1"])
+}
+
+func TestCallbackSignsRequest(t *testing.T) {
+	var verifyErr error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, verifyErr = callbacksig.VerifyRequest(r, []string{"next-signing-secret-0123456789abcdef"}, 0)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	secrets := []string{"current-signing-secret-0123456789abc", "next-signing-secret-0123456789abcdef"}
+	callback := NewSentCallbackPipeline(mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1"})), CallbackConfig{Url: server.URL, MaxRetries: 1, SigningSecrets: secrets}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+	callback.Process(context.TODO())
+
+	assert.NoError(t, verifyErr)
+}
+
+type recordingSink struct {
+	messages []callbacksink.Message
+	err      error
+}
+
+func (s *recordingSink) Deliver(_ context.Context, msg callbacksink.Message) (callbacksink.Receipt, error) {
+	s.messages = append(s.messages, msg)
+	return callbacksink.Receipt{}, s.err
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func TestCallbackDeliversThroughSink(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus string
+	}{
+		{"broker ack acknowledges", nil, outbox.StatusSentAcknowledged},
+		{"broker failure retries", errors.New("nats publish failed: timeout"), outbox.StatusSent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &recordingSink{err: tt.err}
+			outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", UpdatedAt: "2025-01-01T10:00:00Z"}))
+			callbackConfig := CallbackConfig{RetryInterval: time.Second, MaxRetries: 3, SigningSecrets: []string{"dummy-signing-secret-0123456789abcdef"}, Sink: sink}
+			callback := NewSentCallbackPipeline(outboxServiceMock, callbackConfig, PoolConfig{})
+			_, callback.logger = mocks.NewLoggerMock()
+
+			callback.Process(context.TODO())
+
+			require.Len(t, sink.messages, 1)
+			msg := sink.messages[0]
+			assert.Equal(t, "1", msg.Key)
+			assert.JSONEq(t, `{"code":"TRAVELING","reached_at":"2025-01-01T10:00:00Z","message_ids":["1"],"reason":"Consegnato al server di posta"}`, string(msg.Body))
+			assert.Equal(t, "application/json", msg.Headers.Get("Content-Type"))
+			assert.NotEmpty(t, msg.Headers.Get(callbacksig.SignatureHeader))
+			assert.Equal(t, tt.wantStatus, outboxServiceMock.LastStatuses()["1"])
+		})
+	}
+}
+
+func TestInvalidCallbackReportsValidationErrors(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{
+		Id:               "1",
+		Reason:           "payload validation failed",
+		PayloadFilePath:  "/nonexistent/invalid.json",
+		ValidationErrors: json.RawMessage(`[{"field":"to","tag":"email","value":"nope"}]`),
+	}))
+	callback := NewInvalidCallbackPipeline(outboxServiceMock, CallbackConfig{Url: server.URL, MaxRetries: 3}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	callback.Process(context.TODO())
+
+	assert.Equal(t, "VALIDATION-ERROR", body["code"])
+	assert.Equal(t, "payload validation failed", body["reason"])
+	assert.Equal(t, []any{map[string]any{"field": "to", "tag": "email", "value": "nope"}}, body["errors"])
+	assert.Equal(t, outbox.StatusInvalidAcknowledged, outboxServiceMock.LastStatuses()["1"])
+}
+
+func TestSentCallbackOmitsValidationErrorsOfARequeuedEmail(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{
+		Id:               "1",
+		PayloadFilePath:  "/nonexistent/payload.json",
+		ValidationErrors: json.RawMessage(`[{"field":"to","tag":"email","value":"nope"}]`),
+	}))
+	callback := NewSentCallbackPipeline(outboxServiceMock, CallbackConfig{Url: server.URL, MaxRetries: 3}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	callback.Process(context.TODO())
+
+	assert.NotContains(t, body, "errors")
+	assert.Equal(t, outbox.StatusSentAcknowledged, outboxServiceMock.LastStatuses()["1"])
+}
+
+func TestFailedCallbackForwardsStructuredFailure(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{
+		Id:     "1",
+		Reason: "550 5.1.1 User unknown",
+		Failure: &failure.Reason{
+			Category:     failure.CategorySMTPPermanent,
+			SMTPCode:     550,
+			EnhancedCode: "5.1.1",
+			Message:      "550 5.1.1 User unknown",
+		},
+	}))
+	callback := NewFailedCallbackPipeline(outboxServiceMock, CallbackConfig{Url: server.URL, MaxRetries: 3}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	callback.Process(context.TODO())
+
+	assert.Equal(t, "DISPATCH-ERROR", body["code"])
+	assert.Equal(t, map[string]any{
+		"category":      "smtp-permanent",
+		"smtp_code":     float64(550),
+		"enhanced_code": "5.1.1",
+		"message":       "550 5.1.1 User unknown",
+	}, body["failure"])
+	assert.Equal(t, outbox.StatusFailedAcknowledged, outboxServiceMock.LastStatuses()["1"])
+}
+
+func TestBouncedCallbackReportsDiagnostic(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{
+		Id:     "1",
+		Reason: "452 4.2.2 Mailbox full",
+		Failure: &failure.Reason{
+			Category:     failure.CategoryBounceSoft,
+			SMTPCode:     452,
+			EnhancedCode: "4.2.2",
+			Message:      "452 4.2.2 Mailbox full",
+		},
+	}))
+	callback := NewBouncedCallbackPipeline(outboxServiceMock, CallbackConfig{Url: server.URL, MaxRetries: 3}, PoolConfig{})
+	_, callback.logger = mocks.NewLoggerMock()
+
+	callback.Process(context.TODO())
+
+	assert.Equal(t, "BOUNCED", body["code"])
+	assert.Equal(t, "452 4.2.2 Mailbox full", body["reason"])
+	assert.Equal(t, "bounce-soft", body["failure"].(map[string]any)["category"])
+	assert.Equal(t, outbox.StatusBouncedAcknowledged, outboxServiceMock.LastStatuses()["1"])
+}
@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"mailculator-processor/internal/failure"
+	"mailculator-processor/internal/outbox"
+)
+
+// claimedStatuses maps each status a pipeline claims an email with to the status it is released to.
+var claimedStatuses = map[string]string{
+	outbox.StatusIntaking:                 outbox.StatusAccepted,
+	outbox.StatusProcessing:               outbox.StatusReady,
+	outbox.StatusCallingSentCallback:      outbox.StatusSent,
+	outbox.StatusCallingFailedCallback:    outbox.StatusFailed,
+	outbox.StatusCallingCancelledCallback: outbox.StatusCancelled,
+	outbox.StatusCallingInvalidCallback:   outbox.StatusInvalid,
+	outbox.StatusCallingBouncedCallback:   outbox.StatusBounced,
+
+	outbox.StatusCallingSendUncertainCallback: outbox.StatusSendUncertain,
+}
+
+// ShutdownSummary reports how the emails claimed at shutdown were handled.
+type ShutdownSummary struct {
+	// Drained emails left their claimed status during the grace period
+	Drained int
+	// Released emails were restored to the status they were claimed from
+	Released int
+	// Pending emails could not be released and are left to the restore pipelines
+	Pending int
+}
+
+// ClaimTracker wraps the outbox and records the emails claimed by the pipelines of this process
+// until they leave the claimed status, so that they can be released on shutdown.
+type ClaimTracker struct {
+	outboxService
+	mu       sync.Mutex
+	claims   map[string]string
+	draining bool
+	drained  int
+	logger   *slog.Logger
+}
+
+func NewClaimTracker(ob outboxService) *ClaimTracker {
+	return &ClaimTracker{
+		outboxService: ob,
+		claims:        make(map[string]string),
+		logger:        slog.With("component", "shutdown"),
+	}
+}
+
+func (t *ClaimTracker) Update(ctx context.Context, id string, status string, errorReason string) error {
+	err := t.outboxService.Update(ctx, id, status, errorReason)
+	if err == nil {
+		t.record(id, status)
+	}
+	return err
+}
+
+func (t *ClaimTracker) UpdateFrom(ctx context.Context, id string, fromStatus string, toStatus string, errorReason string) error {
+	err := t.outboxService.UpdateFrom(ctx, id, fromStatus, toStatus, errorReason)
+	if err == nil {
+		t.record(id, toStatus)
+	}
+	return err
+}
+
+func (t *ClaimTracker) ScheduleCallbackRetry(ctx context.Context, id string, fromStatus string, toStatus string, delay time.Duration, reason string) error {
+	err := t.outboxService.ScheduleCallbackRetry(ctx, id, fromStatus, toStatus, delay, reason)
+	if err == nil {
+		t.record(id, toStatus)
+	}
+	return err
+}
+
+func (t *ClaimTracker) Fail(ctx context.Context, id string, status string, reason failure.Reason) error {
+	err := t.outboxService.Fail(ctx, id, status, reason)
+	if err == nil {
+		t.record(id, status)
+	}
+	return err
+}
+
+func (t *ClaimTracker) FailFrom(ctx context.Context, id string, fromStatus string, status string, reason failure.Reason) error {
+	err := t.outboxService.FailFrom(ctx, id, fromStatus, status, reason)
+	if err == nil {
+		t.record(id, status)
+	}
+	return err
+}
+
+func (t *ClaimTracker) MarkInvalid(ctx context.Context, id string, reason failure.Reason, validationErrors json.RawMessage) error {
+	err := t.outboxService.MarkInvalid(ctx, id, reason, validationErrors)
+	if err == nil {
+		t.record(id, outbox.StatusInvalid)
+	}
+	return err
+}
+
+func (t *ClaimTracker) Ready(ctx context.Context, id string) error {
+	err := t.outboxService.Ready(ctx, id)
+	if err == nil {
+		t.record(id, outbox.StatusReady)
+	}
+	return err
+}
+
+func (t *ClaimTracker) MarkSending(ctx context.Context, id string, idempotencyKey string, returnPath string) error {
+	err := t.outboxService.MarkSending(ctx, id, idempotencyKey, returnPath)
+	if errors.Is(err, outbox.ErrCancelled) {
+		t.record(id, outbox.StatusCancelled)
+	}
+	return err
+}
+
+// InFlight returns the number of emails currently claimed.
+func (t *ClaimTracker) InFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.claims)
+}
+
+// StartDraining starts counting the claimed emails completed from now on as drained.
+func (t *ClaimTracker) StartDraining() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.draining = true
+}
+
+// Release restores every email still claimed to the status it was claimed from.
+// A PROCESSING email with a send marker may already be accepted by SMTP, so it is not released
+// and is left to the restore pipeline and its ambiguous send policy.
+func (t *ClaimTracker) Release(ctx context.Context) ShutdownSummary {
+	t.mu.Lock()
+	claims := t.claims
+	t.claims = make(map[string]string)
+	summary := ShutdownSummary{Drained: t.drained}
+	t.mu.Unlock()
+
+	for id, status := range claims {
+		logger := t.logger.With("email", id)
+
+		if status == outbox.StatusProcessing {
+			marked, err := t.outboxService.HasSendMarker(ctx, id)
+			if err != nil || marked {
+				logger.Warn("email may already be handed to SMTP, leaving it to the restore pipeline")
+				summary.Pending++
+				continue
+			}
+		}
+
+		if err := t.outboxService.UpdateFrom(ctx, id, status, claimedStatuses[status], ""); err != nil {
+			logger.Warn(fmt.Sprintf("failed to release email from %v: %v", status, err))
+			summary.Pending++
+			continue
+		}
+
+		summary.Released++
+	}
+
+	return summary
+}
+
+func (t *ClaimTracker) record(id string, status string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, claimed := claimedStatuses[status]; claimed {
+		t.claims[id] = status
+		return
+	}
+
+	if _, found := t.claims[id]; found {
+		delete(t.claims, id)
+		if t.draining {
+			t.drained++
+		}
+	}
+}
@@ -0,0 +1,69 @@
+//go:build unit
+
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/testutils/mocks"
+)
+
+func TestClaimTrackerCountsDrainedEmails(t *testing.T) {
+	tracker := NewClaimTracker(mocks.NewOutboxMock())
+
+	_ = tracker.Update(context.TODO(), "1", outbox.StatusProcessing, "")
+	_ = tracker.Update(context.TODO(), "2", outbox.StatusCallingSentCallback, "")
+	assert.Equal(t, 2, tracker.InFlight())
+
+	tracker.StartDraining()
+	_ = tracker.Update(context.TODO(), "1", outbox.StatusSent, "")
+
+	assert.Equal(t, 1, tracker.InFlight())
+	assert.Equal(t, ShutdownSummary{Drained: 1, Released: 1}, tracker.Release(context.TODO()))
+	assert.Equal(t, 0, tracker.InFlight())
+}
+
+func TestClaimTrackerReleasesToPreviousStatus(t *testing.T) {
+	outboxServiceMock := mocks.NewOutboxMock()
+	tracker := NewClaimTracker(outboxServiceMock)
+
+	_ = tracker.Update(context.TODO(), "1", outbox.StatusProcessing, "")
+	summary := tracker.Release(context.TODO())
+
+	assert.Equal(t, ShutdownSummary{Released: 1}, summary)
+	assert.Equal(t, outbox.StatusReady, outboxServiceMock.LastUpdateFromStatus())
+}
+
+func TestClaimTrackerLeavesMarkedEmailsToRestore(t *testing.T) {
+	outboxServiceMock := mocks.NewOutboxMock(mocks.HasSendMarker(true))
+	tracker := NewClaimTracker(outboxServiceMock)
+
+	_ = tracker.Update(context.TODO(), "1", outbox.StatusProcessing, "")
+	summary := tracker.Release(context.TODO())
+
+	assert.Equal(t, ShutdownSummary{Pending: 1}, summary)
+	assert.Equal(t, "", outboxServiceMock.LastUpdateFromStatus())
+}
+
+func TestClaimTrackerForgetsCancelledEmails(t *testing.T) {
+	tracker := NewClaimTracker(mocks.NewOutboxMock(mocks.MarkSendingMethodError(outbox.ErrCancelled)))
+
+	_ = tracker.Update(context.TODO(), "1", outbox.StatusProcessing, "")
+	_ = tracker.MarkSending(context.TODO(), "1", "key", "")
+
+	assert.Equal(t, 0, tracker.InFlight())
+}
+
+func TestClaimTrackerForgetsEmailsScheduledForRetry(t *testing.T) {
+	tracker := NewClaimTracker(mocks.NewOutboxMock())
+
+	_ = tracker.Update(context.TODO(), "1", outbox.StatusCallingSentCallback, "")
+	_ = tracker.ScheduleCallbackRetry(context.TODO(), "1", outbox.StatusCallingSentCallback, outbox.StatusSent, time.Second, "status 503")
+
+	assert.Equal(t, 0, tracker.InFlight())
+}
@@ -2,75 +2,102 @@ package pipeline
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
-	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
 
 	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/failure"
+	"mailculator-processor/internal/metrics"
 	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/tracing"
 )
 
 type IntakePipeline struct {
-	outbox outboxService
-	logger *slog.Logger
+	outbox               outboxService
+	pool                 PoolConfig
+	allowedCallbackHosts []string
+	name                 string
+	logger               *slog.Logger
 }
 
-func NewIntakePipeline(outbox outboxService) *IntakePipeline {
+// NewIntakePipeline marks INVALID the payloads whose callback_url host is not in allowedCallbackHosts.
+func NewIntakePipeline(outbox outboxService, pool PoolConfig, allowedCallbackHosts []string) *IntakePipeline {
 	return &IntakePipeline{
-		outbox: outbox,
-		logger: slog.With("pipe", "intake"),
+		outbox:               outbox,
+		pool:                 pool,
+		allowedCallbackHosts: allowedCallbackHosts,
+		name:                 "intake",
+		logger:               slog.With("pipe", "intake"),
 	}
 }
 
-func (p *IntakePipeline) Process(ctx context.Context) {
-	acceptedList, err := p.outbox.Query(ctx, outbox.StatusAccepted, 25)
+func (p *IntakePipeline) Process(ctx context.Context) int {
+	pool := p.pool.WithDefaults()
+	acceptedList, err := p.outbox.Query(ctx, outbox.StatusAccepted, pool.BatchSize)
 	if err != nil {
 		p.logger.Error(fmt.Sprintf("error while querying emails to process: %v", err))
-		return
+		return 0
 	}
 
-	var wg sync.WaitGroup
-
-	for _, e := range acceptedList {
-		wg.Add(1)
-		go func(email outbox.Email) {
-			defer wg.Done()
-			p.logger.Info(fmt.Sprintf("processing outbox %v", email.Id))
-			subLogger := p.logger.With("outbox", email.Id)
-
-			if err = p.outbox.Update(ctx, email.Id, outbox.StatusIntaking, ""); err != nil {
-				subLogger.Warn(fmt.Sprintf("failed to acquire processing lock, error: %v", err))
-				return
-			}
-
-			if err := p.validatePayload(email); err != nil {
-				subLogger.Error(fmt.Sprintf("failed to validate payload, error: %v", err))
-				p.handle(context.Background(), subLogger, email.Id, outbox.StatusInvalid, err.Error())
-				return
-			}
-
-			if err := p.outbox.Ready(context.Background(), email.Id); err != nil {
-				subLogger.Error(fmt.Sprintf("failed to update status to READY: %v", err))
-				p.handle(context.Background(), subLogger, email.Id, outbox.StatusInvalid, err.Error())
-			} else {
-				subLogger.Info("successfully intaken")
-			}
-		}(e)
-	}
+	forEachEmail(ctx, acceptedList, pool.Workers, func(email outbox.Email) {
+		p.logger.Info(fmt.Sprintf("processing outbox %v", email.Id))
+		subLogger := p.logger.With("outbox", email.Id)
+		metrics.PipelineProcessed.Inc(p.name)
+
+		ctx, span := tracing.Start(ctx, "intake.process", attribute.String("email.id", email.Id))
+		defer span.End()
+
+		if err = p.outbox.Update(ctx, email.Id, outbox.StatusIntaking, ""); err != nil {
+			subLogger.Warn(fmt.Sprintf("failed to acquire processing lock, error: %v", err))
+			metrics.PipelineLockConflicts.Inc(p.name)
+			return
+		}
+
+		if err := p.validatePayload(ctx, email); err != nil {
+			subLogger.Error(fmt.Sprintf("failed to validate payload, error: %v", err))
+			metrics.PipelineFailed.Inc(p.name)
+			p.markInvalid(context.WithoutCancel(ctx), subLogger, email.Id, err)
+			return
+		}
+
+		if err := p.outbox.Ready(context.WithoutCancel(ctx), email.Id); err != nil {
+			subLogger.Error(fmt.Sprintf("failed to update status to READY: %v", err))
+			metrics.PipelineFailed.Inc(p.name)
+			p.fail(context.WithoutCancel(ctx), subLogger, email.Id, outbox.StatusInvalid, err)
+		} else {
+			subLogger.Info("successfully intaken")
+			metrics.PipelineSucceeded.Inc(p.name)
+		}
+	})
 
-	wg.Wait()
+	return len(acceptedList)
 }
 
-func (p *IntakePipeline) validatePayload(e outbox.Email) error {
-	_, err := email.LoadPayload(e.PayloadFilePath)
+func (p *IntakePipeline) validatePayload(ctx context.Context, e outbox.Email) error {
+	payload, err := email.LoadPayload(ctx, e.PayloadFilePath)
 	if err != nil {
 		return err
 	}
-	return nil
+	return payload.CheckCallbackHost(p.allowedCallbackHosts)
+}
+
+// markInvalid stores the classified failure and the fields rejected by the validation, for the invalid callback.
+func (p *IntakePipeline) markInvalid(ctx context.Context, logger *slog.Logger, emailId string, validationErr error) {
+	var validationErrors json.RawMessage
+	if fields := email.ValidationErrors(validationErr); len(fields) > 0 {
+		validationErrors, _ = json.Marshal(fields)
+	}
+
+	if err := p.outbox.MarkInvalid(ctx, emailId, failure.Classify(validationErr), validationErrors); err != nil {
+		logger.Error(fmt.Sprintf("error updating status to %v, error: %v", outbox.StatusInvalid, err))
+	}
 }
 
-func (p *IntakePipeline) handle(ctx context.Context, logger *slog.Logger, emailId string, status string, errorReason string) {
-	if err := p.outbox.Update(ctx, emailId, status, errorReason); err != nil {
+func (p *IntakePipeline) fail(ctx context.Context, logger *slog.Logger, emailId string, status string, cause error) {
+	if err := p.outbox.Fail(ctx, emailId, status, failure.Classify(cause)); err != nil {
 		msg := fmt.Sprintf("error updating status to %v, error: %v", status, err)
 		logger.Error(msg)
 	}
@@ -14,6 +14,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/failure"
 	"mailculator-processor/internal/outbox"
 	"mailculator-processor/internal/testutils/mocks"
 )
@@ -63,7 +64,7 @@ func TestSuccessfulIntake(t *testing.T) {
 
 	buf, logger := mocks.NewLoggerMock()
 
-	intake := NewIntakePipeline(outboxServiceMock)
+	intake := NewIntakePipeline(outboxServiceMock, PoolConfig{}, nil)
 	intake.logger = logger
 
 	intake.Process(context.TODO())
@@ -125,7 +126,7 @@ func TestIntakeInvalidPayloadFile(t *testing.T) {
 		}),
 	)
 
-	intake := NewIntakePipeline(outboxServiceMock)
+	intake := NewIntakePipeline(outboxServiceMock, PoolConfig{}, nil)
 	intake.logger = logger
 
 	intake.Process(context.TODO())
@@ -153,7 +154,7 @@ func TestIntakeInvalidJSON(t *testing.T) {
 		}),
 	)
 
-	intake := NewIntakePipeline(outboxServiceMock)
+	intake := NewIntakePipeline(outboxServiceMock, PoolConfig{}, nil)
 	intake.logger = logger
 
 	intake.Process(context.TODO())
@@ -181,13 +182,17 @@ func TestIntakeValidationError(t *testing.T) {
 		}),
 	)
 
-	intake := NewIntakePipeline(outboxServiceMock)
+	intake := NewIntakePipeline(outboxServiceMock, PoolConfig{}, nil)
 	intake.logger = logger
 
 	intake.Process(context.TODO())
 
 	assert.Contains(t, buf.String(), "level=ERROR msg=\"failed to validate payload")
 	assert.Contains(t, buf.String(), "payload validation failed")
+	assert.Equal(t, "markInvalid", outboxServiceMock.LastMethod())
+	assert.Contains(t, string(outboxServiceMock.ValidationErrors()), `{"field":"from","tag":"email","value":"not-an-email"}`)
+	assert.Equal(t, failure.CategoryValidation, outboxServiceMock.Failure().Category)
+	assert.NotEmpty(t, outboxServiceMock.Failure().Field)
 }
 
 func TestSuccessfulIntakeWithAttachmentsAsStrings(t *testing.T) {
@@ -215,10 +220,41 @@ func TestSuccessfulIntakeWithAttachmentsAsStrings(t *testing.T) {
 
 	buf, logger := mocks.NewLoggerMock()
 
-	intake := NewIntakePipeline(outboxServiceMock)
+	intake := NewIntakePipeline(outboxServiceMock, PoolConfig{}, nil)
 	intake.logger = logger
 
 	intake.Process(context.TODO())
 
 	assert.Contains(t, buf.String(), "level=INFO msg=\"successfully intaken\" outbox=1")
 }
+
+func TestIntakeRejectsCallbackHostNotAllowed(t *testing.T) {
+	payload := email.Payload{
+		Id:          "550e8400-e29b-41d4-a716-446655440000",
+		From:        "sender@example.com",
+		ReplyTo:     "reply@example.com",
+		To:          "recipient@example.com",
+		Subject:     "Test Subject",
+		BodyText:    "Test",
+		CallbackURL: "https://evil.com/notify",
+	}
+
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{
+			Id:              "1",
+			Status:          outbox.StatusAccepted,
+			PayloadFilePath: createTestPayloadFile(t, payload),
+		}),
+	)
+
+	buf, logger := mocks.NewLoggerMock()
+
+	intake := NewIntakePipeline(outboxServiceMock, PoolConfig{}, []string{"crm.example.com"})
+	intake.logger = logger
+
+	intake.Process(context.TODO())
+
+	assert.Contains(t, buf.String(), "callback_url host evil.com is not allowed")
+	assert.Equal(t, outbox.StatusInvalid, outboxServiceMock.LastUpdateStatus())
+	assert.JSONEq(t, `[{"field":"callback_url","tag":"allowed_host","value":"https://evil.com/notify"}]`, string(outboxServiceMock.ValidationErrors()))
+}
@@ -2,8 +2,10 @@ package pipeline
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
+	"mailculator-processor/internal/failure"
 	"mailculator-processor/internal/outbox"
 )
 
@@ -12,5 +14,18 @@ type outboxService interface {
 	QueryStale(ctx context.Context, status string, olderThan time.Duration, limit int) ([]outbox.Email, error)
 	Update(ctx context.Context, id string, status string, errorReason string) error
 	UpdateFrom(ctx context.Context, id string, fromStatus string, toStatus string, errorReason string) error
+	ScheduleCallbackRetry(ctx context.Context, id string, fromStatus string, toStatus string, delay time.Duration, reason string) error
 	Ready(ctx context.Context, id string) error
+	Fail(ctx context.Context, id string, status string, reason failure.Reason) error
+	FailFrom(ctx context.Context, id string, fromStatus string, status string, reason failure.Reason) error
+	MarkInvalid(ctx context.Context, id string, reason failure.Reason, validationErrors json.RawMessage) error
+	MarkSending(ctx context.Context, id string, idempotencyKey string, returnPath string) error
+	ClearSendMarker(ctx context.Context, id string) error
+	HasSendMarker(ctx context.Context, id string) (bool, error)
+	FindBySendMarker(ctx context.Context, idempotencyKey string) (string, error)
+	FindByReturnPath(ctx context.Context, returnPath string) (string, error)
+	Get(ctx context.Context, id string) (outbox.Email, error)
+	History(ctx context.Context, id string) ([]outbox.StatusChange, error)
+	Purge(ctx context.Context, status string, ids []string) ([]string, error)
+	MoveToArchive(ctx context.Context, status string, ids []string) ([]string, error)
 }
@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+
+	"mailculator-processor/internal/outbox"
+)
+
+const defaultBatchSize = 25
+
+// PoolConfig bounds the emails fetched per poll and how many of them are processed concurrently.
+// Zero values fall back to a batch of 25 emails and one worker per email of the batch.
+type PoolConfig struct {
+	BatchSize int
+	Workers   int
+}
+
+// StageConfig holds how the app schedules a pipeline.
+type StageConfig struct {
+	Enabled bool
+	// Interval is the poll interval in seconds
+	Interval int
+	Pool     PoolConfig
+}
+
+// WithDefaults returns the config with zero values replaced by the defaults.
+func (c PoolConfig) WithDefaults() PoolConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	if c.Workers <= 0 {
+		c.Workers = c.BatchSize
+	}
+	return c
+}
+
+// forEachEmail runs fn for every email with at most workers concurrent goroutines and waits for all of them.
+// Once the context is done no further email is dispatched, the running ones are left to finish.
+func forEachEmail(ctx context.Context, emails []outbox.Email, workers int, fn func(email outbox.Email)) {
+	forEach(ctx, emails, workers, fn)
+}
+
+// forEach is forEachEmail for any unit of work, such as a batch of emails.
+func forEach[T any](ctx context.Context, items []T, workers int, fn func(item T)) {
+	sem := make(chan struct{}, max(workers, 1))
+	var wg sync.WaitGroup
+
+	for _, item := range items {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(item T) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(item)
+		}(item)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,54 @@
+//go:build unit
+
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"mailculator-processor/internal/outbox"
+)
+
+func TestPoolConfigDefaults(t *testing.T) {
+	assert.Equal(t, PoolConfig{BatchSize: 25, Workers: 25}, PoolConfig{}.WithDefaults())
+	assert.Equal(t, PoolConfig{BatchSize: 100, Workers: 100}, PoolConfig{BatchSize: 100}.WithDefaults())
+	assert.Equal(t, PoolConfig{BatchSize: 100, Workers: 4}, PoolConfig{BatchSize: 100, Workers: 4}.WithDefaults())
+}
+
+func TestForEachEmailBoundsConcurrency(t *testing.T) {
+	emails := make([]outbox.Email, 20)
+	var running, peak, processed atomic.Int32
+
+	forEachEmail(context.TODO(), emails, 3, func(email outbox.Email) {
+		current := running.Add(1)
+		for {
+			p := peak.Load()
+			if current <= p || peak.CompareAndSwap(p, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		processed.Add(1)
+	})
+
+	assert.Equal(t, int32(20), processed.Load())
+	assert.LessOrEqual(t, peak.Load(), int32(3))
+}
+
+func TestForEachEmailStopsDispatchingWhenContextIsDone(t *testing.T) {
+	emails := make([]outbox.Email, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	var processed atomic.Int32
+
+	forEachEmail(ctx, emails, 1, func(email outbox.Email) {
+		processed.Add(1)
+		cancel()
+	})
+
+	assert.Equal(t, int32(1), processed.Load())
+}
@@ -7,20 +7,37 @@ import (
 	"sync"
 	"time"
 
+	"mailculator-processor/internal/metrics"
 	"mailculator-processor/internal/outbox"
 )
 
+// AmbiguousSendPolicy decides what happens to a stale PROCESSING email that has a send marker,
+// i.e. an email that may already have been accepted by SMTP before the process crashed.
+type AmbiguousSendPolicy string
+
+const (
+	// AmbiguousSendResend clears the send marker and restores the email to READY, accepting a possible duplicate.
+	AmbiguousSendResend AmbiguousSendPolicy = "resend"
+	// AmbiguousSendUncertain moves the email to the terminal SEND-UNCERTAIN status.
+	AmbiguousSendUncertain AmbiguousSendPolicy = "uncertain"
+	// AmbiguousSendOperator moves the email to QUARANTINED, waiting for an operator decision.
+	AmbiguousSendOperator AmbiguousSendPolicy = "operator"
+)
+
 type RestorePipeline struct {
 	outbox        outboxService
+	name          string
 	logger        *slog.Logger
 	startStatus   string
 	restoreStatus string
 	maxAge        time.Duration
+	sendPolicy    AmbiguousSendPolicy
 }
 
 func newRestorePipeline(outbox outboxService, name string, startStatus string, restoreStatus string, maxAge time.Duration) *RestorePipeline {
 	return &RestorePipeline{
 		outbox:        outbox,
+		name:          name,
 		logger:        slog.With("pipe", name),
 		startStatus:   startStatus,
 		restoreStatus: restoreStatus,
@@ -28,11 +45,11 @@ func newRestorePipeline(outbox outboxService, name string, startStatus string, r
 	}
 }
 
-func (p *RestorePipeline) Process(ctx context.Context) {
+func (p *RestorePipeline) Process(ctx context.Context) int {
 	staleList, err := p.outbox.QueryStale(ctx, p.startStatus, p.maxAge, 0)
 	if err != nil {
 		p.logger.Error(fmt.Sprintf("error while querying stale emails to restore: %v", err))
-		return
+		return 0
 	}
 
 	var wg sync.WaitGroup
@@ -43,25 +60,79 @@ func (p *RestorePipeline) Process(ctx context.Context) {
 			defer wg.Done()
 			p.logger.Info(fmt.Sprintf("restoring email %v", email.Id))
 			subLogger := p.logger.With("email", email.Id)
+			metrics.PipelineProcessed.Inc(p.name)
+
+			restoreStatus, reason, resolveErr := p.resolveRestoreStatus(ctx, email)
+			if resolveErr != nil {
+				subLogger.Error(fmt.Sprintf("failed to resolve restore status, error: %v", resolveErr))
+				metrics.PipelineFailed.Inc(p.name)
+				return
+			}
 
-			if err = p.outbox.UpdateFrom(ctx, email.Id, p.startStatus, p.restoreStatus, ""); err != nil {
+			if err = p.outbox.UpdateFrom(ctx, email.Id, p.startStatus, restoreStatus, reason); err != nil {
 				subLogger.Warn(fmt.Sprintf("failed to restore email status, error: %v", err))
+				metrics.PipelineLockConflicts.Inc(p.name)
+				return
+			}
+
+			if restoreStatus != p.restoreStatus {
+				subLogger.Warn(fmt.Sprintf("ambiguous send quarantined to %v", restoreStatus))
+				metrics.PipelineFailed.Inc(p.name)
 				return
 			}
 
 			subLogger.Info("successfully restored email status")
+			metrics.PipelineSucceeded.Inc(p.name)
 		}(e)
 	}
 
 	wg.Wait()
+
+	return len(staleList)
+}
+
+// resolveRestoreStatus returns the status a stale email must be moved to.
+// Emails with a send marker are handled according to the configured AmbiguousSendPolicy.
+func (p *RestorePipeline) resolveRestoreStatus(ctx context.Context, email outbox.Email) (string, string, error) {
+	if p.sendPolicy == "" {
+		return p.restoreStatus, "", nil
+	}
+
+	marked, err := p.outbox.HasSendMarker(ctx, email.Id)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !marked {
+		return p.restoreStatus, "", nil
+	}
+
+	const reason = "process stopped after SMTP hand-off, delivery unknown"
+
+	switch p.sendPolicy {
+	case AmbiguousSendResend:
+		if err := p.outbox.ClearSendMarker(ctx, email.Id); err != nil {
+			return "", "", err
+		}
+		return p.restoreStatus, "", nil
+	case AmbiguousSendUncertain:
+		return outbox.StatusSendUncertain, reason, nil
+	default:
+		return outbox.StatusQuarantined, reason, nil
+	}
 }
 
 func NewRestoreIntakingPipeline(ob outboxService, maxAge time.Duration) *RestorePipeline {
 	return newRestorePipeline(ob, "restore-intaking", outbox.StatusIntaking, outbox.StatusAccepted, maxAge)
 }
 
-func NewRestoreProcessingPipeline(ob outboxService, maxAge time.Duration) *RestorePipeline {
-	return newRestorePipeline(ob, "restore-processing", outbox.StatusProcessing, outbox.StatusReady, maxAge)
+func NewRestoreProcessingPipeline(ob outboxService, maxAge time.Duration, sendPolicy AmbiguousSendPolicy) *RestorePipeline {
+	p := newRestorePipeline(ob, "restore-processing", outbox.StatusProcessing, outbox.StatusReady, maxAge)
+	p.sendPolicy = sendPolicy
+	if p.sendPolicy == "" {
+		p.sendPolicy = AmbiguousSendOperator
+	}
+	return p
 }
 
 func NewRestoreCallingSentPipeline(ob outboxService, maxAge time.Duration) *RestorePipeline {
@@ -71,3 +142,19 @@ func NewRestoreCallingSentPipeline(ob outboxService, maxAge time.Duration) *Rest
 func NewRestoreCallingFailedPipeline(ob outboxService, maxAge time.Duration) *RestorePipeline {
 	return newRestorePipeline(ob, "restore-calling-failed", outbox.StatusCallingFailedCallback, outbox.StatusFailed, maxAge)
 }
+
+func NewRestoreCallingCancelledPipeline(ob outboxService, maxAge time.Duration) *RestorePipeline {
+	return newRestorePipeline(ob, "restore-calling-cancelled", outbox.StatusCallingCancelledCallback, outbox.StatusCancelled, maxAge)
+}
+
+func NewRestoreCallingInvalidPipeline(ob outboxService, maxAge time.Duration) *RestorePipeline {
+	return newRestorePipeline(ob, "restore-calling-invalid", outbox.StatusCallingInvalidCallback, outbox.StatusInvalid, maxAge)
+}
+
+func NewRestoreCallingSendUncertainPipeline(ob outboxService, maxAge time.Duration) *RestorePipeline {
+	return newRestorePipeline(ob, "restore-calling-send-uncertain", outbox.StatusCallingSendUncertainCallback, outbox.StatusSendUncertain, maxAge)
+}
+
+func NewRestoreCallingBouncedPipeline(ob outboxService, maxAge time.Duration) *RestorePipeline {
+	return newRestorePipeline(ob, "restore-calling-bounced", outbox.StatusCallingBouncedCallback, outbox.StatusBounced, maxAge)
+}
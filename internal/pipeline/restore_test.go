@@ -0,0 +1,115 @@
+//go:build unit
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/testutils/mocks"
+)
+
+func TestRestorePipeline(t *testing.T) {
+	buf, logger := mocks.NewLoggerMock()
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Status: outbox.StatusIntaking}))
+	restore := NewRestoreIntakingPipeline(outboxServiceMock, 0)
+	restore.logger = logger
+
+	restore.Process(context.TODO())
+
+	assert.Equal(t, outbox.StatusAccepted, outboxServiceMock.LastUpdateFromStatus())
+	assert.Equal(t,
+		"level=INFO msg=\"restoring email 1\"\nlevel=INFO msg=\"successfully restored email status\" email=1",
+		strings.TrimSpace(buf.String()),
+	)
+}
+
+func TestRestoreCallingInvalidPipeline(t *testing.T) {
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Status: outbox.StatusCallingInvalidCallback}))
+	restore := NewRestoreCallingInvalidPipeline(outboxServiceMock, 0)
+	_, restore.logger = mocks.NewLoggerMock()
+
+	restore.Process(context.TODO())
+
+	assert.Equal(t, outbox.StatusInvalid, outboxServiceMock.LastUpdateFromStatus())
+}
+
+func TestRestoreCallingBouncedPipeline(t *testing.T) {
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Status: outbox.StatusCallingBouncedCallback}))
+	restore := NewRestoreCallingBouncedPipeline(outboxServiceMock, 0)
+	_, restore.logger = mocks.NewLoggerMock()
+
+	restore.Process(context.TODO())
+
+	assert.Equal(t, outbox.StatusBounced, outboxServiceMock.LastUpdateFromStatus())
+}
+
+func TestRestoreCallingSendUncertainPipeline(t *testing.T) {
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Status: outbox.StatusCallingSendUncertainCallback}))
+	restore := NewRestoreCallingSendUncertainPipeline(outboxServiceMock, 0)
+	_, restore.logger = mocks.NewLoggerMock()
+
+	restore.Process(context.TODO())
+
+	assert.Equal(t, outbox.StatusSendUncertain, outboxServiceMock.LastUpdateFromStatus())
+}
+
+func TestRestoreProcessingWithoutSendMarker(t *testing.T) {
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: "1", Status: outbox.StatusProcessing}),
+		mocks.HasSendMarker(false),
+	)
+	restore := NewRestoreProcessingPipeline(outboxServiceMock, 0, AmbiguousSendUncertain)
+	_, restore.logger = mocks.NewLoggerMock()
+
+	restore.Process(context.TODO())
+
+	assert.Equal(t, outbox.StatusReady, outboxServiceMock.LastUpdateFromStatus())
+	assert.Equal(t, 0, outboxServiceMock.ClearSendMarkerCalls())
+}
+
+// TestAmbiguousSendAfterCrash injects a crash between a successful SMTP send and the SENT update,
+// then checks that the restore pipeline applies the configured policy to the stranded PROCESSING email.
+func TestAmbiguousSendAfterCrash(t *testing.T) {
+	testCases := []struct {
+		policy         AmbiguousSendPolicy
+		expectedStatus string
+		expectedClears int
+	}{
+		{AmbiguousSendResend, outbox.StatusReady, 1},
+		{AmbiguousSendUncertain, outbox.StatusSendUncertain, 0},
+		{AmbiguousSendOperator, outbox.StatusQuarantined, 0},
+		{"", outbox.StatusQuarantined, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			payloadFile := createPayloadFile(t)
+			outboxServiceMock := mocks.NewOutboxMock(
+				mocks.Email(outbox.Email{Id: "1", Status: outbox.StatusProcessing, PayloadFilePath: payloadFile}),
+				mocks.UpdateMethodError(errors.New("process killed")),
+				mocks.UpdateMethodFailsCall(2),
+				mocks.HasSendMarker(true),
+			)
+			senderServiceMock := newSenderMock(nil)
+			sender := NewMainSenderPipeline(outboxServiceMock, senderServiceMock, "/base/path/", PoolConfig{}, EnvelopeSenderConfig{})
+			_, sender.logger = mocks.NewLoggerMock()
+
+			sender.Process(context.TODO())
+			assert.Equal(t, 1, senderServiceMock.sendMethodCounter)
+
+			restore := NewRestoreProcessingPipeline(outboxServiceMock, 0, tc.policy)
+			_, restore.logger = mocks.NewLoggerMock()
+
+			restore.Process(context.TODO())
+
+			assert.Equal(t, tc.expectedStatus, outboxServiceMock.LastUpdateFromStatus())
+			assert.Equal(t, tc.expectedClears, outboxServiceMock.ClearSendMarkerCalls())
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package pipeline
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"mailculator-processor/internal/failure"
+	"mailculator-processor/internal/outbox"
+)
+
+type RetentionConfig struct {
+	// Periods maps a terminal status to the time its emails are kept after the last update
+	Periods map[string]time.Duration
+	// BatchSize is the maximum number of emails removed per status on each run
+	BatchSize int
+	// ArchivePath is the directory receiving compressed JSONL archives; empty means delete without archiving
+	ArchivePath string
+	// DeleteFiles removes the payload file together with the rows. Attachments are left in place,
+	// they can be shared by other emails
+	DeleteFiles bool
+	// MoveToColdTables moves the rows to emails_archive and email_statuses_archive instead of deleting them
+	MoveToColdTables bool
+}
+
+type archiveRecord struct {
+	Id              string                `json:"id"`
+	Status          string                `json:"status"`
+	PayloadFilePath string                `json:"payload_file_path"`
+	Reason          string                `json:"reason"`
+	Failure         *failure.Reason       `json:"failure,omitempty"`
+	UpdatedAt       string                `json:"updated_at"`
+	History         []archiveStatusChange `json:"history"`
+}
+
+type archiveStatusChange struct {
+	Status    string          `json:"status"`
+	Reason    string          `json:"reason"`
+	Failure   *failure.Reason `json:"failure,omitempty"`
+	CreatedAt string          `json:"created_at"`
+}
+
+type RetentionPipeline struct {
+	outbox outboxService
+	cfg    RetentionConfig
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+func NewRetentionPipeline(ob outboxService, cfg RetentionConfig) *RetentionPipeline {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+
+	return &RetentionPipeline{
+		outbox: ob,
+		cfg:    cfg,
+		logger: slog.With("pipe", "retention"),
+		now:    time.Now,
+	}
+}
+
+func (p *RetentionPipeline) Process(ctx context.Context) int {
+	statuses := make([]string, 0, len(p.cfg.Periods))
+	for status := range p.cfg.Periods {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	handled := 0
+	for _, status := range statuses {
+		count, err := p.processStatus(ctx, status, p.cfg.Periods[status])
+		if err != nil {
+			p.logger.Error(fmt.Sprintf("error while applying retention to %v: %v", status, err))
+		}
+		handled += count
+	}
+
+	return handled
+}
+
+// processStatus returns the number of expired emails removed from the hot table.
+// Only the emails actually removed, i.e. still in status when the removal runs, are archived and have their files deleted.
+func (p *RetentionPipeline) processStatus(ctx context.Context, status string, retention time.Duration) (int, error) {
+	expiredList, err := p.outbox.QueryStale(ctx, status, retention, p.cfg.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("error while querying expired emails: %w", err)
+	}
+
+	if len(expiredList) == 0 {
+		return 0, nil
+	}
+
+	// The archive is written before the rows are removed, so that a failure never loses data
+	var records []archiveRecord
+	var archiveFile string
+	if p.cfg.ArchivePath != "" {
+		records, err = p.archiveRecords(ctx, expiredList)
+		if err != nil {
+			return 0, fmt.Errorf("error while archiving emails: %w", err)
+		}
+
+		archiveFile, err = p.archive(status, records)
+		if err != nil {
+			return 0, fmt.Errorf("error while archiving emails: %w", err)
+		}
+	}
+
+	ids := make([]string, 0, len(expiredList))
+	for _, e := range expiredList {
+		ids = append(ids, e.Id)
+	}
+
+	var removedIds []string
+	if p.cfg.MoveToColdTables {
+		removedIds, err = p.outbox.MoveToArchive(ctx, status, ids)
+		if err != nil {
+			p.discardArchive(archiveFile)
+			return 0, fmt.Errorf("error while moving emails to cold tables: %w", err)
+		}
+
+		p.logger.Info(fmt.Sprintf("moved %d emails in status %v to cold tables", len(removedIds), status))
+	} else {
+		removedIds, err = p.outbox.Purge(ctx, status, ids)
+		if err != nil {
+			p.discardArchive(archiveFile)
+			return 0, fmt.Errorf("error while purging emails: %w", err)
+		}
+
+		p.logger.Info(fmt.Sprintf("purged %d emails in status %v", len(removedIds), status))
+	}
+
+	removed := make(map[string]bool, len(removedIds))
+	for _, id := range removedIds {
+		removed[id] = true
+	}
+
+	if archiveFile != "" {
+		p.finalizeArchive(archiveFile, records, removed)
+	}
+
+	if p.cfg.DeleteFiles {
+		for _, e := range expiredList {
+			if removed[e.Id] {
+				p.deleteFiles(e)
+			}
+		}
+	}
+
+	return len(removedIds), nil
+}
+
+// finalizeArchive drops from the archive the emails left in the hot table because their status changed.
+func (p *RetentionPipeline) finalizeArchive(archiveFile string, records []archiveRecord, removed map[string]bool) {
+	kept := make([]archiveRecord, 0, len(removed))
+	for _, r := range records {
+		if removed[r.Id] {
+			kept = append(kept, r)
+		}
+	}
+
+	if len(kept) == 0 {
+		p.discardArchive(archiveFile)
+		return
+	}
+
+	if len(kept) < len(records) {
+		if err := p.writeArchiveFile(archiveFile, kept); err != nil {
+			p.logger.Warn(fmt.Sprintf("archive %v also lists %d emails still in the hot table: %v", archiveFile, len(records)-len(kept), err))
+		}
+	}
+
+	p.logger.Info(fmt.Sprintf("archived %d emails to %v", len(kept), archiveFile))
+}
+
+func (p *RetentionPipeline) discardArchive(archiveFile string) {
+	if archiveFile == "" {
+		return
+	}
+
+	if err := os.Remove(archiveFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		p.logger.Warn(fmt.Sprintf("failed to remove archive %v: %v", archiveFile, err))
+	}
+}
+
+// archiveRecords loads the history of the emails to archive.
+func (p *RetentionPipeline) archiveRecords(ctx context.Context, emails []outbox.Email) ([]archiveRecord, error) {
+	records := make([]archiveRecord, 0, len(emails))
+	for _, e := range emails {
+		history, err := p.outbox.History(ctx, e.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		record := archiveRecord{
+			Id:              e.Id,
+			Status:          e.Status,
+			PayloadFilePath: e.PayloadFilePath,
+			Reason:          e.Reason,
+			Failure:         e.Failure,
+			UpdatedAt:       e.UpdatedAt,
+			History:         make([]archiveStatusChange, 0, len(history)),
+		}
+		for _, c := range history {
+			record.History = append(record.History, archiveStatusChange{Status: c.Status, Reason: c.Reason, Failure: c.Failure, CreatedAt: c.CreatedAt})
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// archive writes the records to a new gzip compressed JSONL file and returns its path.
+func (p *RetentionPipeline) archive(status string, records []archiveRecord) (string, error) {
+	if err := os.MkdirAll(p.cfg.ArchivePath, 0o755); err != nil {
+		return "", err
+	}
+
+	fileName := fmt.Sprintf("%s-%s.jsonl.gz", strings.ToLower(status), p.now().UTC().Format("20060102T150405.000000000"))
+	archiveFile := filepath.Join(p.cfg.ArchivePath, fileName)
+
+	if err := p.writeArchiveFile(archiveFile, records); err != nil {
+		return "", err
+	}
+
+	return archiveFile, nil
+}
+
+// writeArchiveFile writes the records to a temporary file renamed to archiveFile once complete,
+// so that archiveFile is either replaced as a whole or left untouched.
+func (p *RetentionPipeline) writeArchiveFile(archiveFile string, records []archiveRecord) error {
+	tmpFile := archiveFile + ".tmp"
+
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+
+	writeErr := writeArchive(f, records)
+	closeErr := f.Close()
+
+	if err := errors.Join(writeErr, closeErr); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+
+	if err := os.Rename(tmpFile, archiveFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+
+	return nil
+}
+
+func writeArchive(f *os.File, records []archiveRecord) error {
+	gz := gzip.NewWriter(f)
+	encoder := json.NewEncoder(gz)
+
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			_ = gz.Close()
+			return err
+		}
+	}
+
+	return gz.Close()
+}
+
+// deleteFiles removes the payload file only: the attachments are referenced by path and the same file
+// can be attached to other emails, still in the hot tables.
+func (p *RetentionPipeline) deleteFiles(e outbox.Email) {
+	if e.PayloadFilePath == "" {
+		return
+	}
+
+	if err := os.Remove(e.PayloadFilePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		p.logger.With("email", e.Id).Warn(fmt.Sprintf("failed to remove file %v: %v", e.PayloadFilePath, err))
+	}
+}
@@ -0,0 +1,204 @@
+//go:build unit
+
+package pipeline
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/testutils/mocks"
+)
+
+func TestRetentionPurgesWithoutArchive(t *testing.T) {
+	buf, logger := mocks.NewLoggerMock()
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Status: outbox.StatusSentAcknowledged}))
+	retention := NewRetentionPipeline(outboxServiceMock, RetentionConfig{
+		Periods: map[string]time.Duration{outbox.StatusSentAcknowledged: time.Hour},
+	})
+	retention.logger = logger
+
+	retention.Process(context.TODO())
+
+	assert.Equal(t, []string{"1"}, outboxServiceMock.PurgedIds())
+	assert.Equal(t, "level=INFO msg=\"purged 1 emails in status SENT-ACKNOWLEDGED\"", strings.TrimSpace(buf.String()))
+}
+
+func TestRetentionArchivesAndDeletesFiles(t *testing.T) {
+	payloadFile := createPayloadFile(t)
+	archivePath := t.TempDir()
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{
+		Id:              "1",
+		Status:          outbox.StatusFailedAcknowledged,
+		PayloadFilePath: payloadFile,
+		Reason:          "some send error",
+	}))
+	retention := NewRetentionPipeline(outboxServiceMock, RetentionConfig{
+		Periods:     map[string]time.Duration{outbox.StatusFailedAcknowledged: time.Hour},
+		ArchivePath: archivePath,
+		DeleteFiles: true,
+	})
+	_, retention.logger = mocks.NewLoggerMock()
+
+	retention.Process(context.TODO())
+
+	assert.Equal(t, []string{"1"}, outboxServiceMock.PurgedIds())
+	assert.NoFileExists(t, payloadFile)
+
+	archives, err := filepath.Glob(filepath.Join(archivePath, "failed-acknowledged-*.jsonl.gz"))
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+
+	f, err := os.Open(archives[0])
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	scanner := bufio.NewScanner(gz)
+	require.True(t, scanner.Scan())
+	var record archiveRecord
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+	assert.Equal(t, "1", record.Id)
+	assert.Equal(t, "some send error", record.Reason)
+	assert.Len(t, record.History, 1)
+	assert.False(t, scanner.Scan())
+}
+
+func TestRetentionKeepsAttachmentsSharedWithOtherEmails(t *testing.T) {
+	dir := t.TempDir()
+	attachment := filepath.Join(dir, "shared.pdf")
+	require.NoError(t, os.WriteFile(attachment, []byte("%PDF-1.4"), 0o644))
+	payloadFile := filepath.Join(dir, "payload.json")
+	require.NoError(t, os.WriteFile(payloadFile, []byte(`{"id":"1","attachments":["`+attachment+`"]}`), 0o644))
+
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{
+		Id:              "1",
+		Status:          outbox.StatusSentAcknowledged,
+		PayloadFilePath: payloadFile,
+	}))
+	retention := NewRetentionPipeline(outboxServiceMock, RetentionConfig{
+		Periods:     map[string]time.Duration{outbox.StatusSentAcknowledged: time.Hour},
+		DeleteFiles: true,
+	})
+	_, retention.logger = mocks.NewLoggerMock()
+
+	retention.Process(context.TODO())
+
+	assert.NoFileExists(t, payloadFile)
+	assert.FileExists(t, attachment)
+}
+
+func TestRetentionMovesToColdTables(t *testing.T) {
+	buf, logger := mocks.NewLoggerMock()
+	outboxServiceMock := mocks.NewOutboxMock(mocks.Email(outbox.Email{Id: "1", Status: outbox.StatusSentAcknowledged}))
+	retention := NewRetentionPipeline(outboxServiceMock, RetentionConfig{
+		Periods:          map[string]time.Duration{outbox.StatusSentAcknowledged: time.Hour},
+		MoveToColdTables: true,
+	})
+	retention.logger = logger
+
+	retention.Process(context.TODO())
+
+	assert.Equal(t, []string{"1"}, outboxServiceMock.MovedIds())
+	assert.Empty(t, outboxServiceMock.PurgedIds())
+	assert.Equal(t, "level=INFO msg=\"moved 1 emails in status SENT-ACKNOWLEDGED to cold tables\"", strings.TrimSpace(buf.String()))
+}
+
+func TestRetentionPurgeError(t *testing.T) {
+	buf, logger := mocks.NewLoggerMock()
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: "1", Status: outbox.StatusInvalidAcknowledged}),
+		mocks.PurgeMethodError(errors.New("some purge error")),
+	)
+	retention := NewRetentionPipeline(outboxServiceMock, RetentionConfig{
+		Periods: map[string]time.Duration{outbox.StatusInvalidAcknowledged: time.Hour},
+	})
+	retention.logger = logger
+
+	retention.Process(context.TODO())
+
+	assert.Equal(t,
+		"level=ERROR msg=\"error while applying retention to INVALID-ACKNOWLEDGED: error while purging emails: some purge error\"",
+		strings.TrimSpace(buf.String()),
+	)
+}
+
+func TestRetentionKeepsFilesAndArchiveOfEmailsWhoseStatusChanged(t *testing.T) {
+	removedPayload := createPayloadFile(t)
+	changedPayload := createPayloadFile(t)
+	archivePath := t.TempDir()
+	buf, logger := mocks.NewLoggerMock()
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Emails(
+			outbox.Email{Id: "1", Status: outbox.StatusFailedAcknowledged, PayloadFilePath: removedPayload},
+			outbox.Email{Id: "2", Status: outbox.StatusFailedAcknowledged, PayloadFilePath: changedPayload},
+		),
+		mocks.ChangedStatus("2"),
+	)
+	retention := NewRetentionPipeline(outboxServiceMock, RetentionConfig{
+		Periods:     map[string]time.Duration{outbox.StatusFailedAcknowledged: time.Hour},
+		ArchivePath: archivePath,
+		DeleteFiles: true,
+	})
+	retention.logger = logger
+
+	handled := retention.Process(context.TODO())
+
+	assert.Equal(t, 1, handled)
+	assert.Equal(t, []string{"1"}, outboxServiceMock.PurgedIds())
+	assert.NoFileExists(t, removedPayload)
+	assert.FileExists(t, changedPayload)
+	assert.Contains(t, buf.String(), "purged 1 emails in status FAILED-ACKNOWLEDGED")
+
+	archives, err := filepath.Glob(filepath.Join(archivePath, "*"))
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+
+	f, err := os.Open(archives[0])
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	scanner := bufio.NewScanner(gz)
+	require.True(t, scanner.Scan())
+	var record archiveRecord
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+	assert.Equal(t, "1", record.Id)
+	assert.False(t, scanner.Scan())
+}
+
+func TestRetentionDiscardsArchiveWhenNoEmailIsRemoved(t *testing.T) {
+	archivePath := t.TempDir()
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: "1", Status: outbox.StatusSentAcknowledged}),
+		mocks.ChangedStatus("1"),
+	)
+	retention := NewRetentionPipeline(outboxServiceMock, RetentionConfig{
+		Periods:          map[string]time.Duration{outbox.StatusSentAcknowledged: time.Hour},
+		ArchivePath:      archivePath,
+		MoveToColdTables: true,
+	})
+	_, retention.logger = mocks.NewLoggerMock()
+
+	handled := retention.Process(context.TODO())
+
+	assert.Equal(t, 0, handled)
+	assert.Empty(t, outboxServiceMock.MovedIds())
+
+	archives, err := filepath.Glob(filepath.Join(archivePath, "*"))
+	require.NoError(t, err)
+	assert.Empty(t, archives)
+}
@@ -5,79 +5,187 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/mail"
 	"net/textproto"
-	"sync"
+	"strconv"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
+	"mailculator-processor/internal/bounce"
 	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/failure"
+	"mailculator-processor/internal/metrics"
 	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/smtp"
+	"mailculator-processor/internal/tracing"
+)
+
+const (
+	// EnvelopeSenderFixed hands every email over with the same envelope sender
+	EnvelopeSenderFixed = "fixed"
+	// EnvelopeSenderPayload hands each email over with the From of its payload
+	EnvelopeSenderPayload = "payload"
+	// EnvelopeSenderVERP hands each email over with a VERP address carrying its id, bounces+<email id>@example.com
+	EnvelopeSenderVERP = "verp"
 )
 
 type clientService interface {
-	Send(payload email.Payload, attachmentsBasePath string) error
+	// Send hands the payload over to SMTP, returnPath is the envelope sender (MAIL FROM), empty for the relay default
+	Send(ctx context.Context, payload email.Payload, returnPath string, attachmentsBasePath string) error
+}
+
+// EnvelopeSenderConfig chooses the envelope sender (MAIL FROM) of the emails, where the bounces are returned.
+type EnvelopeSenderConfig struct {
+	// Strategy is EnvelopeSenderFixed, EnvelopeSenderPayload or EnvelopeSenderVERP, empty means fixed
+	Strategy string
+	// Address is the fixed envelope sender, or the VERP base address: bounces@example.com.
+	// An empty fixed address leaves the envelope sender to the SMTP client
+	Address string
+}
+
+// returnPath returns the bare envelope sender address an email is handed over with.
+func (c EnvelopeSenderConfig) returnPath(emailId string, payload email.Payload) (string, error) {
+	switch c.Strategy {
+	case EnvelopeSenderPayload:
+		return envelopeAddress(payload.From)
+	case EnvelopeSenderVERP:
+		return bounce.VERPAddress(c.Address, emailId), nil
+	default:
+		return envelopeAddress(c.Address)
+	}
+}
+
+// envelopeAddress strips the display name of an address, "Sender <sender@example.com>" becomes sender@example.com.
+func envelopeAddress(address string) (string, error) {
+	if address == "" {
+		return "", nil
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid envelope sender %q: %w", address, err)
+	}
+	return parsed.Address, nil
 }
 
 type MainSenderPipeline struct {
 	outbox              outboxService
 	client              clientService
 	attachmentsBasePath string
+	pool                PoolConfig
+	envelope            EnvelopeSenderConfig
+	name                string
 	logger              *slog.Logger
 }
 
-func NewMainSenderPipeline(outbox outboxService, client clientService, attachmentsBasePath string) *MainSenderPipeline {
+func NewMainSenderPipeline(outbox outboxService, client clientService, attachmentsBasePath string, pool PoolConfig, envelope EnvelopeSenderConfig) *MainSenderPipeline {
 	return &MainSenderPipeline{
 		outbox:              outbox,
 		client:              client,
 		attachmentsBasePath: attachmentsBasePath,
+		pool:                pool,
+		envelope:            envelope,
+		name:                "main",
 		logger:              slog.With("pipe", "main"),
 	}
 }
 
-func (p *MainSenderPipeline) Process(ctx context.Context) {
-	readyList, err := p.outbox.Query(ctx, outbox.StatusReady, 25)
+func (p *MainSenderPipeline) Process(ctx context.Context) int {
+	pool := p.pool.WithDefaults()
+	readyList, err := p.outbox.Query(ctx, outbox.StatusReady, pool.BatchSize)
 	if err != nil {
 		p.logger.Error(fmt.Sprintf("error while querying emails to process: %v", err))
-		return
+		return 0
 	}
 
-	var wg sync.WaitGroup
+	forEachEmail(ctx, readyList, pool.Workers, func(outboxEmail outbox.Email) {
+		p.logger.Info(fmt.Sprintf("processing outbox %v", outboxEmail.Id))
+		logger := p.logger.With("outbox", outboxEmail.Id)
+		metrics.PipelineProcessed.Inc(p.name)
+
+		ctx, span := tracing.Start(ctx, "sender.process", attribute.String("email.id", outboxEmail.Id))
+		defer span.End()
+
+		if err = p.outbox.Update(ctx, outboxEmail.Id, outbox.StatusProcessing, ""); err != nil {
+			logger.Warn(fmt.Sprintf("failed to acquire processing lock, error: %v", err))
+			metrics.PipelineLockConflicts.Inc(p.name)
+			return
+		}
 
-	for _, e := range readyList {
-		wg.Add(1)
-		go func(outboxEmail outbox.Email) {
-			defer wg.Done()
-			p.logger.Info(fmt.Sprintf("processing outbox %v", outboxEmail.Id))
-			logger := p.logger.With("outbox", outboxEmail.Id)
+		payload, payloadErr := email.LoadPayload(ctx, outboxEmail.PayloadFilePath)
+		if payloadErr != nil {
+			logger.Error(fmt.Sprintf("failed to load payload, error: %v", payloadErr))
+			metrics.PipelineFailed.Inc(p.name)
+			p.fail(context.WithoutCancel(ctx), logger, outboxEmail.Id, payloadErr)
+			return
+		}
 
-			if err = p.outbox.Update(ctx, outboxEmail.Id, outbox.StatusProcessing, ""); err != nil {
-				logger.Warn(fmt.Sprintf("failed to acquire processing lock, error: %v", err))
+		// links the send to the trace of the producer that submitted the email
+		tracing.LinkTraceParent(span, payload.TraceParent)
+
+		returnPath, returnPathErr := p.envelope.returnPath(outboxEmail.Id, payload)
+		if returnPathErr != nil {
+			logger.Error(fmt.Sprintf("failed to choose the envelope sender, error: %v", returnPathErr))
+			metrics.PipelineFailed.Inc(p.name)
+			p.fail(context.WithoutCancel(ctx), logger, outboxEmail.Id, returnPathErr)
+			return
+		}
+
+		if markErr := p.outbox.MarkSending(context.WithoutCancel(ctx), outboxEmail.Id, payload.Id, returnPath); markErr != nil {
+			if errors.Is(markErr, outbox.ErrCancelled) {
+				logger.Warn("email cancelled before SMTP hand-off, not sending")
 				return
 			}
-
-			payload, payloadErr := email.LoadPayload(outboxEmail.PayloadFilePath)
-			if payloadErr != nil {
-				logger.Error(fmt.Sprintf("failed to load payload, error: %v", payloadErr))
-				p.handle(context.Background(), logger, outboxEmail.Id, outbox.StatusFailed, payloadErr.Error())
+			if errors.Is(markErr, outbox.ErrDuplicateSend) {
+				logger.Error(fmt.Sprintf("refusing to send, idempotency key %v already used", payload.Id))
+				metrics.PipelineFailed.Inc(p.name)
+				p.fail(context.WithoutCancel(ctx), logger, outboxEmail.Id, markErr)
 				return
 			}
+			logger.Error(fmt.Sprintf("failed to mark email as sending, restoring to READY: %v", markErr))
+			if restoreErr := p.outbox.UpdateFrom(context.WithoutCancel(ctx), outboxEmail.Id, outbox.StatusProcessing, outbox.StatusReady, ""); restoreErr != nil {
+				logger.Error(fmt.Sprintf("error restoring email to READY, error: %v", restoreErr))
+			}
+			return
+		}
+
+		sendStart := time.Now()
+		err = p.client.Send(context.WithoutCancel(ctx), payload, returnPath, p.attachmentsBasePath)
+		metrics.SMTPSendDuration.Observe(metrics.Since(sendStart))
+		metrics.SMTPReplies.Inc(smtpReplyCode(err))
+
+		switch sendOutcomeOf(err) {
+		case sendDelivered:
+			if err != nil {
+				logger.Warn(fmt.Sprintf("message accepted, ignoring error on QUIT: %v", err))
+			}
+			logger.Info("successfully sent")
+			metrics.PipelineSucceeded.Inc(p.name)
+			p.handle(context.WithoutCancel(ctx), logger, outboxEmail.Id, outbox.StatusSent, "")
+		case sendAmbiguous:
+			// the relay may have accepted the message: the send marker stays and the restore pipeline applies the ambiguous send policy
+			logger.Error(fmt.Sprintf("send outcome unknown, leaving email in %v, error: %v", outbox.StatusProcessing, err))
+			metrics.PipelineFailed.Inc(p.name)
+		default:
+			// SMTP did not accept the message, so the send marker must not block a later attempt
+			if clearErr := p.outbox.ClearSendMarker(context.WithoutCancel(ctx), outboxEmail.Id); clearErr != nil {
+				logger.Error(fmt.Sprintf("error clearing send marker, error: %v", clearErr))
+			}
 
-			if err = p.client.Send(payload, p.attachmentsBasePath); err != nil {
-				if isSMTPThrottling(err) {
-					logger.Warn(fmt.Sprintf("smtp throttling, restoring to READY: %v", err))
-					if restoreErr := p.outbox.UpdateFrom(context.Background(), outboxEmail.Id, outbox.StatusProcessing, outbox.StatusReady, ""); restoreErr != nil {
-						logger.Error(fmt.Sprintf("error restoring email to READY, error: %v", restoreErr))
-					}
-				} else {
-					logger.Error(fmt.Sprintf("failed to send, error: %v", err))
-					p.handle(context.Background(), logger, outboxEmail.Id, outbox.StatusFailed, err.Error())
+			if isSMTPThrottling(err) {
+				logger.Warn(fmt.Sprintf("smtp throttling, restoring to READY: %v", err))
+				if restoreErr := p.outbox.UpdateFrom(context.WithoutCancel(ctx), outboxEmail.Id, outbox.StatusProcessing, outbox.StatusReady, ""); restoreErr != nil {
+					logger.Error(fmt.Sprintf("error restoring email to READY, error: %v", restoreErr))
 				}
 			} else {
-				logger.Info("successfully sent")
-				p.handle(context.Background(), logger, outboxEmail.Id, outbox.StatusSent, "")
+				logger.Error(fmt.Sprintf("failed to send, error: %v", err))
+				metrics.PipelineFailed.Inc(p.name)
+				p.fail(context.WithoutCancel(ctx), logger, outboxEmail.Id, err)
 			}
-		}(e)
-	}
+		}
+	})
 
-	wg.Wait()
+	return len(readyList)
 }
 
 func (p *MainSenderPipeline) handle(ctx context.Context, logger *slog.Logger, emailId string, status string, errorReason string) {
@@ -87,6 +195,66 @@ func (p *MainSenderPipeline) handle(ctx context.Context, logger *slog.Logger, em
 	}
 }
 
+// fail moves the email to FAILED with the classified cause, the failed callback forwards it to the producer.
+func (p *MainSenderPipeline) fail(ctx context.Context, logger *slog.Logger, emailId string, cause error) {
+	if err := p.outbox.Fail(ctx, emailId, outbox.StatusFailed, failure.Classify(cause)); err != nil {
+		logger.Error(fmt.Sprintf("error updating status to %v, error: %v", outbox.StatusFailed, err))
+	}
+}
+
+// smtpReplyCode returns the reply code of a send: 250 when the message was accepted,
+// the SMTP code of the error otherwise, or "none" when the server gave no reply code.
+func smtpReplyCode(err error) string {
+	if err == nil {
+		return "250"
+	}
+
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return strconv.Itoa(smtpErr.Code)
+	}
+
+	return "none"
+}
+
+type sendOutcome int
+
+const (
+	// sendRejected means the relay did not take the message, it can be sent again
+	sendRejected sendOutcome = iota
+	// sendDelivered means the relay accepted the message
+	sendDelivered
+	// sendAmbiguous means the relay may or may not have accepted the message
+	sendAmbiguous
+)
+
+// sendOutcomeOf tells whether the relay took the message from the step of the SMTP conversation that failed.
+// Errors before DATA and replies rejecting DATA are rejections, an error on QUIT comes after the message
+// was accepted, and any other DATA error (a dropped connection, a timeout waiting for the final reply) is ambiguous.
+func sendOutcomeOf(err error) sendOutcome {
+	if err == nil {
+		return sendDelivered
+	}
+
+	var commandErr *smtp.CommandError
+	if !errors.As(err, &commandErr) {
+		return sendRejected
+	}
+
+	switch commandErr.Command {
+	case "QUIT":
+		return sendDelivered
+	case "DATA":
+		var smtpErr *textproto.Error
+		if errors.As(err, &smtpErr) {
+			return sendRejected
+		}
+		return sendAmbiguous
+	default:
+		return sendRejected
+	}
+}
+
 func isSMTPThrottling(err error) bool {
 	if err == nil {
 		return false
@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/textproto"
 	"os"
 	"strings"
@@ -15,20 +16,25 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/failure"
+	"mailculator-processor/internal/metrics"
 	"mailculator-processor/internal/outbox"
+	"mailculator-processor/internal/smtp"
 	"mailculator-processor/internal/testutils/mocks"
 )
 
 type senderMock struct {
 	sendMethodError   error
 	sendMethodCounter int
+	returnPath        string
 }
 
 func newSenderMock(sendMethodError error) *senderMock {
 	return &senderMock{sendMethodError: sendMethodError, sendMethodCounter: 0}
 }
 
-func (m *senderMock) Send(payload email.Payload, attachmentsBasePath string) error {
+func (m *senderMock) Send(_ context.Context, payload email.Payload, returnPath string, attachmentsBasePath string) error {
+	m.returnPath = returnPath
 	if m.sendMethodError == nil {
 		m.sendMethodCounter++
 	}
@@ -68,13 +74,71 @@ func TestSucceededSendEmails(t *testing.T) {
 	)
 	senderServiceMock := newSenderMock(nil)
 	buf, logger := mocks.NewLoggerMock()
-	sender := NewMainSenderPipeline(outboxServiceMock, senderServiceMock, "/base/path/")
+	sender := NewMainSenderPipeline(outboxServiceMock, senderServiceMock, "/base/path/", PoolConfig{}, EnvelopeSenderConfig{})
 	sender.logger = logger
 	sender.Process(context.TODO())
 	assert.Equal(t, 1, senderServiceMock.sendMethodCounter)
 	assert.Equal(t, "level=INFO msg=\"processing outbox 1\"\nlevel=INFO msg=\"successfully sent\" outbox=1", strings.TrimSpace(buf.String()))
 }
 
+func TestSendEmailWithVERPReturnPath(t *testing.T) {
+	payloadFile := createPayloadFile(t)
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: "1", Status: "", PayloadFilePath: payloadFile}),
+	)
+	senderServiceMock := newSenderMock(nil)
+	sender := NewMainSenderPipeline(outboxServiceMock, senderServiceMock, "/base/path/", PoolConfig{},
+		EnvelopeSenderConfig{Strategy: EnvelopeSenderVERP, Address: "bounces@example.com"})
+	_, sender.logger = mocks.NewLoggerMock()
+
+	sender.Process(context.TODO())
+
+	assert.Equal(t, "bounces+1@example.com", senderServiceMock.returnPath)
+	assert.Equal(t, map[string]string{"bounces+1@example.com": "1"}, outboxServiceMock.ReturnPaths())
+}
+
+func TestSendEmailInvalidReturnPath(t *testing.T) {
+	payloadFile := createPayloadFile(t)
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: "1", Status: "", PayloadFilePath: payloadFile}),
+	)
+	senderServiceMock := newSenderMock(nil)
+	sender := NewMainSenderPipeline(outboxServiceMock, senderServiceMock, "/base/path/", PoolConfig{},
+		EnvelopeSenderConfig{Strategy: EnvelopeSenderFixed, Address: "not an address"})
+	_, sender.logger = mocks.NewLoggerMock()
+
+	sender.Process(context.TODO())
+
+	assert.Equal(t, 0, senderServiceMock.sendMethodCounter)
+	assert.Equal(t, "fail", outboxServiceMock.LastMethod())
+	assert.Empty(t, outboxServiceMock.ReturnPaths())
+}
+
+func TestEnvelopeSenderReturnPath(t *testing.T) {
+	payload := email.Payload{From: "Sender <sender@example.com>"}
+
+	tests := []struct {
+		name     string
+		config   EnvelopeSenderConfig
+		expected string
+	}{
+		{"fixed", EnvelopeSenderConfig{Strategy: EnvelopeSenderFixed, Address: "Relay <relay@example.com>"}, "relay@example.com"},
+		{"default is fixed", EnvelopeSenderConfig{Address: "relay@example.com"}, "relay@example.com"},
+		{"fixed without address", EnvelopeSenderConfig{Strategy: EnvelopeSenderFixed}, ""},
+		{"payload", EnvelopeSenderConfig{Strategy: EnvelopeSenderPayload}, "sender@example.com"},
+		{"verp", EnvelopeSenderConfig{Strategy: EnvelopeSenderVERP, Address: "bounces@example.com"}, "bounces+email-1@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returnPath, err := tt.config.returnPath("email-1", payload)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, returnPath)
+		})
+	}
+}
+
 func TestQueryEmailError(t *testing.T) {
 	buf, logger := mocks.NewLoggerMock()
 	outboxServiceMock := mocks.NewOutboxMock(mocks.QueryMethodError(errors.New("some query error")))
@@ -124,6 +188,26 @@ func TestSendEmailError(t *testing.T) {
 	)
 }
 
+func TestSendEmailFailureIsClassified(t *testing.T) {
+	payloadFile := createPayloadFile(t)
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: "1", Status: "", PayloadFilePath: payloadFile}),
+	)
+	senderServiceMock := newSenderMock(&textproto.Error{Code: 550, Msg: "5.1.1 User unknown"})
+	sender := MainSenderPipeline{outbox: outboxServiceMock, client: senderServiceMock, attachmentsBasePath: "/base/path/"}
+	_, sender.logger = mocks.NewLoggerMock()
+
+	sender.Process(context.TODO())
+
+	assert.Equal(t, outbox.StatusFailed, outboxServiceMock.LastStatuses()["1"])
+	assert.Equal(t, &failure.Reason{
+		Category:     failure.CategorySMTPPermanent,
+		SMTPCode:     550,
+		EnhancedCode: "5.1.1",
+		Message:      "550 5.1.1 User unknown",
+	}, outboxServiceMock.Failure())
+}
+
 func TestSendEmailThrottlingRestore(t *testing.T) {
 	payloadFile := createPayloadFile(t)
 	buf, logger := mocks.NewLoggerMock()
@@ -143,6 +227,95 @@ func TestSendEmailThrottlingRestore(t *testing.T) {
 	)
 }
 
+func TestSendEmailOutcomeBySMTPCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		status        string
+		markerCleared bool
+	}{
+		{"rejected recipient", &smtp.CommandError{Command: "RCPT", Err: &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}}, outbox.StatusFailed, true},
+		{"connection lost before DATA", &smtp.CommandError{Command: "MAIL", Err: io.EOF}, outbox.StatusFailed, true},
+		{"rejected message", &smtp.CommandError{Command: "DATA", Err: &textproto.Error{Code: 554, Msg: "5.7.1 Message rejected"}}, outbox.StatusFailed, true},
+		{"connection lost during DATA", &smtp.CommandError{Command: "DATA", Err: io.ErrUnexpectedEOF}, outbox.StatusProcessing, false},
+		{"error on QUIT", &smtp.CommandError{Command: "QUIT", Err: io.EOF}, outbox.StatusSent, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloadFile := createPayloadFile(t)
+			outboxServiceMock := mocks.NewOutboxMock(
+				mocks.Email(outbox.Email{Id: "1", Status: "", PayloadFilePath: payloadFile}),
+			)
+			sender := NewMainSenderPipeline(outboxServiceMock, newSenderMock(tt.err), "/base/path/", PoolConfig{}, EnvelopeSenderConfig{})
+			_, sender.logger = mocks.NewLoggerMock()
+
+			sender.Process(context.TODO())
+
+			assert.Equal(t, tt.status, outboxServiceMock.LastStatuses()["1"])
+			if tt.markerCleared {
+				assert.Equal(t, 1, outboxServiceMock.ClearSendMarkerCalls())
+			} else {
+				assert.Equal(t, 0, outboxServiceMock.ClearSendMarkerCalls())
+			}
+		})
+	}
+}
+
+func TestSendEmailDuplicateIdempotencyKey(t *testing.T) {
+	payloadFile := createPayloadFile(t)
+	buf, logger := mocks.NewLoggerMock()
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: "1", Status: "", PayloadFilePath: payloadFile}),
+		mocks.MarkSendingMethodError(outbox.ErrDuplicateSend),
+	)
+	senderServiceMock := newSenderMock(nil)
+	sender := MainSenderPipeline{outbox: outboxServiceMock, client: senderServiceMock, attachmentsBasePath: "/base/path/", logger: logger}
+
+	sender.Process(context.TODO())
+
+	assert.Equal(t, 0, senderServiceMock.sendMethodCounter)
+	assert.Equal(t, "fail", outboxServiceMock.LastMethod())
+	assert.Equal(t, failure.CategoryInternal, outboxServiceMock.Failure().Category)
+	assert.Equal(t,
+		"level=INFO msg=\"processing outbox 1\"\nlevel=ERROR msg=\"refusing to send, idempotency key 550e8400-e29b-41d4-a716-446655440000 already used\" outbox=1",
+		strings.TrimSpace(buf.String()),
+	)
+}
+
+func TestSendEmailCancelledDuringRace(t *testing.T) {
+	payloadFile := createPayloadFile(t)
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: "1", Status: "", PayloadFilePath: payloadFile}),
+		mocks.MarkSendingMethodError(outbox.ErrCancelled),
+	)
+	senderServiceMock := newSenderMock(nil)
+	sender := NewMainSenderPipeline(outboxServiceMock, senderServiceMock, "/base/path/", PoolConfig{}, EnvelopeSenderConfig{})
+	_, sender.logger = mocks.NewLoggerMock()
+
+	sender.Process(context.TODO())
+
+	assert.Equal(t, 0, senderServiceMock.sendMethodCounter)
+	assert.Equal(t, "markSending", outboxServiceMock.LastMethod())
+	assert.Equal(t, outbox.StatusProcessing, outboxServiceMock.LastUpdateStatus())
+}
+
+func TestSendEmailMarkSendingError(t *testing.T) {
+	payloadFile := createPayloadFile(t)
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: "1", Status: "", PayloadFilePath: payloadFile}),
+		mocks.MarkSendingMethodError(errors.New("some marker error")),
+	)
+	senderServiceMock := newSenderMock(nil)
+	sender := NewMainSenderPipeline(outboxServiceMock, senderServiceMock, "/base/path/", PoolConfig{}, EnvelopeSenderConfig{})
+	_, sender.logger = mocks.NewLoggerMock()
+
+	sender.Process(context.TODO())
+
+	assert.Equal(t, 0, senderServiceMock.sendMethodCounter)
+	assert.Equal(t, outbox.StatusReady, outboxServiceMock.LastUpdateFromStatus())
+}
+
 func TestHandleUpdateError(t *testing.T) {
 	payloadFile := createPayloadFile(t)
 	buf, logger := mocks.NewLoggerMock()
@@ -162,3 +335,27 @@ func TestHandleUpdateError(t *testing.T) {
 		strings.TrimSpace(buf.String()),
 	)
 }
+
+func TestSendEmailMetrics(t *testing.T) {
+	payloadFile := createPayloadFile(t)
+	outboxServiceMock := mocks.NewOutboxMock(
+		mocks.Email(outbox.Email{Id: "1", Status: "", PayloadFilePath: payloadFile}),
+	)
+	sender := NewMainSenderPipeline(outboxServiceMock, newSenderMock(&textproto.Error{Code: 550, Msg: "mailbox unavailable"}), "/base/path/", PoolConfig{}, EnvelopeSenderConfig{})
+	_, sender.logger = mocks.NewLoggerMock()
+	processed := metrics.PipelineProcessed.Value("main")
+	failed := metrics.PipelineFailed.Value("main")
+	replies := metrics.SMTPReplies.Value("550")
+
+	sender.Process(context.TODO())
+
+	assert.Equal(t, processed+1, metrics.PipelineProcessed.Value("main"))
+	assert.Equal(t, failed+1, metrics.PipelineFailed.Value("main"))
+	assert.Equal(t, replies+1, metrics.SMTPReplies.Value("550"))
+}
+
+func TestSmtpReplyCode(t *testing.T) {
+	assert.Equal(t, "250", smtpReplyCode(nil))
+	assert.Equal(t, "454", smtpReplyCode(&textproto.Error{Code: 454, Msg: "try again later"}))
+	assert.Equal(t, "none", smtpReplyCode(errors.New("connection refused")))
+}
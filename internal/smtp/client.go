@@ -1,12 +1,17 @@
 package smtp
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
 	"net/mail"
 	"net/smtp"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/tracing"
 )
 
 type Config struct {
@@ -30,8 +35,12 @@ func New(cfg Config) *Client {
 	}
 }
 
-func (c *Client) Send(payload email.Payload, attachmentsBasePath string) error {
-	message, err := c.builder.Build(payload, attachmentsBasePath)
+// Send hands the payload over to the relay with returnPath as envelope sender, the configured From when empty.
+func (c *Client) Send(ctx context.Context, payload email.Payload, returnPath string, attachmentsBasePath string) (err error) {
+	ctx, span := tracing.Start(ctx, "smtp.Send", attribute.String("email.id", payload.Id))
+	defer func() { tracing.End(span, err) }()
+
+	message, err := c.builder.Build(ctx, payload, attachmentsBasePath)
 	if err != nil {
 		return err
 	}
@@ -42,33 +51,41 @@ func (c *Client) Send(payload email.Payload, attachmentsBasePath string) error {
 	}
 
 	server := fmt.Sprintf("%s:%d", c.cfg.Host, c.cfg.Port)
-	client, err := smtp.Dial(server)
+	var client *smtp.Client
+	err = command(ctx, "DIAL", func() error {
+		var dialErr error
+		client, dialErr = smtp.Dial(server)
+		return dialErr
+	})
 	if err != nil {
 		return err
 	}
 
 	defer func() { _ = client.Close() }()
 
-	if err := client.Hello("localhost"); err != nil {
+	if err := command(ctx, "EHLO", func() error { return client.Hello("localhost") }); err != nil {
 		return err
 	}
 
 	if ok, _ := client.Extension("STARTTLS"); ok {
-		if err := client.StartTLS(tlsCfg); err != nil {
+		if err := command(ctx, "STARTTLS", func() error { return client.StartTLS(tlsCfg) }); err != nil {
 			return err
 		}
 	}
 
 	if c.cfg.User != "" {
 		auth := smtp.PlainAuth("", c.cfg.User, c.cfg.Password, c.cfg.Host)
-		if err := client.Auth(auth); err != nil {
+		if err := command(ctx, "AUTH", func() error { return client.Auth(auth) }); err != nil {
 			return err
 		}
 	}
 
-	from, err := mail.ParseAddress(c.cfg.From)
-	if err != nil {
-		return err
+	if returnPath == "" {
+		from, err := mail.ParseAddress(c.cfg.From)
+		if err != nil {
+			return err
+		}
+		returnPath = from.Address
 	}
 
 	to, err := mail.ParseAddress(payload.To)
@@ -76,28 +93,88 @@ func (c *Client) Send(payload email.Payload, attachmentsBasePath string) error {
 		return err
 	}
 
-	if err := client.Mail(from.Address); err != nil {
+	if err := command(ctx, "MAIL", func() error { return client.Mail(returnPath) }); err != nil {
 		return err
 	}
-	if err := client.Rcpt(to.Address); err != nil {
+	if err := command(ctx, "RCPT", func() error { return client.Rcpt(to.Address) }); err != nil {
 		return err
 	}
 
-	writer, err := client.Data()
+	err = command(ctx, "DATA", func() error {
+		writer, dataErr := client.Data()
+		if dataErr != nil {
+			return dataErr
+		}
+		if _, writeErr := writer.Write(message); writeErr != nil {
+			_ = writer.Close()
+			return writeErr
+		}
+		return writer.Close()
+	})
 	if err != nil {
 		return err
 	}
-	if _, err := writer.Write(message); err != nil {
-		_ = writer.Close()
+
+	if err := command(ctx, "QUIT", client.Quit); err != nil {
 		return err
 	}
-	if err := writer.Close(); err != nil {
+
+	return nil
+}
+
+// Probe opens a connection to the relay and runs EHLO, STARTTLS when offered and AUTH when configured,
+// without sending any message. The whole conversation is bounded by the context deadline.
+func (c *Client) Probe(ctx context.Context) error {
+	server := fmt.Sprintf("%s:%d", c.cfg.Host, c.cfg.Port)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
 		return err
 	}
 
-	if err := client.Quit(); err != nil {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, c.cfg.Host)
+	if err != nil {
+		_ = conn.Close()
 		return err
 	}
 
+	defer func() { _ = client.Close() }()
+
+	if err := client.Hello("localhost"); err != nil {
+		return err
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		tlsCfg := &tls.Config{
+			ServerName:         c.cfg.Host,
+			InsecureSkipVerify: c.cfg.AllowInsecureTls,
+		}
+		if err := client.StartTLS(tlsCfg); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.User != "" {
+		auth := smtp.PlainAuth("", c.cfg.User, c.cfg.Password, c.cfg.Host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	return client.Quit()
+}
+
+// command runs a step of the SMTP conversation in its own span, a failure is returned as a CommandError.
+func command(ctx context.Context, name string, fn func() error) error {
+	_, span := tracing.Start(ctx, "smtp."+name, attribute.String("smtp.command", name))
+	err := fn()
+	tracing.End(span, err)
+	if err != nil {
+		return &CommandError{Command: name, Err: err}
+	}
 	return nil
 }
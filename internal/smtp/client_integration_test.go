@@ -3,6 +3,7 @@
 package smtp
 
 import (
+	"context"
 	"os"
 	"strconv"
 	"sync"
@@ -44,7 +45,7 @@ func TestClientSendIntegration(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := client.Send(payload, "")
+			err := client.Send(context.TODO(), payload, "", "")
 			require.NoError(t, err)
 		}()
 	}
@@ -0,0 +1,31 @@
+package smtp
+
+import "fmt"
+
+// AttachmentError reports an attachment of the payload that could not be read or encoded.
+type AttachmentError struct {
+	Path string
+	Err  error
+}
+
+func (e *AttachmentError) Error() string {
+	return fmt.Sprintf("failed to attach %s: %v", e.Path, e.Err)
+}
+
+func (e *AttachmentError) Unwrap() error {
+	return e.Err
+}
+
+// CommandError tells which step of the SMTP conversation failed, its message is the one of the underlying error.
+type CommandError struct {
+	Command string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
@@ -2,6 +2,7 @@ package smtp
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -15,17 +16,22 @@ import (
 	"time"
 
 	"github.com/h2non/filetype"
+	"go.opentelemetry.io/otel/attribute"
 
 	"mailculator-processor/internal/email"
+	"mailculator-processor/internal/tracing"
 )
 
 type MessageBuilder struct{}
 
-func (b *MessageBuilder) Build(payload email.Payload, attachmentsBasePath string) ([]byte, error) {
+func (b *MessageBuilder) Build(ctx context.Context, payload email.Payload, attachmentsBasePath string) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "smtp.MessageBuilder.Build", attribute.Int("email.attachments", len(payload.Attachments)))
+	defer func() { tracing.End(span, err) }()
+
 	msg := &mail.Message{}
 	b.addStandardHeadersToMessage(msg, payload)
 
-	orderedStandardHeaders := []string{"From", "Reply-To", "To", "Date", "Subject", "Content-Type"}
+	orderedStandardHeaders := []string{"From", "Reply-To", "To", "Date", "Message-ID", "Subject", "Content-Type"}
 	var buf bytes.Buffer
 
 	for _, key := range orderedStandardHeaders {
@@ -74,13 +80,13 @@ func (b *MessageBuilder) Build(payload email.Payload, attachmentsBasePath string
 	for _, attachment := range payload.Attachments {
 		fullPath := attachmentsBasePath + attachment.Path
 
-		attachmentData, err := os.ReadFile(fullPath)
+		attachmentData, err := b.readAttachment(ctx, fullPath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read attachment: %w", err)
+			return nil, &AttachmentError{Path: attachment.Path, Err: err}
 		}
 
 		if err = b.writeAttachmentWithName(&buf, payload.Id, fullPath, attachment.Name, attachmentData); err != nil {
-			return nil, err
+			return nil, &AttachmentError{Path: attachment.Path, Err: err}
 		}
 	}
 
@@ -91,6 +97,13 @@ func (b *MessageBuilder) Build(payload email.Payload, attachmentsBasePath string
 	return buf.Bytes(), nil
 }
 
+func (b *MessageBuilder) readAttachment(ctx context.Context, path string) (_ []byte, err error) {
+	_, span := tracing.Start(ctx, "smtp.readAttachment", attribute.String("attachment.path", path))
+	defer func() { tracing.End(span, err) }()
+
+	return os.ReadFile(path)
+}
+
 func (b *MessageBuilder) addStandardHeadersToMessage(msg *mail.Message, data email.Payload) {
 	msg.Header = make(mail.Header)
 	msg.Header["From"] = []string{data.From}
@@ -101,14 +114,26 @@ func (b *MessageBuilder) addStandardHeadersToMessage(msg *mail.Message, data ema
 
 	msg.Header["To"] = []string{data.To}
 	msg.Header["Date"] = []string{time.Now().Format(time.RFC1123Z)}
+	msg.Header["Message-ID"] = []string{messageID(data)}
 	msg.Header["Subject"] = []string{data.Subject}
 	msg.Header["Content-Type"] = []string{fmt.Sprintf("multipart/mixed; boundary=\"%s\"", data.Id)}
 
 	for key, value := range data.CustomHeaders {
+		// a bounce is correlated by the generated Message-ID, the payload cannot replace it
+		if strings.EqualFold(key, "Message-ID") {
+			continue
+		}
 		msg.Header[key] = []string{value}
 	}
 }
 
+// messageID is the Message-ID of an email: the payload id at the domain of the sender,
+// so a bounce returning the original headers can be correlated to the email.
+func messageID(data email.Payload) string {
+	domain := data.From[strings.LastIndex(data.From, "@")+1:]
+	return fmt.Sprintf("<%s@%s>", data.Id, domain)
+}
+
 func (b *MessageBuilder) writePart(multipartWriter *multipart.Writer, contentType, charset, body string) error {
 	headers := textproto.MIMEHeader{
 		"Content-Type":              []string{fmt.Sprintf("%s; %s", contentType, charset)},
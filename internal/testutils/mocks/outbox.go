@@ -2,8 +2,10 @@ package mocks
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
+	"mailculator-processor/internal/failure"
 	"mailculator-processor/internal/outbox"
 )
 
@@ -12,11 +14,27 @@ type OutboxMock struct {
 	updateMethodError     error
 	updateMethodCall      int
 	updateMethodFailsCall int
+	updateLastStatus      string
 	queryStaleMethodError error
 	updateFromMethodError error
 	updateFromMethodCall  int
 	updateFromFailsCall   int
+	updateFromLastStatus  string
+	retryDelays           []time.Duration
+	markSendingError      error
+	hasSendMarker         bool
+	sendMarkers           map[string]string
+	returnPaths           map[string]string
+	clearSendMarkerCalls  int
+	purgeMethodError      error
+	purgedIds             []string
+	movedIds              []string
+	changedIds            map[string]bool
 	email                 outbox.Email
+	emails                []outbox.Email
+	lastStatuses          map[string]string
+	validationErrors      json.RawMessage
+	failure               *failure.Reason
 	lastMethod            string
 }
 
@@ -58,12 +76,60 @@ func UpdateFromMethodFailsCall(updateFromFailsCall int) OutboxMockOptions {
 	}
 }
 
+func MarkSendingMethodError(markSendingError error) OutboxMockOptions {
+	return func(o *OutboxMock) {
+		o.markSendingError = markSendingError
+	}
+}
+
+func HasSendMarker(hasSendMarker bool) OutboxMockOptions {
+	return func(o *OutboxMock) {
+		o.hasSendMarker = hasSendMarker
+	}
+}
+
+// SendMarker records that the email emailId was sent with the idempotency key, for FindBySendMarker.
+func SendMarker(idempotencyKey string, emailId string) OutboxMockOptions {
+	return func(o *OutboxMock) {
+		o.sendMarkers[idempotencyKey] = emailId
+	}
+}
+
+// ReturnPath records that the email emailId was sent with the envelope sender returnPath, for FindByReturnPath.
+func ReturnPath(returnPath string, emailId string) OutboxMockOptions {
+	return func(o *OutboxMock) {
+		o.returnPaths[returnPath] = emailId
+	}
+}
+
+func PurgeMethodError(purgeMethodError error) OutboxMockOptions {
+	return func(o *OutboxMock) {
+		o.purgeMethodError = purgeMethodError
+	}
+}
+
+// ChangedStatus simulates emails whose status changed after the query, so Purge and MoveToArchive leave them untouched.
+func ChangedStatus(ids ...string) OutboxMockOptions {
+	return func(o *OutboxMock) {
+		for _, id := range ids {
+			o.changedIds[id] = true
+		}
+	}
+}
+
 func Email(email outbox.Email) OutboxMockOptions {
 	return func(o *OutboxMock) {
 		o.email = email
 	}
 }
 
+// Emails makes Query and QueryStale return several emails instead of the single Email.
+func Emails(emails ...outbox.Email) OutboxMockOptions {
+	return func(o *OutboxMock) {
+		o.emails = emails
+	}
+}
+
 func NewOutboxMock(opts ...OutboxMockOptions) *OutboxMock {
 	o := &OutboxMock{
 		queryMethodError:      nil,
@@ -75,6 +141,10 @@ func NewOutboxMock(opts ...OutboxMockOptions) *OutboxMock {
 		updateFromMethodCall:  0,
 		updateFromFailsCall:   1,
 		email:                 outbox.Email{},
+		lastStatuses:          make(map[string]string),
+		sendMarkers:           make(map[string]string),
+		returnPaths:           make(map[string]string),
+		changedIds:            make(map[string]bool),
 		lastMethod:            "",
 	}
 	for _, opt := range opts {
@@ -85,20 +155,28 @@ func NewOutboxMock(opts ...OutboxMockOptions) *OutboxMock {
 
 func (m *OutboxMock) Query(ctx context.Context, status string, limit int) ([]outbox.Email, error) {
 	m.lastMethod = "query"
+	if m.emails != nil {
+		return m.emails, m.queryMethodError
+	}
 	return []outbox.Email{m.email}, m.queryMethodError
 }
 
 func (m *OutboxMock) QueryStale(ctx context.Context, status string, olderThan time.Duration, limit int) ([]outbox.Email, error) {
 	m.lastMethod = "queryStale"
+	if m.emails != nil {
+		return m.emails, m.queryStaleMethodError
+	}
 	return []outbox.Email{m.email}, m.queryStaleMethodError
 }
 
 func (m *OutboxMock) Update(ctx context.Context, id string, status string, errorReason string) error {
 	m.lastMethod = "update"
 	m.updateMethodCall++
-	if m.updateMethodCall == m.updateMethodFailsCall {
+	m.updateLastStatus = status
+	if m.updateMethodCall == m.updateMethodFailsCall && m.updateMethodError != nil {
 		return m.updateMethodError
 	}
+	m.lastStatuses[id] = status
 	return nil
 }
 
@@ -113,13 +191,182 @@ func (m *OutboxMock) Ready(ctx context.Context, id string) error {
 
 func (m *OutboxMock) UpdateFrom(ctx context.Context, id string, fromStatus string, toStatus string, errorReason string) error {
 	m.lastMethod = "updateFrom"
+	m.updateFromLastStatus = toStatus
 	m.updateFromMethodCall++
-	if m.updateFromMethodCall == m.updateFromFailsCall {
+	if m.updateFromMethodCall == m.updateFromFailsCall && m.updateFromMethodError != nil {
 		return m.updateFromMethodError
 	}
+	m.lastStatuses[id] = toStatus
 	return nil
 }
 
+func (m *OutboxMock) ScheduleCallbackRetry(ctx context.Context, id string, fromStatus string, toStatus string, delay time.Duration, reason string) error {
+	m.lastMethod = "scheduleCallbackRetry"
+	m.retryDelays = append(m.retryDelays, delay)
+	m.lastStatuses[id] = toStatus
+	return nil
+}
+
+func (m *OutboxMock) Fail(ctx context.Context, id string, status string, reason failure.Reason) error {
+	m.lastMethod = "fail"
+	m.failure = &reason
+	m.updateMethodCall++
+	m.updateLastStatus = status
+	if m.updateMethodCall == m.updateMethodFailsCall && m.updateMethodError != nil {
+		return m.updateMethodError
+	}
+	m.lastStatuses[id] = status
+	return nil
+}
+
+func (m *OutboxMock) FailFrom(ctx context.Context, id string, fromStatus string, status string, reason failure.Reason) error {
+	m.lastMethod = "failFrom"
+	m.failure = &reason
+	m.updateFromMethodCall++
+	m.updateFromLastStatus = status
+	if m.updateFromMethodCall == m.updateFromFailsCall && m.updateFromMethodError != nil {
+		return m.updateFromMethodError
+	}
+	m.lastStatuses[id] = status
+	return nil
+}
+
+func (m *OutboxMock) MarkInvalid(ctx context.Context, id string, reason failure.Reason, validationErrors json.RawMessage) error {
+	m.lastMethod = "markInvalid"
+	m.failure = &reason
+	m.validationErrors = validationErrors
+	m.updateMethodCall++
+	m.updateLastStatus = outbox.StatusInvalid
+	if m.updateMethodCall == m.updateMethodFailsCall && m.updateMethodError != nil {
+		return m.updateMethodError
+	}
+	m.lastStatuses[id] = outbox.StatusInvalid
+	return nil
+}
+
+func (m *OutboxMock) MarkSending(ctx context.Context, id string, idempotencyKey string, returnPath string) error {
+	m.lastMethod = "markSending"
+	if m.markSendingError == nil {
+		m.returnPaths[returnPath] = id
+	}
+	return m.markSendingError
+}
+
+func (m *OutboxMock) ClearSendMarker(ctx context.Context, id string) error {
+	m.lastMethod = "clearSendMarker"
+	m.clearSendMarkerCalls++
+	return nil
+}
+
+func (m *OutboxMock) HasSendMarker(ctx context.Context, id string) (bool, error) {
+	m.lastMethod = "hasSendMarker"
+	return m.hasSendMarker, nil
+}
+
+func (m *OutboxMock) FindBySendMarker(ctx context.Context, idempotencyKey string) (string, error) {
+	m.lastMethod = "findBySendMarker"
+	emailId, found := m.sendMarkers[idempotencyKey]
+	if !found {
+		return "", outbox.ErrNotFound
+	}
+	return emailId, nil
+}
+
+func (m *OutboxMock) FindByReturnPath(ctx context.Context, returnPath string) (string, error) {
+	m.lastMethod = "findByReturnPath"
+	emailId, found := m.returnPaths[returnPath]
+	if !found {
+		return "", outbox.ErrNotFound
+	}
+	return emailId, nil
+}
+
+// Get returns the Email when id matches it, ErrNotFound otherwise.
+func (m *OutboxMock) Get(ctx context.Context, id string) (outbox.Email, error) {
+	m.lastMethod = "get"
+	if m.email.Id != id {
+		return outbox.Email{}, outbox.ErrNotFound
+	}
+	return m.email, nil
+}
+
+func (m *OutboxMock) History(ctx context.Context, id string) ([]outbox.StatusChange, error) {
+	m.lastMethod = "history"
+	return []outbox.StatusChange{{Status: m.email.Status, Reason: m.email.Reason, CreatedAt: m.email.UpdatedAt}}, nil
+}
+
+func (m *OutboxMock) Purge(ctx context.Context, status string, ids []string) ([]string, error) {
+	m.lastMethod = "purge"
+	if m.purgeMethodError != nil {
+		return nil, m.purgeMethodError
+	}
+	purged := m.unchanged(ids)
+	m.purgedIds = append(m.purgedIds, purged...)
+	return purged, nil
+}
+
+func (m *OutboxMock) MoveToArchive(ctx context.Context, status string, ids []string) ([]string, error) {
+	m.lastMethod = "moveToArchive"
+	moved := m.unchanged(ids)
+	m.movedIds = append(m.movedIds, moved...)
+	return moved, nil
+}
+
+func (m *OutboxMock) unchanged(ids []string) []string {
+	var unchanged []string
+	for _, id := range ids {
+		if !m.changedIds[id] {
+			unchanged = append(unchanged, id)
+		}
+	}
+	return unchanged
+}
+
+func (m *OutboxMock) MovedIds() []string {
+	return m.movedIds
+}
+
+func (m *OutboxMock) PurgedIds() []string {
+	return m.purgedIds
+}
+
+// LastStatuses is the last status each email was successfully moved to.
+func (m *OutboxMock) LastStatuses() map[string]string {
+	return m.lastStatuses
+}
+
+// ReturnPaths maps the envelope senders stored by MarkSending to their email ids.
+func (m *OutboxMock) ReturnPaths() map[string]string {
+	return m.returnPaths
+}
+
+// ValidationErrors is what the last MarkInvalid stored.
+func (m *OutboxMock) ValidationErrors() json.RawMessage {
+	return m.validationErrors
+}
+
+// Failure is what the last Fail or MarkInvalid stored.
+func (m *OutboxMock) Failure() *failure.Reason {
+	return m.failure
+}
+
 func (m *OutboxMock) LastMethod() string {
 	return m.lastMethod
 }
+
+func (m *OutboxMock) LastUpdateStatus() string {
+	return m.updateLastStatus
+}
+
+func (m *OutboxMock) LastUpdateFromStatus() string {
+	return m.updateFromLastStatus
+}
+
+// RetryDelays returns the delays of the scheduled callback retries.
+func (m *OutboxMock) RetryDelays() []time.Duration {
+	return m.retryDelays
+}
+
+func (m *OutboxMock) ClearSendMarkerCalls() int {
+	return m.clearSendMarkerCalls
+}
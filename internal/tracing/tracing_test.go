@@ -0,0 +1,78 @@
+//go:build unit
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup_WithStdoutExporter_ShouldExportSpansOnShutdown(t *testing.T) {
+	var buf bytes.Buffer
+	shutdown, err := Setup(context.TODO(), Config{Exporter: ExporterStdout, Writer: &buf})
+	require.NoError(t, err)
+
+	ctx, parent := Start(context.TODO(), "parent")
+	_, child := Start(ctx, "child", attribute.String("email.id", "1"))
+	End(child, errors.New("some error"))
+	End(parent, nil)
+
+	require.NoError(t, shutdown(context.TODO()))
+
+	out := buf.String()
+	assert.Contains(t, out, `"Name":"child"`)
+	assert.Contains(t, out, `"Name":"parent"`)
+	assert.Contains(t, out, `"Description":"some error"`)
+	assert.Contains(t, out, `"Value":"mailculator-processor"`)
+}
+
+func TestSetup_WhenExporterUnknown_ShouldReturnError(t *testing.T) {
+	_, err := Setup(context.TODO(), Config{Exporter: "jaeger"})
+
+	assert.ErrorContains(t, err, "unknown tracing exporter")
+}
+
+func TestSetup_WhenDisabled_ShouldInstallPropagator(t *testing.T) {
+	shutdown, err := Setup(context.TODO(), Config{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.TODO()))
+
+	producer := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	carrier := propagation.MapCarrier{}
+
+	Inject(trace.ContextWithSpanContext(context.TODO(), producer), carrier)
+
+	assert.Equal(t, "00-01000000000000000000000000000000-0200000000000000-01", carrier.Get("traceparent"))
+}
+
+type linkRecorder struct {
+	trace.Span
+	links []trace.Link
+}
+
+func (s *linkRecorder) AddLink(link trace.Link) {
+	s.links = append(s.links, link)
+}
+
+func TestLinkTraceParent(t *testing.T) {
+	span := &linkRecorder{Span: trace.SpanFromContext(context.TODO())}
+
+	assert.False(t, LinkTraceParent(span, ""))
+	assert.False(t, LinkTraceParent(span, "not-a-traceparent"))
+	assert.True(t, LinkTraceParent(span, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+
+	require.Len(t, span.links, 1)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.links[0].SpanContext.TraceID().String())
+}
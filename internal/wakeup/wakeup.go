@@ -0,0 +1,168 @@
+package wakeup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	messagePrefix  = "mailculator-wakeup:"
+	maxMessageSize = 128
+)
+
+type Config struct {
+	// ListenAddr is the UDP address receiving wakeups from the other replicas, empty disables it
+	ListenAddr string
+	// Peers are the UDP addresses of the other replicas
+	Peers []string
+}
+
+// Hub wakes the pipelines subscribed to a status as soon as an email enters it.
+// Notifications are delivered in-process and forwarded to the configured peers over UDP.
+// Wakeups are best effort: the timer poll of each pipeline remains the fallback.
+type Hub struct {
+	cfg         Config
+	mu          sync.Mutex
+	subscribers map[string][]chan struct{}
+	conn        net.PacketConn
+	peerAddrs   []net.Addr
+	logger      *slog.Logger
+}
+
+func NewHub(cfg Config) *Hub {
+	return &Hub{
+		cfg:         cfg,
+		subscribers: make(map[string][]chan struct{}),
+		logger:      slog.With("component", "wakeup"),
+	}
+}
+
+// Subscribe returns a channel receiving a value when an email enters the status.
+// Notifications arriving before the previous one was consumed are coalesced.
+func (h *Hub) Subscribe(status string) <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	h.subscribers[status] = append(h.subscribers[status], ch)
+	return ch
+}
+
+// Notify wakes the local subscribers of the status and forwards the wakeup to the peers.
+// Replicas run the same pipelines, so statuses without local subscribers are not forwarded.
+func (h *Hub) Notify(status string) {
+	if h.wake(status) {
+		h.forward(status)
+	}
+}
+
+// wake reports whether the status has subscribers.
+func (h *Hub) wake(status string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, ch := range h.subscribers[status] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+
+	return len(h.subscribers[status]) > 0
+}
+
+func (h *Hub) forward(status string) {
+	if len(h.cfg.Peers) == 0 {
+		return
+	}
+
+	conn, addrs, err := h.peerConn()
+	if err != nil {
+		h.logger.Warn(fmt.Sprintf("failed to prepare wakeup peers: %v", err))
+		return
+	}
+
+	msg := []byte(messagePrefix + status)
+	for _, addr := range addrs {
+		if _, err := conn.WriteTo(msg, addr); err != nil {
+			h.logger.Warn(fmt.Sprintf("failed to send wakeup to %v: %v", addr, err))
+		}
+	}
+}
+
+// peerConn lazily opens the sending socket and resolves the peers, retrying on the next call after a failure.
+func (h *Hub) peerConn() (net.PacketConn, []net.Addr, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil {
+		return h.conn, h.peerAddrs, nil
+	}
+
+	addrs := make([]net.Addr, 0, len(h.cfg.Peers))
+	for _, peer := range h.cfg.Peers {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return nil, nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.conn = conn
+	h.peerAddrs = addrs
+	return conn, addrs, nil
+}
+
+// Listening reports whether wakeups from the peers are received.
+func (h *Hub) Listening() bool {
+	return h.cfg.ListenAddr != ""
+}
+
+// ListenAndServe receives the wakeups forwarded by the peers until the context is done.
+// Received wakeups are only delivered locally, never forwarded again.
+func (h *Hub) ListenAndServe(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", h.cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("wakeup listener failed: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, _, readErr := conn.ReadFrom(buf)
+		if readErr != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("wakeup listener failed: %w", readErr)
+		}
+
+		if status, found := strings.CutPrefix(string(buf[:n]), messagePrefix); found {
+			_ = h.wake(status)
+		}
+	}
+}
+
+// Close releases the socket used to forward wakeups to the peers.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil {
+		_ = h.conn.Close()
+		h.conn = nil
+	}
+}
@@ -0,0 +1,65 @@
+//go:build unit
+
+package wakeup
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyWakesSubscribersOfStatus(t *testing.T) {
+	hub := NewHub(Config{})
+	ready := hub.Subscribe("READY")
+	sent := hub.Subscribe("SENT")
+
+	hub.Notify("READY")
+	hub.Notify("READY")
+
+	assert.Len(t, ready, 1, "notifications should be coalesced")
+	assert.Len(t, sent, 0)
+}
+
+func TestNotifyIsForwardedToPeers(t *testing.T) {
+	addr := freeUDPAddr(t)
+	receiver := NewHub(Config{ListenAddr: addr})
+	ready := receiver.Subscribe("READY")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- receiver.ListenAndServe(ctx)
+	}()
+
+	sender := NewHub(Config{Peers: []string{addr}})
+	defer sender.Close()
+	sender.Subscribe("READY")
+
+	// the listener may not be bound yet, keep notifying until the wakeup arrives
+	require.Eventually(t, func() bool {
+		sender.Notify("READY")
+		select {
+		case <-ready:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func freeUDPAddr(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	return conn.LocalAddr().String()
+}
@@ -0,0 +1,134 @@
+// Package callbacksig signs the callback requests of the processor and verifies them on the receiver side.
+//
+// Each request carries two headers:
+//
+//	X-Mailculator-Timestamp: 1735725600
+//	X-Mailculator-Signature: v1=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd,v1=...
+//
+// Every v1 entry is the hex HMAC-SHA256 of "<timestamp>.<body>" with one of the active secrets, so a
+// secret can be rotated by adding the new one on both sides before removing the old one.
+package callbacksig
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-Mailculator-Timestamp"
+	SignatureHeader = "X-Mailculator-Signature"
+	// Version prefixes each signature of the header, so the scheme can change without breaking receivers
+	Version = "v1"
+	// DefaultTolerance is the maximum age of a request accepted by Verify when no tolerance is given
+	DefaultTolerance = 5 * time.Minute
+)
+
+var (
+	ErrMissingSignature = errors.New("missing signature headers")
+	ErrInvalidTimestamp = errors.New("invalid signature timestamp")
+	ErrExpired          = errors.New("signature timestamp outside the tolerance")
+	ErrMismatch         = errors.New("no signature matches the secrets")
+)
+
+// Compute returns the hex HMAC-SHA256 of "<timestamp>.<body>".
+func Compute(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signer adds the signature headers to outgoing requests, with one signature per active secret.
+type Signer struct {
+	secrets [][]byte
+}
+
+func NewSigner(secrets ...string) *Signer {
+	s := &Signer{secrets: make([][]byte, 0, len(secrets))}
+	for _, secret := range secrets {
+		s.secrets = append(s.secrets, []byte(secret))
+	}
+	return s
+}
+
+// Sign sets the timestamp and signature headers for body, signed at now.
+func (s *Signer) Sign(header http.Header, body []byte, now time.Time) {
+	timestamp := now.Unix()
+
+	signatures := make([]string, 0, len(s.secrets))
+	for _, secret := range s.secrets {
+		signatures = append(signatures, Version+"="+Compute(secret, timestamp, body))
+	}
+
+	header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	header.Set(SignatureHeader, strings.Join(signatures, ","))
+}
+
+// Verify checks that one of the signatures in header matches body with one of the secrets and that the
+// timestamp is within tolerance of now. A zero tolerance means DefaultTolerance.
+func Verify(header http.Header, body []byte, secrets []string, tolerance time.Duration, now time.Time) error {
+	rawTimestamp := header.Get(TimestampHeader)
+	rawSignatures := header.Get(SignatureHeader)
+	if rawTimestamp == "" || rawSignatures == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTimestamp, rawTimestamp)
+	}
+
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return ErrExpired
+	}
+
+	for _, entry := range strings.Split(rawSignatures, ",") {
+		version, signature, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || version != Version {
+			continue
+		}
+
+		given, err := hex.DecodeString(signature)
+		if err != nil {
+			continue
+		}
+
+		for _, secret := range secrets {
+			expected, _ := hex.DecodeString(Compute([]byte(secret), timestamp, body))
+			if hmac.Equal(given, expected) {
+				return nil
+			}
+		}
+	}
+
+	return ErrMismatch
+}
+
+// VerifyRequest reads and verifies the body of r, which is restored so the handler can read it again.
+func VerifyRequest(r *http.Request, secrets []string, tolerance time.Duration) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	_ = r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := Verify(r.Header, body, secrets, tolerance, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
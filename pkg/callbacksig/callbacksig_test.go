@@ -0,0 +1,91 @@
+//go:build unit
+
+package callbacksig
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var signedAt = time.Unix(1735725600, 0)
+
+const body = `{"code":"TRAVELING"}`
+
+func TestCompute(t *testing.T) {
+	// echo -n '1735725600.{"code":"TRAVELING"}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "85ad5c0fe19163ab5aa25a9825c70471e898b824d0954e6783a52a2946d238c3", Compute([]byte("secret"), 1735725600, []byte(body)))
+}
+
+func TestSignerSetsHeaders(t *testing.T) {
+	header := http.Header{}
+	NewSigner("secret", "next-secret").Sign(header, []byte(body), signedAt)
+
+	assert.Equal(t, "1735725600", header.Get(TimestampHeader))
+	assert.Equal(t,
+		"v1=85ad5c0fe19163ab5aa25a9825c70471e898b824d0954e6783a52a2946d238c3,v1="+Compute([]byte("next-secret"), 1735725600, []byte(body)),
+		header.Get(SignatureHeader),
+	)
+}
+
+func TestVerify(t *testing.T) {
+	header := http.Header{}
+	NewSigner("old-secret", "new-secret").Sign(header, []byte(body), signedAt)
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		body    string
+		secrets []string
+		now     time.Time
+		wantErr error
+	}{
+		{"valid", header, body, []string{"new-secret"}, signedAt.Add(time.Minute), nil},
+		{"rotated secret", header, body, []string{"old-secret"}, signedAt, nil},
+		{"wrong secret", header, body, []string{"other"}, signedAt, ErrMismatch},
+		{"tampered body", header, `{"code":"DISPATCH-ERROR"}`, []string{"new-secret"}, signedAt, ErrMismatch},
+		{"expired", header, body, []string{"new-secret"}, signedAt.Add(DefaultTolerance + time.Second), ErrExpired},
+		{"from the future", header, body, []string{"new-secret"}, signedAt.Add(-DefaultTolerance - time.Second), ErrExpired},
+		{"missing headers", http.Header{}, body, []string{"new-secret"}, signedAt, ErrMissingSignature},
+		{"invalid timestamp", http.Header{TimestampHeader: {"yesterday"}, SignatureHeader: {"v1=00"}}, body, []string{"new-secret"}, signedAt, ErrInvalidTimestamp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.header, []byte(tt.body), tt.secrets, 0, tt.now)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIgnoresUnknownVersions(t *testing.T) {
+	header := http.Header{
+		TimestampHeader: {"1735725600"},
+		SignatureHeader: {"v0=85ad5c0fe19163ab5aa25a9825c70471e898b824d0954e6783a52a2946d238c3"},
+	}
+
+	assert.ErrorIs(t, Verify(header, []byte(body), []string{"secret"}, 0, signedAt), ErrMismatch)
+}
+
+func TestVerifyRequestRestoresBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(body))
+	NewSigner("secret").Sign(req.Header, []byte(body), time.Now())
+
+	verified, err := VerifyRequest(req, []string{"secret"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(verified))
+
+	again, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(again))
+}